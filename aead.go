@@ -18,6 +18,37 @@ const (
 	tagPadding        = 4 << 4
 )
 
+// Tweak domains for the constructions other than Deoxys-II.
+// The first byte of the tweak always holds the domain.
+// Deoxys-II only uses first bytes whose high nibble is 0, 1, 2, 4, 6 or 8-f,
+// so the other constructions take their domains from high nibbles 3 and 7.
+const (
+	domainZMACMask  = 0x30
+	domainZMACHash  = 0x31
+	domainZMACFinal = 0x32 // 0x32-0x35
+
+	domainDRBG          = 0x36
+	domainDRBGUpdateKey = 0x37
+	domainDRBGUpdateV   = 0x38
+
+	domainKDF    = 0x39
+	domainSector = 0x3a
+
+	domainWideHash       = 0x3b
+	domainWideHashPadded = 0x3c
+	domainWideFinal      = 0x3d
+	domainWideBlock      = 0x3e
+	domainWideStream     = 0x3f
+
+	domainIDFeistel = 0x70
+	domainIDBlock   = 0x71
+
+	domainFPE       = 0x72
+	domainFPEExpand = 0x73
+
+	domainHeaderProtection = 0x74
+)
+
 const padByte byte = 0x80
 
 const (
@@ -28,11 +59,10 @@ const (
 )
 
 // AEAD implements the Deoxys-II authenticated encryption mode
-// with Deoxys-BC as the underlying tweakable block cipher
+// with Deoxys-BC as the underlying tweakable block cipher.
+// Seal and Open may be called concurrently, but Reset may not.
 type AEAD struct {
-	state   [16]uint8
-	subkey  [numRounds][16]uint8
-	counter [16]uint8
+	subkey [numRounds][16]uint8
 }
 
 func New(key []byte) *AEAD {
@@ -55,54 +85,55 @@ func (m *AEAD) Overhead() int {
 
 // Seal encrypts and authenticates the plaintext
 func (m *AEAD) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
+	var counter [16]uint8
 	tmp := make([]byte, 16)
 	auth := make([]byte, TagSize)
 
 	// hash the message and additional data
 	// to get the auth tag
-	m.hash(tagAdditionalData, additionalData, tmp, auth)
-	m.hash(tagMessage, plaintext, tmp, auth)
+	m.hash(&counter, tagAdditionalData, additionalData, tmp, auth)
+	m.hash(&counter, tagMessage, plaintext, tmp, auth)
 
 	// encrypt the auth with the nonce as tweak to get the final tag
-	m.counter[0] = tagNonce
-	copy(m.counter[1:], nonce)
-	m.encrypt(auth, auth)
+	counter[0] = tagNonce
+	copy(counter[1:], nonce)
+	m.encrypt(&counter, auth, auth)
 
 	// encrypt the message
 	// using the auth tag as an IV
-	copy(m.counter[0:], auth)
-	m.counter[0] |= 0x80
+	copy(counter[0:], auth)
+	counter[0] |= 0x80
 	p := plaintext
 	var nonce0 = make([]byte, 16)
 	copy(nonce0[1:], nonce)
 	var i int64
 	for i = 0; len(p) >= 16; i++ {
-		m.counter[8] = auth[8] ^ uint8(i>>56)
-		m.counter[9] = auth[9] ^ uint8(i>>48)
-		m.counter[10] = auth[10] ^ uint8(i>>40)
-		m.counter[11] = auth[11] ^ uint8(i>>32)
-		m.counter[12] = auth[12] ^ uint8(i>>24)
-		m.counter[13] = auth[13] ^ uint8(i>>16)
-		m.counter[14] = auth[14] ^ uint8(i>>8)
-		m.counter[15] = auth[15] ^ uint8(i)
+		counter[8] = auth[8] ^ uint8(i>>56)
+		counter[9] = auth[9] ^ uint8(i>>48)
+		counter[10] = auth[10] ^ uint8(i>>40)
+		counter[11] = auth[11] ^ uint8(i>>32)
+		counter[12] = auth[12] ^ uint8(i>>24)
+		counter[13] = auth[13] ^ uint8(i>>16)
+		counter[14] = auth[14] ^ uint8(i>>8)
+		counter[15] = auth[15] ^ uint8(i)
 
-		m.encrypt(nonce0, tmp)
+		m.encrypt(&counter, nonce0, tmp)
 
 		xor(tmp, p[:16])
 		p = p[16:]
 		dst = append(dst, tmp...)
 	}
 	if len(p) > 0 {
-		m.counter[8] = auth[8] ^ uint8(i>>56)
-		m.counter[9] = auth[9] ^ uint8(i>>48)
-		m.counter[10] = auth[10] ^ uint8(i>>40)
-		m.counter[11] = auth[11] ^ uint8(i>>32)
-		m.counter[12] = auth[12] ^ uint8(i>>24)
-		m.counter[13] = auth[13] ^ uint8(i>>16)
-		m.counter[14] = auth[14] ^ uint8(i>>8)
-		m.counter[15] = auth[15] ^ uint8(i)
-
-		m.encrypt(nonce0, tmp)
+		counter[8] = auth[8] ^ uint8(i>>56)
+		counter[9] = auth[9] ^ uint8(i>>48)
+		counter[10] = auth[10] ^ uint8(i>>40)
+		counter[11] = auth[11] ^ uint8(i>>32)
+		counter[12] = auth[12] ^ uint8(i>>24)
+		counter[13] = auth[13] ^ uint8(i>>16)
+		counter[14] = auth[14] ^ uint8(i>>8)
+		counter[15] = auth[15] ^ uint8(i)
+
+		m.encrypt(&counter, nonce0, tmp)
 		xor(tmp, p)
 		dst = append(dst, tmp[:len(p)]...)
 	}
@@ -115,6 +146,7 @@ func (m *AEAD) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
 
 // Open authenticates the ciphertext and additional data and returns the decrypted plaintext.
 func (m *AEAD) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	var counter [16]uint8
 	tmp := make([]byte, 16)
 	auth := make([]byte, TagSize)
 
@@ -129,51 +161,51 @@ func (m *AEAD) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, erro
 
 	// decrypt
 	// using the auth tag as an IV
-	copy(m.counter[0:], tag)
-	m.counter[0] |= 0x80
+	copy(counter[0:], tag)
+	counter[0] |= 0x80
 	p := ciphertext
 	var nonce0 = make([]byte, 16)
 	copy(nonce0[1:], nonce)
 	var i uint64
 	for i = 0; len(p) >= blockSize; i++ {
-		m.counter[8] = tag[8] ^ uint8(i>>56)
-		m.counter[9] = tag[9] ^ uint8(i>>48)
-		m.counter[10] = tag[10] ^ uint8(i>>40)
-		m.counter[11] = tag[11] ^ uint8(i>>32)
-		m.counter[12] = tag[12] ^ uint8(i>>24)
-		m.counter[13] = tag[13] ^ uint8(i>>16)
-		m.counter[14] = tag[14] ^ uint8(i>>8)
-		m.counter[15] = tag[15] ^ uint8(i)
-
-		m.encrypt(nonce0, tmp)
+		counter[8] = tag[8] ^ uint8(i>>56)
+		counter[9] = tag[9] ^ uint8(i>>48)
+		counter[10] = tag[10] ^ uint8(i>>40)
+		counter[11] = tag[11] ^ uint8(i>>32)
+		counter[12] = tag[12] ^ uint8(i>>24)
+		counter[13] = tag[13] ^ uint8(i>>16)
+		counter[14] = tag[14] ^ uint8(i>>8)
+		counter[15] = tag[15] ^ uint8(i)
+
+		m.encrypt(&counter, nonce0, tmp)
 		xor(tmp, p[:blockSize])
 		p = p[blockSize:]
 		dst = append(dst, tmp...)
 	}
 	if len(p) > 0 {
-		m.counter[8] = tag[8] ^ uint8(i>>56)
-		m.counter[9] = tag[9] ^ uint8(i>>48)
-		m.counter[10] = tag[10] ^ uint8(i>>40)
-		m.counter[11] = tag[11] ^ uint8(i>>32)
-		m.counter[12] = tag[12] ^ uint8(i>>24)
-		m.counter[13] = tag[13] ^ uint8(i>>16)
-		m.counter[14] = tag[14] ^ uint8(i>>8)
-		m.counter[15] = tag[15] ^ uint8(i)
-
-		m.encrypt(nonce0, tmp)
+		counter[8] = tag[8] ^ uint8(i>>56)
+		counter[9] = tag[9] ^ uint8(i>>48)
+		counter[10] = tag[10] ^ uint8(i>>40)
+		counter[11] = tag[11] ^ uint8(i>>32)
+		counter[12] = tag[12] ^ uint8(i>>24)
+		counter[13] = tag[13] ^ uint8(i>>16)
+		counter[14] = tag[14] ^ uint8(i>>8)
+		counter[15] = tag[15] ^ uint8(i)
+
+		m.encrypt(&counter, nonce0, tmp)
 		xor(tmp, p)
 		dst = append(dst, tmp[:len(p)]...)
 	}
 
 	// hash the message and additional data
 	// to get the auth tag
-	m.hash(tagAdditionalData, additionalData, tmp, auth)
-	m.hash(tagMessage, dst[origLen:], tmp, auth)
+	m.hash(&counter, tagAdditionalData, additionalData, tmp, auth)
+	m.hash(&counter, tagMessage, dst[origLen:], tmp, auth)
 
 	// encrypt the auth with the nonce as tweak to get the final tag
-	m.counter[0] = tagNonce
-	copy(m.counter[1:], nonce)
-	m.encrypt(auth, auth)
+	counter[0] = tagNonce
+	copy(counter[1:], nonce)
+	m.encrypt(&counter, auth, auth)
 
 	if subtle.ConstantTimeCompare(auth, tag) == 0 {
 		return dst, errors.New("Open: invalid tag")
@@ -182,37 +214,37 @@ func (m *AEAD) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, erro
 	return dst, nil
 }
 
-func (m *AEAD) encrypt(in, out []byte) {
-	encryptBlock(m.subkey[:], m.counter[:], in, out)
+func (m *AEAD) encrypt(counter *[16]uint8, in, out []byte) {
+	encryptBlock(m.subkey[:], counter[:], in, out)
 }
 
-func (m *AEAD) hash(tag uint8, data, tmp, auth []byte) {
-	for i := range m.counter {
-		m.counter[i] = 0
+func (m *AEAD) hash(counter *[16]uint8, tag uint8, data, tmp, auth []byte) {
+	for i := range counter {
+		counter[i] = 0
 	}
-	m.counter[0] = tag
+	counter[0] = tag
 	for len(data) >= 16 {
-		m.encrypt(data[:16], tmp)
+		m.encrypt(counter, data[:16], tmp)
 		data = data[16:]
 		xor(auth, tmp)
-		m.inc()
+		inc(counter)
 	}
 	if len(data) > 0 {
-		m.counter[0] |= tagPadding
+		counter[0] |= tagPadding
 		for i := range tmp {
 			tmp[i] = 0
 		}
 		n := copy(tmp, data)
 		tmp[n] = padByte
-		m.encrypt(tmp, tmp)
+		m.encrypt(counter, tmp, tmp)
 		xor(auth, tmp)
 	}
 }
 
-func (m *AEAD) inc() {
-	for i := len(m.counter) - 1; i >= 0; i-- {
-		m.counter[i]++
-		if m.counter[i] != 0 {
+func inc(counter *[16]uint8) {
+	for i := len(counter) - 1; i >= 0; i-- {
+		counter[i]++
+		if counter[i] != 0 {
 			return
 		}
 	}
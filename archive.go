@@ -0,0 +1,362 @@
+package deoxys
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/fs"
+	"time"
+)
+
+// An archive holds a sequence of files, each encrypted as a separate
+// stream, followed by an encrypted index and a trailer:
+//
+//	header || member 0 || member 1 || ... || index || index offset (8 bytes, big-endian)
+//
+// The header is magic "DXAR" || version (1 byte) || archive ID (16 bytes).
+// The archive ID is random, and the streams are sealed with a key derived
+// from the caller's key and the archive ID, so nonces only need to be
+// unique within an archive. Member i is a stream with the nonce prefix
+// 0 || i (8 bytes, big-endian) || 0, and the index is a stream with the
+// nonce prefix 1 || 0 (9 bytes). All of them use the header as
+// additional data.
+//
+// The index lists, for each member, its name, mode, modification time,
+// size, and the offset and length of its stream. Because the index is
+// authenticated, and each member's nonce prefix includes its position,
+// members cannot be swapped, renamed or moved between archives. Only the
+// index offset is unauthenticated: a wrong offset makes the index fail
+// to decrypt.
+const (
+	archiveMagic   = "DXAR"
+	archiveVersion = 1
+	archiveIDSize  = 16
+	archiveHdrSize = len(archiveMagic) + 1 + archiveIDSize
+)
+
+var archiveKeyLabel = []byte("deoxys archive v1")
+
+// An ArchiveHeader describes a member of an archive.
+type ArchiveHeader struct {
+	Name    string // at most 65535 bytes
+	Mode    fs.FileMode
+	ModTime time.Time // stored with one-second precision
+	Size    int64     // size of the contents; set by ArchiveWriter
+
+	offset int64 // offset of the stream
+	length int64 // length of the stream
+}
+
+func newArchiveAEAD(key, header []byte) (*AEAD, error) {
+	if len(key) != 16 {
+		return nil, errors.New("wrong size key")
+	}
+	id := header[len(archiveMagic)+1:]
+	return New(DeriveKey(key, archiveKeyLabel, id, 16)), nil
+}
+
+func archiveNoncePrefix(index bool, member int) []byte {
+	prefix := make([]byte, StreamNoncePrefixSize)
+	if index {
+		prefix[0] = 1
+	} else {
+		binary.BigEndian.PutUint64(prefix[1:], uint64(member))
+	}
+	return prefix
+}
+
+// countingWriter counts the bytes written to w.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// ArchiveWriter writes an encrypted archive.
+// Callers must call Close to write the index.
+type ArchiveWriter struct {
+	w       countingWriter
+	aead    *AEAD
+	header  []byte
+	members []ArchiveHeader
+	names   map[string]bool
+	cur     *archiveMemberWriter
+	err     error // sticky error
+	closed  bool
+}
+
+type archiveMemberWriter struct {
+	a  *ArchiveWriter
+	sw *StreamWriter
+	n  int64
+}
+
+func (m *archiveMemberWriter) Write(p []byte) (int, error) {
+	if m.a.cur != m {
+		return 0, errors.New("ArchiveWriter: write to a finished member")
+	}
+	if m.a.err != nil {
+		return 0, m.a.err
+	}
+	n, err := m.sw.Write(p)
+	m.n += int64(n)
+	if err != nil {
+		m.a.err = err
+	}
+	return n, err
+}
+
+// NewArchiveWriter writes the archive header to w and returns
+// an ArchiveWriter that encrypts members with the 16-byte key.
+func NewArchiveWriter(w io.Writer, key []byte) (*ArchiveWriter, error) {
+	header := make([]byte, archiveHdrSize)
+	copy(header, archiveMagic)
+	header[len(archiveMagic)] = archiveVersion
+	if _, err := rand.Read(header[len(archiveMagic)+1:]); err != nil {
+		return nil, err
+	}
+	aead, err := newArchiveAEAD(key, header)
+	if err != nil {
+		return nil, errors.New("NewArchiveWriter: " + err.Error())
+	}
+	a := &ArchiveWriter{
+		w:      countingWriter{w: w},
+		aead:   aead,
+		header: header,
+		names:  make(map[string]bool),
+	}
+	if _, err := a.w.Write(header); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Create adds a member described by h and returns a Writer for its
+// contents, which is valid until the next call to Create or Close.
+// The Size field of h is ignored. Names must be unique and non-empty.
+func (a *ArchiveWriter) Create(h *ArchiveHeader) (io.Writer, error) {
+	if a.closed {
+		return nil, errors.New("ArchiveWriter: create after close")
+	}
+	if h.Name == "" || len(h.Name) > 0xffff {
+		return nil, errors.New("ArchiveWriter: invalid member name")
+	}
+	if a.names[h.Name] {
+		return nil, errors.New("ArchiveWriter: duplicate member name " + h.Name)
+	}
+	if err := a.finish(); err != nil {
+		return nil, err
+	}
+	m := *h
+	m.Size = 0
+	m.offset = a.w.n
+	sw, err := NewStreamWriter(&a.w, a.aead, archiveNoncePrefix(false, len(a.members)), a.header)
+	if err != nil {
+		return nil, err
+	}
+	a.names[m.Name] = true
+	a.members = append(a.members, m)
+	a.cur = &archiveMemberWriter{a: a, sw: sw}
+	return a.cur, nil
+}
+
+// finish closes the current member's stream and records its size.
+func (a *ArchiveWriter) finish() error {
+	if a.err != nil {
+		return a.err
+	}
+	if a.cur == nil {
+		return nil
+	}
+	cur := a.cur
+	a.cur = nil
+	if err := cur.sw.Close(); err != nil {
+		a.err = err
+		return err
+	}
+	m := &a.members[len(a.members)-1]
+	m.Size = cur.n
+	m.length = a.w.n - m.offset
+	return nil
+}
+
+// Close finishes the last member and writes the index and trailer.
+// It does not close the underlying writer.
+func (a *ArchiveWriter) Close() error {
+	if a.closed {
+		return a.err
+	}
+	a.closed = true
+	if err := a.finish(); err != nil {
+		return err
+	}
+	offset := a.w.n
+	sw, err := NewStreamWriter(&a.w, a.aead, archiveNoncePrefix(true, 0), a.header)
+	if err != nil {
+		return err
+	}
+	if _, err := sw.Write(marshalArchiveIndex(a.members)); err != nil {
+		a.err = err
+		return err
+	}
+	if err := sw.Close(); err != nil {
+		a.err = err
+		return err
+	}
+	var trailer [8]byte
+	binary.BigEndian.PutUint64(trailer[:], uint64(offset))
+	if _, err := a.w.Write(trailer[:]); err != nil {
+		a.err = err
+		return err
+	}
+	return nil
+}
+
+// The index is a 4-byte big-endian count followed by, for each member,
+//
+//	name length (2 bytes) || name || mode (4 bytes) || mtime (8 bytes, Unix seconds) ||
+//	size (8 bytes) || offset (8 bytes) || length (8 bytes)
+//
+// with every integer big-endian.
+func marshalArchiveIndex(members []ArchiveHeader) []byte {
+	b := binary.BigEndian.AppendUint32(nil, uint32(len(members)))
+	for _, m := range members {
+		b = binary.BigEndian.AppendUint16(b, uint16(len(m.Name)))
+		b = append(b, m.Name...)
+		b = binary.BigEndian.AppendUint32(b, uint32(m.Mode))
+		b = binary.BigEndian.AppendUint64(b, uint64(m.ModTime.Unix()))
+		b = binary.BigEndian.AppendUint64(b, uint64(m.Size))
+		b = binary.BigEndian.AppendUint64(b, uint64(m.offset))
+		b = binary.BigEndian.AppendUint64(b, uint64(m.length))
+	}
+	return b
+}
+
+func unmarshalArchiveIndex(b []byte, indexOffset int64) ([]ArchiveHeader, error) {
+	errMalformed := errors.New("ArchiveReader: malformed index")
+	if len(b) < 4 {
+		return nil, errMalformed
+	}
+	n := binary.BigEndian.Uint32(b)
+	b = b[4:]
+	const fixedSize = 2 + 4 + 8 + 8 + 8 + 8
+	if uint64(n) > uint64(len(b)/fixedSize) {
+		return nil, errMalformed
+	}
+	members := make([]ArchiveHeader, n)
+	for i := range members {
+		if len(b) < 2 {
+			return nil, errMalformed
+		}
+		l := int(binary.BigEndian.Uint16(b))
+		if len(b) < fixedSize+l {
+			return nil, errMalformed
+		}
+		m := &members[i]
+		m.Name = string(b[2 : 2+l])
+		b = b[2+l:]
+		m.Mode = fs.FileMode(binary.BigEndian.Uint32(b))
+		m.ModTime = time.Unix(int64(binary.BigEndian.Uint64(b[4:])), 0)
+		m.Size = int64(binary.BigEndian.Uint64(b[12:]))
+		m.offset = int64(binary.BigEndian.Uint64(b[20:]))
+		m.length = int64(binary.BigEndian.Uint64(b[28:]))
+		b = b[36:]
+		// The index is authenticated, so this only guards
+		// against archives written with a buggy writer.
+		if m.offset < int64(archiveHdrSize) || m.length < 0 || m.offset > indexOffset-m.length {
+			return nil, errMalformed
+		}
+	}
+	if len(b) != 0 {
+		return nil, errMalformed
+	}
+	return members, nil
+}
+
+// ArchiveReader reads an encrypted archive.
+// It is safe for concurrent use, so members can be extracted in parallel,
+// but each Reader returned by Open or OpenMember must be read by
+// one goroutine at a time.
+type ArchiveReader struct {
+	r       io.ReaderAt
+	aead    *AEAD
+	header  []byte
+	members []ArchiveHeader
+}
+
+// NewArchiveReader reads and authenticates the index of the archive
+// in r, which is size bytes long. It returns ErrStreamInvalid if the
+// index fails authentication, as it does with the wrong key.
+func NewArchiveReader(r io.ReaderAt, size int64, key []byte) (*ArchiveReader, error) {
+	errFormat := errors.New("NewArchiveReader: not an archive")
+	if size < int64(archiveHdrSize)+8 {
+		return nil, errFormat
+	}
+	header := make([]byte, archiveHdrSize)
+	if _, err := r.ReadAt(header, 0); err != nil {
+		return nil, err
+	}
+	if string(header[:len(archiveMagic)]) != archiveMagic {
+		return nil, errFormat
+	}
+	if header[len(archiveMagic)] != archiveVersion {
+		return nil, errors.New("NewArchiveReader: unsupported version")
+	}
+	aead, err := newArchiveAEAD(key, header)
+	if err != nil {
+		return nil, errors.New("NewArchiveReader: " + err.Error())
+	}
+	var trailer [8]byte
+	if _, err := r.ReadAt(trailer[:], size-8); err != nil {
+		return nil, err
+	}
+	offset := binary.BigEndian.Uint64(trailer[:])
+	if offset < uint64(archiveHdrSize) || offset > uint64(size-8) {
+		return nil, ErrStreamInvalid
+	}
+	sr, err := NewStreamReader(io.NewSectionReader(r, int64(offset), size-8-int64(offset)), aead, archiveNoncePrefix(true, 0), header)
+	if err != nil {
+		return nil, err
+	}
+	var index bytes.Buffer
+	if _, err := index.ReadFrom(sr); err != nil {
+		return nil, err
+	}
+	members, err := unmarshalArchiveIndex(index.Bytes(), int64(offset))
+	if err != nil {
+		return nil, err
+	}
+	return &ArchiveReader{r: r, aead: aead, header: header, members: members}, nil
+}
+
+// Members returns the headers of the archive's members, in order.
+func (a *ArchiveReader) Members() []ArchiveHeader {
+	return append([]ArchiveHeader(nil), a.members...)
+}
+
+// Open returns a Reader for the contents of the member with the given name.
+// The Reader returns ErrStreamInvalid if the contents fail authentication.
+func (a *ArchiveReader) Open(name string) (io.Reader, error) {
+	for i := range a.members {
+		if a.members[i].Name == name {
+			return a.OpenMember(i)
+		}
+	}
+	return nil, errors.New("ArchiveReader: no member named " + name)
+}
+
+// OpenMember returns a Reader for the contents of the i'th member.
+func (a *ArchiveReader) OpenMember(i int) (io.Reader, error) {
+	if i < 0 || i >= len(a.members) {
+		return nil, errors.New("ArchiveReader: member index out of range")
+	}
+	m := &a.members[i]
+	return NewStreamReader(io.NewSectionReader(a.r, m.offset, m.length), a.aead, archiveNoncePrefix(false, i), a.header)
+}
@@ -0,0 +1,203 @@
+package deoxys
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"sync"
+	"testing"
+	"time"
+)
+
+type archiveFile struct {
+	name string
+	data []byte
+}
+
+var archiveFiles = []archiveFile{
+	{"hello.txt", []byte("Hello, world!\n")},
+	{"empty", nil},
+	{"dir/chunk", bytes.Repeat([]byte{'c'}, StreamChunkSize)},
+	{"dir/big", bytes.Repeat([]byte("A witty saying means nothing. "), 5000)},
+}
+
+func writeTestArchive(t *testing.T, key []byte, files []archiveFile) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	a, err := NewArchiveWriter(&buf, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, f := range files {
+		w, err := a.Create(&ArchiveHeader{
+			Name:    f.name,
+			Mode:    0640,
+			ModTime: time.Unix(1700000000+int64(i), 0),
+			Size:    12345, // ignored
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(f.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestArchiveRoundTrip(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	data := writeTestArchive(t, key, archiveFiles)
+	a, err := NewArchiveReader(bytes.NewReader(data), int64(len(data)), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	members := a.Members()
+	if len(members) != len(archiveFiles) {
+		t.Fatalf("got %d members, want %d", len(members), len(archiveFiles))
+	}
+	for i, m := range members {
+		f := archiveFiles[i]
+		if m.Name != f.name || m.Size != int64(len(f.data)) || m.Mode != 0640 || !m.ModTime.Equal(time.Unix(1700000000+int64(i), 0)) {
+			t.Errorf("member %d = %+v", i, m)
+		}
+	}
+	// Extract in reverse order, to check random access.
+	for i := len(archiveFiles) - 1; i >= 0; i-- {
+		f := archiveFiles[i]
+		r, err := a.Open(f.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(r)
+		if err != nil || !bytes.Equal(got, f.data) {
+			t.Errorf("%s: read %d bytes, %v; want %d bytes", f.name, len(got), err, len(f.data))
+		}
+	}
+	if _, err := a.Open("missing"); err == nil {
+		t.Errorf("Open of a missing member succeeded")
+	}
+	if _, err := a.OpenMember(len(archiveFiles)); err == nil {
+		t.Errorf("OpenMember out of range succeeded")
+	}
+}
+
+func TestArchiveConcurrent(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	data := writeTestArchive(t, key, archiveFiles)
+	a, err := NewArchiveReader(bytes.NewReader(data), int64(len(data)), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i, f := range archiveFiles {
+		wg.Add(1)
+		go func(i int, f archiveFile) {
+			defer wg.Done()
+			r, err := a.OpenMember(i)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			got, err := io.ReadAll(r)
+			if err != nil || !bytes.Equal(got, f.data) {
+				t.Errorf("%s: read %d bytes, %v; want %d bytes", f.name, len(got), err, len(f.data))
+			}
+		}(i, f)
+	}
+	wg.Wait()
+}
+
+func TestArchiveEmpty(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	data := writeTestArchive(t, key, nil)
+	a, err := NewArchiveReader(bytes.NewReader(data), int64(len(data)), key)
+	if err != nil || len(a.Members()) != 0 {
+		t.Errorf("NewArchiveReader = %v, %v", a, err)
+	}
+}
+
+// readArchive reads every member of an archive, returning the first error.
+func readArchive(data, key []byte) error {
+	a, err := NewArchiveReader(bytes.NewReader(data), int64(len(data)), key)
+	if err != nil {
+		return err
+	}
+	for i := range a.Members() {
+		r, err := a.OpenMember(i)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(io.Discard, r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestArchiveTampering(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	files := []archiveFile{{"a", []byte("first")}, {"b", []byte("second")}, {"c", nil}}
+	data := writeTestArchive(t, key, files)
+
+	if err := readArchive(data, []byte("fedcba9876543210")); err != ErrStreamInvalid {
+		t.Errorf("wrong key: got %v, want ErrStreamInvalid", err)
+	}
+	for i := range data {
+		data[i] ^= 1
+		if err := readArchive(data, key); err == nil {
+			t.Errorf("byte %d modified: archive read successfully", i)
+		}
+		data[i] ^= 1
+	}
+	for n := 0; n < len(data); n++ {
+		if err := readArchive(data[:n], key); err == nil {
+			t.Errorf("truncated to %d bytes: archive read successfully", n)
+		}
+	}
+
+	// Members can't be moved between archives, even at the same offset.
+	other := writeTestArchive(t, key, files)
+	a, _ := NewArchiveReader(bytes.NewReader(data), int64(len(data)), key)
+	m := a.members[1]
+	mixed := append([]byte(nil), data...)
+	copy(mixed[m.offset:m.offset+m.length], other[m.offset:])
+	if err := readArchive(mixed, key); err != ErrStreamInvalid {
+		t.Errorf("member from another archive: got %v, want ErrStreamInvalid", err)
+	}
+}
+
+func TestArchiveWriterErrors(t *testing.T) {
+	if _, err := NewArchiveWriter(io.Discard, make([]byte, 15)); err == nil {
+		t.Errorf("NewArchiveWriter accepted a short key")
+	}
+	a, err := NewArchiveWriter(io.Discard, make([]byte, 16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Create(&ArchiveHeader{}); err == nil {
+		t.Errorf("Create accepted an empty name")
+	}
+	first, err := a.Create(&ArchiveHeader{Name: "x", Mode: fs.ModePerm})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Create(&ArchiveHeader{Name: "x"}); err == nil {
+		t.Errorf("Create accepted a duplicate name")
+	}
+	if _, err := a.Create(&ArchiveHeader{Name: "y"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := first.Write([]byte("late")); err == nil {
+		t.Errorf("Write to a finished member succeeded")
+	}
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Create(&ArchiveHeader{Name: "z"}); err == nil {
+		t.Errorf("Create after Close succeeded")
+	}
+}
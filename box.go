@@ -0,0 +1,63 @@
+package deoxys
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+)
+
+// SealRandom encrypts and authenticates plaintext with a 16-byte key
+// and a fresh random nonce, and returns the nonce followed by the ciphertext.
+//
+// Random 120-bit nonces are safe for far more messages than
+// any key should encrypt, and even a repeated nonce only reveals
+// whether two messages are equal, since Deoxys-II is misuse resistant.
+func SealRandom(key, plaintext, additionalData []byte) ([]byte, error) {
+	out := make([]byte, NonceSize, NonceSize+len(plaintext)+TagSize)
+	if _, err := rand.Read(out); err != nil {
+		return nil, err
+	}
+	return New(key).Seal(out, out[:NonceSize], plaintext, additionalData), nil
+}
+
+// OpenRandom decrypts a message sealed by SealRandom.
+func OpenRandom(key, box, additionalData []byte) ([]byte, error) {
+	if len(box) < NonceSize+TagSize {
+		return nil, errors.New("OpenRandom: message too short")
+	}
+	return New(key).Open(nil, box[:NonceSize], box[NonceSize:], additionalData)
+}
+
+// SealCounter encrypts and authenticates plaintext with a 16-byte key,
+// using a message counter as the nonce, and returns the nonce
+// followed by the ciphertext.
+//
+// The caller is responsible for persisting the counter and
+// never reusing a value with the same key; a key should be used
+// either with SealCounter or with SealRandom, but not both.
+func SealCounter(key []byte, counter uint64, plaintext, additionalData []byte) []byte {
+	out := make([]byte, NonceSize, NonceSize+len(plaintext)+TagSize)
+	binary.BigEndian.PutUint64(out[NonceSize-8:], counter)
+	return New(key).Seal(out, out[:NonceSize], plaintext, additionalData)
+}
+
+// OpenCounter decrypts a message sealed by SealCounter
+// and returns the plaintext and the counter it was sealed with.
+// Callers that need replay protection should check that
+// the counter is greater than the last one they accepted.
+func OpenCounter(key, box, additionalData []byte) ([]byte, uint64, error) {
+	if len(box) < NonceSize+TagSize {
+		return nil, 0, errors.New("OpenCounter: message too short")
+	}
+	for _, b := range box[:NonceSize-8] {
+		if b != 0 {
+			return nil, 0, errors.New("OpenCounter: not a counter nonce")
+		}
+	}
+	counter := binary.BigEndian.Uint64(box[NonceSize-8 : NonceSize])
+	plaintext, err := New(key).Open(nil, box[:NonceSize], box[NonceSize:], additionalData)
+	if err != nil {
+		return nil, 0, err
+	}
+	return plaintext, counter, nil
+}
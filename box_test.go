@@ -0,0 +1,69 @@
+package deoxys
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSealRandom(t *testing.T) {
+	key := []byte("16-byte password")
+	msg := []byte("A witty saying means nothing.")
+	b0, err := SealRandom(key, msg, []byte("ad"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b0) != NonceSize+len(msg)+TagSize {
+		t.Errorf("SealRandom returned %d bytes, want %d", len(b0), NonceSize+len(msg)+TagSize)
+	}
+	b1, _ := SealRandom(key, msg, []byte("ad"))
+	if bytes.Equal(b0[:NonceSize], b1[:NonceSize]) {
+		t.Errorf("SealRandom used the same nonce twice")
+	}
+
+	for _, b := range [][]byte{b0, b1} {
+		pt, err := OpenRandom(key, b, []byte("ad"))
+		if err != nil || !bytes.Equal(pt, msg) {
+			t.Errorf("OpenRandom = %q, %v; want %q", pt, err, msg)
+		}
+	}
+	if _, err := OpenRandom(key, b0, nil); err == nil {
+		t.Errorf("OpenRandom with the wrong additional data succeeded")
+	}
+	b0[0] ^= 1
+	if _, err := OpenRandom(key, b0, []byte("ad")); err == nil {
+		t.Errorf("OpenRandom with a modified nonce succeeded")
+	}
+	if _, err := OpenRandom(key, b0[:NonceSize+TagSize-1], nil); err == nil {
+		t.Errorf("OpenRandom of a short message succeeded")
+	}
+}
+
+func TestSealCounter(t *testing.T) {
+	key := []byte("16-byte password")
+	msg := []byte("A witty saying means nothing.")
+	for _, c := range []uint64{0, 1, 1<<64 - 1} {
+		b := SealCounter(key, c, msg, nil)
+		want := New(key).Seal(nil, b[:NonceSize], msg, nil)
+		if !bytes.Equal(b[NonceSize:], want) {
+			t.Errorf("SealCounter(%d) = %x, want %x", c, b[NonceSize:], want)
+		}
+		pt, got, err := OpenCounter(key, b, nil)
+		if err != nil || got != c || !bytes.Equal(pt, msg) {
+			t.Errorf("OpenCounter = %q, %d, %v; want %q, %d", pt, got, err, msg, c)
+		}
+	}
+
+	b := SealCounter(key, 1, msg, nil)
+	if bytes.Equal(b, SealCounter(key, 2, msg, nil)) {
+		t.Errorf("SealCounter ignores the counter")
+	}
+	b[0] = 1
+	if _, _, err := OpenCounter(key, b, nil); err == nil {
+		t.Errorf("OpenCounter accepted a non-counter nonce")
+	}
+	r, _ := SealRandom(key, msg, nil)
+	r[0] = 0
+	if _, _, err := OpenCounter(key, r, nil); err == nil {
+		t.Errorf("OpenCounter accepted a random nonce")
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/magical/deoxys"
+)
+
+func (e *env) archive(args []string) error {
+	if len(args) == 0 {
+		usage(e.stderr)
+		return errUsage
+	}
+	switch args[0] {
+	case "create":
+		return e.archiveCreate(args[1:])
+	case "list":
+		return e.archiveList(args[1:])
+	case "extract":
+		return e.archiveExtract(args[1:])
+	}
+	fmt.Fprintf(e.stderr, "deoxys: unknown archive command %q\n", args[0])
+	usage(e.stderr)
+	return errUsage
+}
+
+func (e *env) archiveCreate(args []string) error {
+	fs := e.flags("archive create")
+	kf := addKeyFlags(fs)
+	out := fs.String("o", "", "write the archive to `file` instead of stdout")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	if fs.NArg() == 0 {
+		fmt.Fprintln(e.stderr, "deoxys: no files to archive")
+		return errUsage
+	}
+	key, err := e.loadKey(kf)
+	if err != nil {
+		return err
+	}
+	w, finish, err := e.output(*out)
+	if err != nil {
+		return err
+	}
+	var self os.FileInfo
+	if f, ok := w.(*os.File); ok {
+		self, _ = f.Stat()
+	}
+	return finish(e.createArchive(w, key, fs.Args(), self))
+}
+
+// createArchive writes an archive of the files under paths to w,
+// skipping the file described by self, which is the archive itself.
+func (e *env) createArchive(w io.Writer, key []byte, paths []string, self os.FileInfo) error {
+	a, err := deoxys.NewArchiveWriter(w, key)
+	if err != nil {
+		return err
+	}
+	for _, root := range paths {
+		err := filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			if !d.Type().IsRegular() {
+				fmt.Fprintf(e.stderr, "deoxys: skipping %s: not a regular file\n", path)
+				return nil
+			}
+			if self != nil {
+				if fi, err := d.Info(); err == nil && os.SameFile(fi, self) {
+					return nil
+				}
+			}
+			return addFile(a, path)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return a.Close()
+}
+
+// archiveName returns the name under which path is stored:
+// a relative, slash-separated path without leading slashes.
+func archiveName(path string) (string, error) {
+	name := strings.TrimLeft(filepath.ToSlash(filepath.Clean(path)), "/")
+	if !filepath.IsLocal(filepath.FromSlash(name)) {
+		return "", fmt.Errorf("cannot archive %s: path leaves the current directory", path)
+	}
+	return name, nil
+}
+
+func addFile(a *deoxys.ArchiveWriter, path string) error {
+	name, err := archiveName(path)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	w, err := a.Create(&deoxys.ArchiveHeader{
+		Name:    name,
+		Mode:    fi.Mode().Perm(),
+		ModTime: fi.ModTime(),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// openArchive opens and reads the index of the archive named by the -i flag.
+func (e *env) openArchive(name string, kf keyFlags) (*deoxys.ArchiveReader, func(), error) {
+	if name == "" {
+		fmt.Fprintln(e.stderr, "deoxys: an archive is required (-i)")
+		return nil, nil, errUsage
+	}
+	key, err := e.loadKey(kf)
+	if err != nil {
+		return nil, nil, err
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	a, err := deoxys.NewArchiveReader(f, fi.Size(), key)
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return a, func() { f.Close() }, nil
+}
+
+func (e *env) archiveList(args []string) error {
+	fs := e.flags("archive list")
+	kf := addKeyFlags(fs)
+	in := fs.String("i", "", "read the archive from `file`")
+	if err := e.parse(fs, args); err != nil {
+		return err
+	}
+	a, closeIn, err := e.openArchive(*in, kf)
+	if err != nil {
+		return err
+	}
+	defer closeIn()
+	for _, m := range a.Members() {
+		_, err := fmt.Fprintf(e.stdout, "%v %12d %s %s\n", m.Mode, m.Size, m.ModTime.UTC().Format("2006-01-02 15:04"), m.Name)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *env) archiveExtract(args []string) error {
+	fs := e.flags("archive extract")
+	kf := addKeyFlags(fs)
+	in := fs.String("i", "", "read the archive from `file`")
+	dir := fs.String("C", ".", "extract into `dir`")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	a, closeIn, err := e.openArchive(*in, kf)
+	if err != nil {
+		return err
+	}
+	defer closeIn()
+
+	wanted := make(map[string]bool)
+	for _, name := range fs.Args() {
+		wanted[name] = true
+	}
+	for i, m := range a.Members() {
+		if len(wanted) > 0 {
+			if !wanted[m.Name] {
+				continue
+			}
+			delete(wanted, m.Name)
+		}
+		if err := extractFile(a, i, m, *dir); err != nil {
+			return err
+		}
+	}
+	for name := range wanted {
+		return fmt.Errorf("%s: not found in archive", name)
+	}
+	return nil
+}
+
+func extractFile(a *deoxys.ArchiveReader, i int, m deoxys.ArchiveHeader, dir string) error {
+	rel := filepath.FromSlash(m.Name)
+	if !filepath.IsLocal(rel) {
+		return fmt.Errorf("refusing to extract %q: path leaves the target directory", m.Name)
+	}
+	path := filepath.Join(dir, rel)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	r, err := a.OpenMember(i)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, m.Mode.Perm())
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		// Don't leave unauthenticated data behind.
+		os.Remove(path)
+		return err
+	}
+	return os.Chtimes(path, m.ModTime, m.ModTime)
+}
@@ -0,0 +1,393 @@
+// Command deoxys encrypts and decrypts files with Deoxys-II.
+//
+// Usage:
+//
+//	deoxys keygen [-o keyfile]
+//	deoxys encrypt (-k keyfile | -key-env VAR) [-i input] [-o output]
+//	deoxys decrypt (-k keyfile | -key-env VAR) [-i input] [-o output]
+//	deoxys verify (-k keyfile | -key-env VAR) [-i input]
+//	deoxys archive create (-k keyfile | -key-env VAR) [-o output] path...
+//	deoxys archive list (-k keyfile | -key-env VAR) -i archive
+//	deoxys archive extract (-k keyfile | -key-env VAR) -i archive [-C dir] [name...]
+//	deoxys bench [-size bytes] [-time duration]
+//
+// A key is 16 bytes written as 32 hexadecimal digits.
+// Input and output default to stdin and stdout, except that
+// archives must be read from a file.
+//
+// Encrypted files start with a header of the magic string "deoxys",
+// a version byte and a random nonce prefix, followed by the data
+// sealed in chunks as described in the deoxys package.
+// The header is authenticated along with every chunk.
+//
+// The exit status is 0 on success, 1 on errors, 2 on usage errors
+// and 3 if the input fails authentication.
+// Decrypt writes plaintext as it is authenticated, chunk by chunk;
+// if a later chunk fails, it removes the output file,
+// but data already written to stdout cannot be taken back.
+//
+// The archive commands use the archive format of the deoxys package,
+// where every file is encrypted separately and an encrypted index
+// allows extracting files without reading the whole archive.
+// Create adds the regular files named on the command line and
+// those found in directories, recursively. Extract writes files
+// under the current directory, or -C dir; it refuses names that would
+// be placed outside it, and does not overwrite existing files.
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/magical/deoxys"
+)
+
+const (
+	magic   = "deoxys"
+	version = 1
+
+	headerSize = len(magic) + 1 + deoxys.StreamNoncePrefixSize
+)
+
+const (
+	exitOK = iota
+	exitError
+	exitUsage
+	exitAuth
+)
+
+var errUsage = errors.New("usage error")
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr, os.Getenv))
+}
+
+type env struct {
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+	getenv func(string) string
+}
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer, getenv func(string) string) int {
+	e := &env{stdin, stdout, stderr, getenv}
+	if len(args) == 0 {
+		usage(stderr)
+		return exitUsage
+	}
+	var err error
+	switch args[0] {
+	case "keygen":
+		err = e.keygen(args[1:])
+	case "encrypt":
+		err = e.encrypt(args[1:])
+	case "decrypt":
+		err = e.decrypt(args[1:], false)
+	case "verify":
+		err = e.decrypt(args[1:], true)
+	case "archive":
+		err = e.archive(args[1:])
+	case "bench":
+		err = e.bench(args[1:])
+	case "help", "-h", "-help", "--help":
+		usage(stdout)
+		return exitOK
+	default:
+		fmt.Fprintf(stderr, "deoxys: unknown command %q\n", args[0])
+		usage(stderr)
+		return exitUsage
+	}
+	switch {
+	case err == nil:
+		return exitOK
+	case err == errUsage:
+		return exitUsage
+	case err == deoxys.ErrStreamInvalid:
+		fmt.Fprintln(stderr, "deoxys: authentication failed")
+		return exitAuth
+	default:
+		fmt.Fprintf(stderr, "deoxys: %v\n", err)
+		return exitError
+	}
+}
+
+func usage(w io.Writer) {
+	fmt.Fprint(w, `usage:
+	deoxys keygen [-o keyfile]
+	deoxys encrypt (-k keyfile | -key-env VAR) [-i input] [-o output]
+	deoxys decrypt (-k keyfile | -key-env VAR) [-i input] [-o output]
+	deoxys verify (-k keyfile | -key-env VAR) [-i input]
+	deoxys archive create (-k keyfile | -key-env VAR) [-o output] path...
+	deoxys archive list (-k keyfile | -key-env VAR) -i archive
+	deoxys archive extract (-k keyfile | -key-env VAR) -i archive [-C dir] [name...]
+	deoxys bench [-size bytes] [-time duration]
+`)
+}
+
+func (e *env) flags(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet("deoxys "+name, flag.ContinueOnError)
+	fs.SetOutput(e.stderr)
+	return fs
+}
+
+func (e *env) parse(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintf(e.stderr, "deoxys: unexpected argument %q\n", fs.Arg(0))
+		return errUsage
+	}
+	return nil
+}
+
+func (e *env) keygen(args []string) error {
+	fs := e.flags("keygen")
+	out := fs.String("o", "", "write the key to `file` instead of stdout")
+	if err := e.parse(fs, args); err != nil {
+		return err
+	}
+	key := make([]byte, 16)
+	if _, err := rand.Read(key); err != nil {
+		return err
+	}
+	line := hex.EncodeToString(key) + "\n"
+	if *out == "" {
+		_, err := io.WriteString(e.stdout, line)
+		return err
+	}
+	f, err := os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(f, line); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+type keyFlags struct {
+	file   *string
+	envVar *string
+}
+
+func addKeyFlags(fs *flag.FlagSet) keyFlags {
+	return keyFlags{
+		file:   fs.String("k", "", "read the key from `file`"),
+		envVar: fs.String("key-env", "", "read the key from the environment variable `VAR`"),
+	}
+}
+
+func (e *env) loadKey(k keyFlags) ([]byte, error) {
+	var s string
+	switch {
+	case *k.file != "" && *k.envVar != "":
+		fmt.Fprintln(e.stderr, "deoxys: -k and -key-env are mutually exclusive")
+		return nil, errUsage
+	case *k.file != "":
+		b, err := os.ReadFile(*k.file)
+		if err != nil {
+			return nil, err
+		}
+		s = string(b)
+	case *k.envVar != "":
+		s = e.getenv(*k.envVar)
+		if s == "" {
+			return nil, fmt.Errorf("environment variable %s is not set", *k.envVar)
+		}
+	default:
+		fmt.Fprintln(e.stderr, "deoxys: a key is required (-k or -key-env)")
+		return nil, errUsage
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(s))
+	if err != nil || len(key) != 16 {
+		return nil, errors.New("key must be 32 hexadecimal digits")
+	}
+	return key, nil
+}
+
+func (e *env) input(name string) (io.Reader, func(), error) {
+	if name == "" {
+		return e.stdin, func() {}, nil
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	return f, func() { f.Close() }, nil
+}
+
+// output returns a writer for the named file or stdout.
+// The finish function closes the file, and removes it if err is non-nil.
+func (e *env) output(name string) (io.Writer, func(err error) error, error) {
+	if name == "" {
+		return e.stdout, func(err error) error { return err }, nil
+	}
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, nil, err
+	}
+	return f, func(err error) error {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(name)
+		}
+		return err
+	}, nil
+}
+
+func (e *env) encrypt(args []string) error {
+	fs := e.flags("encrypt")
+	kf := addKeyFlags(fs)
+	in := fs.String("i", "", "read plaintext from `file` instead of stdin")
+	out := fs.String("o", "", "write ciphertext to `file` instead of stdout")
+	if err := e.parse(fs, args); err != nil {
+		return err
+	}
+	key, err := e.loadKey(kf)
+	if err != nil {
+		return err
+	}
+	r, closeIn, err := e.input(*in)
+	if err != nil {
+		return err
+	}
+	defer closeIn()
+	w, finish, err := e.output(*out)
+	if err != nil {
+		return err
+	}
+	return finish(encrypt(w, r, key))
+}
+
+func encrypt(w io.Writer, r io.Reader, key []byte) error {
+	header := make([]byte, headerSize)
+	copy(header, magic)
+	header[len(magic)] = version
+	prefix := header[len(magic)+1:]
+	if _, err := rand.Read(prefix); err != nil {
+		return err
+	}
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	sw, err := deoxys.NewStreamWriter(w, deoxys.New(key), prefix, header)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(sw, r); err != nil {
+		return err
+	}
+	return sw.Close()
+}
+
+func (e *env) decrypt(args []string, verifyOnly bool) error {
+	name := "decrypt"
+	if verifyOnly {
+		name = "verify"
+	}
+	fs := e.flags(name)
+	kf := addKeyFlags(fs)
+	in := fs.String("i", "", "read ciphertext from `file` instead of stdin")
+	out := new(string)
+	if !verifyOnly {
+		out = fs.String("o", "", "write plaintext to `file` instead of stdout")
+	}
+	if err := e.parse(fs, args); err != nil {
+		return err
+	}
+	key, err := e.loadKey(kf)
+	if err != nil {
+		return err
+	}
+	r, closeIn, err := e.input(*in)
+	if err != nil {
+		return err
+	}
+	defer closeIn()
+	if verifyOnly {
+		return decrypt(io.Discard, r, key)
+	}
+	w, finish, err := e.output(*out)
+	if err != nil {
+		return err
+	}
+	return finish(decrypt(w, r, key))
+}
+
+func decrypt(w io.Writer, r io.Reader, key []byte) error {
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return errors.New("input is not a deoxys file")
+		}
+		return err
+	}
+	if string(header[:len(magic)]) != magic {
+		return errors.New("input is not a deoxys file")
+	}
+	if header[len(magic)] != version {
+		return fmt.Errorf("unsupported file version %d", header[len(magic)])
+	}
+	prefix := header[len(magic)+1:]
+	sr, err := deoxys.NewStreamReader(r, deoxys.New(key), prefix, header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, sr)
+	return err
+}
+
+func (e *env) bench(args []string) error {
+	fs := e.flags("bench")
+	size := fs.Int("size", 1<<20, "message size in `bytes`")
+	d := fs.Duration("time", time.Second, "how long to run each benchmark")
+	if err := e.parse(fs, args); err != nil {
+		return err
+	}
+	if *size < 0 {
+		fmt.Fprintln(e.stderr, "deoxys: -size must not be negative")
+		return errUsage
+	}
+	key := make([]byte, 16)
+	nonce := make([]byte, deoxys.NonceSize)
+	m := deoxys.New(key)
+	msg := make([]byte, *size)
+	ct := m.Seal(nil, nonce, msg, nil)
+	buf := make([]byte, 0, len(ct))
+
+	run := func(name string, f func() error) error {
+		var n int
+		start := time.Now()
+		for time.Since(start) < *d {
+			if err := f(); err != nil {
+				return err
+			}
+			n++
+		}
+		elapsed := time.Since(start)
+		mb := float64(n) * float64(*size) / (1 << 20)
+		fmt.Fprintf(e.stdout, "%-5s %10d bytes  %8d ops  %10.2f MiB/s\n", name, *size, n, mb/elapsed.Seconds())
+		return nil
+	}
+	if err := run("seal", func() error {
+		m.Seal(buf[:0], nonce, msg, nil)
+		return nil
+	}); err != nil {
+		return err
+	}
+	return run("open", func() error {
+		_, err := m.Open(buf[:0], nonce, ct, nil)
+		return err
+	})
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testKey = "000102030405060708090a0b0c0d0e0f"
+
+func testEnv(name string) string {
+	switch name {
+	case "DEOXYS_KEY":
+		return testKey
+	case "OTHER_KEY":
+		return "ffffffffffffffffffffffffffffffff"
+	}
+	return ""
+}
+
+func runCmd(t *testing.T, stdin []byte, args ...string) (int, []byte, string) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	code := run(args, bytes.NewReader(stdin), &stdout, &stderr, testEnv)
+	return code, stdout.Bytes(), stderr.String()
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	msg := bytes.Repeat([]byte("A witty saying means nothing. "), 5000)
+	code, ct, stderr := runCmd(t, msg, "encrypt", "-key-env", "DEOXYS_KEY")
+	if code != exitOK {
+		t.Fatalf("encrypt: exit %d: %s", code, stderr)
+	}
+	if !bytes.HasPrefix(ct, []byte(magic)) {
+		t.Errorf("ciphertext does not start with %q", magic)
+	}
+
+	code, pt, stderr := runCmd(t, ct, "decrypt", "-key-env", "DEOXYS_KEY")
+	if code != exitOK {
+		t.Fatalf("decrypt: exit %d: %s", code, stderr)
+	}
+	if !bytes.Equal(pt, msg) {
+		t.Errorf("decrypt returned a different message")
+	}
+
+	if code, _, stderr := runCmd(t, ct, "verify", "-key-env", "DEOXYS_KEY"); code != exitOK {
+		t.Errorf("verify: exit %d: %s", code, stderr)
+	}
+
+	// Encrypting twice uses a fresh nonce.
+	_, ct2, _ := runCmd(t, msg, "encrypt", "-key-env", "DEOXYS_KEY")
+	if bytes.Equal(ct, ct2) {
+		t.Errorf("encrypting twice produced the same output")
+	}
+}
+
+func TestAuthenticationFailure(t *testing.T) {
+	msg := []byte("attack at dawn")
+	_, ct, _ := runCmd(t, msg, "encrypt", "-key-env", "DEOXYS_KEY")
+
+	tampered := append([]byte(nil), ct...)
+	tampered[len(tampered)-1] ^= 1
+	header := append([]byte(nil), ct...)
+	header[len(magic)+1] ^= 1
+
+	tests := []struct {
+		name string
+		ct   []byte
+		key  string
+	}{
+		{"wrong key", ct, "OTHER_KEY"},
+		{"tampered", tampered, "DEOXYS_KEY"},
+		{"tampered header", header, "DEOXYS_KEY"},
+		{"truncated", ct[:len(ct)-1], "DEOXYS_KEY"},
+		{"header only", ct[:headerSize], "DEOXYS_KEY"},
+	}
+	for _, tt := range tests {
+		for _, cmd := range []string{"decrypt", "verify"} {
+			if code, _, _ := runCmd(t, tt.ct, cmd, "-key-env", tt.key); code != exitAuth {
+				t.Errorf("%s %s: exit %d, want %d", cmd, tt.name, code, exitAuth)
+			}
+		}
+	}
+
+	for _, bad := range [][]byte{nil, []byte("not a deoxys file at all"), append([]byte(magic), 99)} {
+		if code, _, _ := runCmd(t, bad, "decrypt", "-key-env", "DEOXYS_KEY"); code != exitError {
+			t.Errorf("decrypt %q: exit %d, want %d", bad, code, exitError)
+		}
+	}
+}
+
+func TestFiles(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "key")
+	plain := filepath.Join(dir, "plain")
+	sealed := filepath.Join(dir, "sealed")
+	opened := filepath.Join(dir, "opened")
+
+	if code, _, stderr := runCmd(t, nil, "keygen", "-o", keyFile); code != exitOK {
+		t.Fatalf("keygen: exit %d: %s", code, stderr)
+	}
+	if code, _, _ := runCmd(t, nil, "keygen", "-o", keyFile); code != exitError {
+		t.Errorf("keygen overwrote an existing key file")
+	}
+	msg := []byte("A witty saying means nothing.")
+	if err := os.WriteFile(plain, msg, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if code, _, stderr := runCmd(t, nil, "encrypt", "-k", keyFile, "-i", plain, "-o", sealed); code != exitOK {
+		t.Fatalf("encrypt: exit %d: %s", code, stderr)
+	}
+	if code, _, stderr := runCmd(t, nil, "decrypt", "-k", keyFile, "-i", sealed, "-o", opened); code != exitOK {
+		t.Fatalf("decrypt: exit %d: %s", code, stderr)
+	}
+	if b, err := os.ReadFile(opened); err != nil || !bytes.Equal(b, msg) {
+		t.Errorf("decrypted file = %q, %v; want %q", b, err, msg)
+	}
+
+	// A failed decryption leaves no output file behind.
+	os.Remove(opened)
+	if code, _, _ := runCmd(t, nil, "decrypt", "-key-env", "OTHER_KEY", "-i", sealed, "-o", opened); code != exitAuth {
+		t.Errorf("decrypt with the wrong key: exit %d, want %d", code, exitAuth)
+	}
+	if _, err := os.Stat(opened); !os.IsNotExist(err) {
+		t.Errorf("output file exists after failed decryption")
+	}
+}
+
+func TestKeygen(t *testing.T) {
+	code, out, _ := runCmd(t, nil, "keygen")
+	if code != exitOK {
+		t.Fatalf("keygen: exit %d", code)
+	}
+	if s := strings.TrimSpace(string(out)); len(s) != 32 {
+		t.Errorf("keygen printed %q, want 32 hex digits", s)
+	}
+}
+
+func TestUsage(t *testing.T) {
+	for _, args := range [][]string{
+		{},
+		{"frobnicate"},
+		{"encrypt"},
+		{"encrypt", "-k", "a", "-key-env", "DEOXYS_KEY"},
+		{"encrypt", "-key-env", "DEOXYS_KEY", "extra"},
+		{"decrypt", "-nosuchflag"},
+		{"verify", "-key-env", "DEOXYS_KEY", "-o", "out"},
+	} {
+		if code, _, _ := runCmd(t, nil, args...); code != exitUsage {
+			t.Errorf("%q: exit %d, want %d", args, code, exitUsage)
+		}
+	}
+	if code, _, _ := runCmd(t, nil, "encrypt", "-key-env", "UNSET"); code != exitError {
+		t.Errorf("unset key variable: exit %d, want %d", code, exitError)
+	}
+}
+
+func TestBench(t *testing.T) {
+	code, out, stderr := runCmd(t, nil, "bench", "-size", "1024", "-time", "10ms")
+	if code != exitOK {
+		t.Fatalf("bench: exit %d: %s", code, stderr)
+	}
+	if !bytes.Contains(out, []byte("seal")) || !bytes.Contains(out, []byte("open")) {
+		t.Errorf("bench output = %q", out)
+	}
+}
+
+func TestArchive(t *testing.T) {
+	src := t.TempDir()
+	files := map[string]string{
+		"a.txt":     "alpha",
+		"sub/b.txt": "bravo",
+		"sub/empty": "",
+	}
+	for name, data := range files {
+		path := filepath.Join(src, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := os.WriteFile(path, []byte(data), 0640); err != nil {
+			t.Fatal(err)
+		}
+	}
+	archive := filepath.Join(src, "out.dxa")
+
+	// Archive paths are taken relative to the working directory.
+	wd, _ := os.Getwd()
+	if err := os.Chdir(src); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	if code, _, stderr := runCmd(t, nil, "archive", "create", "-key-env", "DEOXYS_KEY", "-o", archive, "."); code != exitOK {
+		t.Fatalf("archive create: exit %d: %s", code, stderr)
+	}
+	code, out, stderr := runCmd(t, nil, "archive", "list", "-key-env", "DEOXYS_KEY", "-i", archive)
+	if code != exitOK {
+		t.Fatalf("archive list: exit %d: %s", code, stderr)
+	}
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	if len(lines) != len(files) {
+		t.Errorf("archive list printed %q, want %d files", out, len(files))
+	}
+	for _, line := range lines {
+		if _, ok := files[line[strings.LastIndex(line, " ")+1:]]; !ok || !strings.HasPrefix(line, "-rw-r-----") {
+			t.Errorf("unexpected list line %q", line)
+		}
+	}
+
+	dst := t.TempDir()
+	if code, _, stderr := runCmd(t, nil, "archive", "extract", "-key-env", "DEOXYS_KEY", "-i", archive, "-C", dst); code != exitOK {
+		t.Fatalf("archive extract: exit %d: %s", code, stderr)
+	}
+	for name, data := range files {
+		got, err := os.ReadFile(filepath.Join(dst, filepath.FromSlash(name)))
+		if err != nil || string(got) != data {
+			t.Errorf("%s = %q, %v; want %q", name, got, err, data)
+		}
+	}
+
+	// Extraction doesn't overwrite files.
+	if code, _, _ := runCmd(t, nil, "archive", "extract", "-key-env", "DEOXYS_KEY", "-i", archive, "-C", dst, "a.txt"); code != exitError {
+		t.Errorf("extract over an existing file: exit %d, want %d", code, exitError)
+	}
+	one := t.TempDir()
+	if code, _, stderr := runCmd(t, nil, "archive", "extract", "-key-env", "DEOXYS_KEY", "-i", archive, "-C", one, "sub/b.txt"); code != exitOK {
+		t.Errorf("extract one file: exit %d: %s", code, stderr)
+	}
+	if entries, _ := os.ReadDir(one); len(entries) != 1 {
+		t.Errorf("extracting one file created %d entries", len(entries))
+	}
+	if code, _, _ := runCmd(t, nil, "archive", "extract", "-key-env", "DEOXYS_KEY", "-i", archive, "-C", one, "missing"); code != exitError {
+		t.Errorf("extract of a missing file: exit %d, want %d", code, exitError)
+	}
+
+	// A damaged member fails authentication and is not left behind.
+	data, _ := os.ReadFile(archive)
+	data[30] ^= 1 // in the first member
+	damaged := filepath.Join(t.TempDir(), "damaged.dxa")
+	os.WriteFile(damaged, data, 0600)
+	bad := t.TempDir()
+	if code, _, _ := runCmd(t, nil, "archive", "extract", "-key-env", "DEOXYS_KEY", "-i", damaged, "-C", bad); code != exitAuth {
+		t.Errorf("extract of a damaged archive: exit %d, want %d", code, exitAuth)
+	}
+	if _, err := os.Stat(filepath.Join(bad, "a.txt")); !os.IsNotExist(err) {
+		t.Errorf("damaged member was extracted")
+	}
+
+	for _, args := range [][]string{
+		{"archive", "list", "-key-env", "OTHER_KEY", "-i", archive},
+		{"archive", "extract", "-key-env", "OTHER_KEY", "-i", archive, "-C", t.TempDir()},
+	} {
+		if code, _, _ := runCmd(t, nil, args...); code != exitAuth {
+			t.Errorf("%q: exit %d, want %d", args, code, exitAuth)
+		}
+	}
+	for _, args := range [][]string{
+		{"archive"},
+		{"archive", "frobnicate"},
+		{"archive", "create", "-key-env", "DEOXYS_KEY"},
+		{"archive", "list", "-key-env", "DEOXYS_KEY"},
+		{"archive", "list", "-key-env", "DEOXYS_KEY", "-i", archive, "extra"},
+	} {
+		if code, _, _ := runCmd(t, nil, args...); code != exitUsage {
+			t.Errorf("%q: exit %d, want %d", args, code, exitUsage)
+		}
+	}
+}
+
+func TestArchiveName(t *testing.T) {
+	for path, want := range map[string]string{
+		"a":          "a",
+		"./a/b":      "a/b",
+		"/abs/path":  "abs/path",
+		"a/../b":     "b",
+		"../outside": "",
+	} {
+		got, err := archiveName(filepath.FromSlash(path))
+		if got != want || (err != nil) != (want == "") {
+			t.Errorf("archiveName(%q) = %q, %v; want %q", path, got, err, want)
+		}
+	}
+}
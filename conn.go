@@ -0,0 +1,384 @@
+package deoxys
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Conn is a record layer over a net.Conn for links that share a 16-byte key.
+//
+// The client and server first exchange hellos carrying 16 random bytes each:
+//
+//	hello = magic "DXCN" || version (1 byte) || random (16 bytes)
+//
+// and derive one key per direction with the KDF, using the two
+// hellos as context. Each side then sends a finished record, an empty
+// record authenticated together with both hellos, to confirm that
+// the peer has the same key. The random values make the keys
+// of every connection different, so records cannot be replayed
+// into another connection.
+//
+// After the handshake, data is sent in records of
+//
+//	type (1 byte) || length (2 bytes, big-endian) || ciphertext
+//
+// sealed with the record header as additional data. The nonce is
+// the record's sequence number in its direction, encoded as
+// 7 zero bytes followed by 8 bytes big-endian. Each side replaces its
+// sending key after every connRekeyInterval records, and resets the
+// sequence number. Close sends a close-notify record, so a reader can
+// tell a clean close from a truncated connection.
+//
+// The key must be high-entropy: the handshake offers no
+// protection against guessing a weak key.
+type Conn struct {
+	conn     net.Conn
+	isClient bool
+	psk      []byte
+
+	handshakeMu   sync.Mutex
+	handshakeErr  error
+	handshakeDone bool
+	// handshakeOK is set once the handshake has succeeded. Close reads
+	// it without handshakeMu, which is held while the handshake blocks.
+	handshakeOK atomic.Bool
+
+	in  halfConn
+	out halfConn
+
+	rawIn    [recordHeaderSize + MaxRecordSize + TagSize]byte
+	inBuf    []byte // decrypted data not yet returned by Read
+	inClosed bool   // close-notify received
+
+	closeOnce sync.Once
+}
+
+const (
+	// MaxRecordSize is the largest amount of data in a record.
+	MaxRecordSize = 16 << 10
+
+	connMagic         = "DXCN"
+	connVersion       = 1
+	connRandomSize    = 16
+	connHelloSize     = len(connMagic) + 1 + connRandomSize
+	connRekeyInterval = 1 << 24
+
+	recordHeaderSize = 3
+
+	recordData        = 0x17
+	recordFinished    = 0x16
+	recordCloseNotify = 0x15
+)
+
+var (
+	connKeyLabel   = []byte("deoxys conn v1")
+	connRekeyLabel = []byte("deoxys conn rekey v1")
+
+	errRecordAuth = errors.New("deoxys: record authentication failed")
+)
+
+// halfConn is one direction of a Conn.
+type halfConn struct {
+	sync.Mutex
+	key        []byte
+	aead       *AEAD
+	seq        uint64
+	rekeyAfter uint64
+	err        error // sticky error
+}
+
+func (h *halfConn) setKey(key []byte) {
+	h.key = key
+	h.aead = New(key)
+	h.seq = 0
+}
+
+func (h *halfConn) nonce() []byte {
+	var nonce [NonceSize]byte
+	binary.BigEndian.PutUint64(nonce[NonceSize-8:], h.seq)
+	return nonce[:]
+}
+
+// advance increments the sequence number,
+// replacing the key every rekeyAfter records.
+func (h *halfConn) advance() {
+	h.seq++
+	if h.seq == h.rekeyAfter {
+		h.setKey(DeriveKey(h.key, connRekeyLabel, nil, 16))
+	}
+}
+
+// Client returns a Conn for the client side of conn.
+// The handshake runs on the first Read or Write, or on a call to Handshake.
+func Client(conn net.Conn, key []byte) *Conn {
+	return newConn(conn, key, true)
+}
+
+// Server returns a Conn for the server side of conn.
+// The handshake runs on the first Read or Write, or on a call to Handshake.
+func Server(conn net.Conn, key []byte) *Conn {
+	return newConn(conn, key, false)
+}
+
+func newConn(conn net.Conn, key []byte, isClient bool) *Conn {
+	if len(key) != 16 {
+		panic("deoxys: wrong size key")
+	}
+	c := &Conn{
+		conn:     conn,
+		isClient: isClient,
+		psk:      append([]byte(nil), key...),
+	}
+	c.in.rekeyAfter = connRekeyInterval
+	c.out.rekeyAfter = connRekeyInterval
+	return c
+}
+
+// Handshake runs the handshake if it has not run yet.
+// It returns an error if the peer does not have the same key.
+func (c *Conn) Handshake() error {
+	c.handshakeMu.Lock()
+	defer c.handshakeMu.Unlock()
+	if !c.handshakeDone {
+		c.handshakeErr = c.handshake()
+		c.handshakeDone = true
+		c.handshakeOK.Store(c.handshakeErr == nil)
+	}
+	return c.handshakeErr
+}
+
+func (c *Conn) handshake() error {
+	hello := make([]byte, connHelloSize)
+	copy(hello, connMagic)
+	hello[len(connMagic)] = connVersion
+	if _, err := rand.Read(hello[len(connMagic)+1:]); err != nil {
+		return err
+	}
+	peer := make([]byte, connHelloSize)
+
+	// The client speaks first, so that the handshake also works
+	// over synchronous transports such as net.Pipe.
+	var transcript []byte
+	if c.isClient {
+		if _, err := c.conn.Write(hello); err != nil {
+			return err
+		}
+		if err := c.readHello(peer); err != nil {
+			return err
+		}
+		transcript = append(hello, peer...)
+		c.setKeys(transcript)
+		if err := c.readFinished(transcript); err != nil {
+			return err
+		}
+		return c.writeRecord(recordFinished, nil, transcript)
+	}
+
+	if err := c.readHello(peer); err != nil {
+		return err
+	}
+	transcript = append(peer, hello...)
+	c.setKeys(transcript)
+	if _, err := c.conn.Write(hello); err != nil {
+		return err
+	}
+	if err := c.writeRecord(recordFinished, nil, transcript); err != nil {
+		return err
+	}
+	return c.readFinished(transcript)
+}
+
+func (c *Conn) readHello(hello []byte) error {
+	if _, err := io.ReadFull(c.conn, hello); err != nil {
+		return err
+	}
+	if string(hello[:len(connMagic)]) != connMagic {
+		return errors.New("deoxys: peer is not speaking the record protocol")
+	}
+	if hello[len(connMagic)] != connVersion {
+		return errors.New("deoxys: unsupported record protocol version")
+	}
+	return nil
+}
+
+func (c *Conn) setKeys(transcript []byte) {
+	keys := DeriveKey(c.psk, connKeyLabel, transcript, 32)
+	clientKey, serverKey := keys[:16], keys[16:]
+	if c.isClient {
+		c.out.setKey(clientKey)
+		c.in.setKey(serverKey)
+	} else {
+		c.out.setKey(serverKey)
+		c.in.setKey(clientKey)
+	}
+}
+
+func (c *Conn) readFinished(transcript []byte) error {
+	typ, data, err := c.readRecord(transcript)
+	if err != nil {
+		return err
+	}
+	if typ != recordFinished || len(data) != 0 {
+		return errors.New("deoxys: unexpected record during handshake")
+	}
+	return nil
+}
+
+// writeRecord seals data into a record and writes it.
+// The extra additional data is only used by the finished records.
+func (c *Conn) writeRecord(typ byte, data, extraAD []byte) error {
+	c.out.Lock()
+	defer c.out.Unlock()
+	if c.out.err != nil {
+		return c.out.err
+	}
+	record := make([]byte, recordHeaderSize, recordHeaderSize+len(data)+TagSize)
+	record[0] = typ
+	binary.BigEndian.PutUint16(record[1:], uint16(len(data)+TagSize))
+	ad := append(record[:recordHeaderSize:recordHeaderSize], extraAD...)
+	record = c.out.aead.Seal(record, c.out.nonce(), data, ad)
+	c.out.advance()
+	if _, err := c.conn.Write(record); err != nil {
+		c.out.err = err
+		return err
+	}
+	return nil
+}
+
+// readRecord reads and opens the next record.
+// The caller must hold c.in, except during the handshake.
+func (c *Conn) readRecord(extraAD []byte) (byte, []byte, error) {
+	header := c.rawIn[:recordHeaderSize]
+	if _, err := io.ReadFull(c.conn, header); err != nil {
+		if err == io.EOF {
+			// A clean close is signaled by close-notify.
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, nil, err
+	}
+	n := int(binary.BigEndian.Uint16(header[1:]))
+	if n < TagSize || n > MaxRecordSize+TagSize {
+		return 0, nil, errors.New("deoxys: record length out of range")
+	}
+	body := c.rawIn[recordHeaderSize : recordHeaderSize+n]
+	if _, err := io.ReadFull(c.conn, body); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, nil, err
+	}
+	ad := append(header[:recordHeaderSize:recordHeaderSize], extraAD...)
+	data, err := c.in.aead.Open(body[:0], c.in.nonce(), body, ad)
+	if err != nil {
+		return 0, nil, errRecordAuth
+	}
+	c.in.advance()
+	return header[0], data, nil
+}
+
+// Read reads data from the connection.
+// It returns io.EOF after the peer closes the connection with Close,
+// and io.ErrUnexpectedEOF if the connection ends without a close-notify.
+func (c *Conn) Read(p []byte) (int, error) {
+	if err := c.Handshake(); err != nil {
+		return 0, err
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	c.in.Lock()
+	defer c.in.Unlock()
+	for len(c.inBuf) == 0 {
+		if c.in.err != nil {
+			return 0, c.in.err
+		}
+		if c.inClosed {
+			return 0, io.EOF
+		}
+		typ, data, err := c.readRecord(nil)
+		if err != nil {
+			c.in.err = err
+			return 0, err
+		}
+		switch typ {
+		case recordData:
+			c.inBuf = data
+		case recordCloseNotify:
+			c.inClosed = true
+		default:
+			c.in.err = errors.New("deoxys: unexpected record type")
+		}
+	}
+	n := copy(p, c.inBuf)
+	c.inBuf = c.inBuf[n:]
+	return n, nil
+}
+
+// Write writes data to the connection, in records of at most MaxRecordSize bytes.
+func (c *Conn) Write(p []byte) (int, error) {
+	if err := c.Handshake(); err != nil {
+		return 0, err
+	}
+	n := 0
+	for len(p) > 0 {
+		m := len(p)
+		if m > MaxRecordSize {
+			m = MaxRecordSize
+		}
+		if err := c.writeRecord(recordData, p[:m], nil); err != nil {
+			return n, err
+		}
+		n += m
+		p = p[m:]
+	}
+	return n, nil
+}
+
+// Close sends a close-notify record, if the handshake has completed,
+// and closes the underlying connection. A handshake in progress
+// is interrupted and fails.
+func (c *Conn) Close() error {
+	err := net.ErrClosed
+	c.closeOnce.Do(func() {
+		var notifyErr error
+		if c.handshakeOK.Load() {
+			// Don't block forever on a peer that is not reading.
+			c.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+			notifyErr = c.writeRecord(recordCloseNotify, nil, nil)
+			c.out.Lock()
+			c.out.err = net.ErrClosed
+			c.out.Unlock()
+		}
+		err = c.conn.Close()
+		if err == nil {
+			err = notifyErr
+		}
+	})
+	return err
+}
+
+// NetConn returns the underlying connection.
+func (c *Conn) NetConn() net.Conn { return c.conn }
+
+// LocalAddr returns the local network address.
+func (c *Conn) LocalAddr() net.Addr { return c.conn.LocalAddr() }
+
+// RemoteAddr returns the remote network address.
+func (c *Conn) RemoteAddr() net.Addr { return c.conn.RemoteAddr() }
+
+// SetDeadline sets the read and write deadlines of the underlying connection.
+func (c *Conn) SetDeadline(t time.Time) error { return c.conn.SetDeadline(t) }
+
+// SetReadDeadline sets the read deadline of the underlying connection.
+// A Read that times out may leave the connection unusable.
+func (c *Conn) SetReadDeadline(t time.Time) error { return c.conn.SetReadDeadline(t) }
+
+// SetWriteDeadline sets the write deadline of the underlying connection.
+// A Write that times out may leave the connection unusable.
+func (c *Conn) SetWriteDeadline(t time.Time) error { return c.conn.SetWriteDeadline(t) }
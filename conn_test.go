@@ -0,0 +1,235 @@
+package deoxys
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+var _ net.Conn = (*Conn)(nil)
+
+var connKey = []byte("16-byte password")
+
+// pipe returns a connected client and server.
+func pipe(clientKey, serverKey []byte) (*Conn, *Conn) {
+	a, b := net.Pipe()
+	return Client(a, clientKey), Server(b, serverKey)
+}
+
+func TestConn(t *testing.T) {
+	client, server := pipe(connKey, connKey)
+	msg := bytes.Repeat([]byte("A witty saying means nothing. "), 3000) // several records
+	done := make(chan error, 1)
+	go func() {
+		buf := make([]byte, len(msg))
+		if _, err := io.ReadFull(server, buf); err != nil {
+			done <- err
+			return
+		}
+		if _, err := server.Write(buf); err != nil {
+			done <- err
+			return
+		}
+		done <- server.Close()
+	}()
+
+	if _, err := client.Write(msg); err != nil {
+		t.Fatal(err)
+	}
+	echo, err := io.ReadAll(client)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if !bytes.Equal(echo, msg) {
+		t.Errorf("echoed %d bytes, want %d", len(echo), len(msg))
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("server: %v", err)
+	}
+	// Reads after close-notify keep returning EOF.
+	if _, err := client.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("Read after close-notify = %v, want EOF", err)
+	}
+	client.Close()
+}
+
+func TestConnWrongKey(t *testing.T) {
+	client, server := pipe(connKey, []byte("some other key!!"))
+	done := make(chan error, 1)
+	go func() {
+		done <- server.Handshake()
+		server.Close()
+	}()
+	if err := client.Handshake(); err == nil {
+		t.Errorf("client Handshake succeeded with the wrong key")
+	}
+	client.Close()
+	if err := <-done; err == nil {
+		t.Errorf("server Handshake succeeded with the wrong key")
+	}
+	if _, err := client.Write([]byte("x")); err == nil {
+		t.Errorf("Write succeeded after a failed handshake")
+	}
+}
+
+func TestConnTruncation(t *testing.T) {
+	client, server := pipe(connKey, connKey)
+	go func() {
+		server.Write([]byte("hello"))
+		// Close the underlying connection without a close-notify.
+		server.NetConn().Close()
+	}()
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(client, buf); err != nil || string(buf) != "hello" {
+		t.Fatalf("Read = %q, %v", buf, err)
+	}
+	if _, err := client.Read(buf); err != io.ErrUnexpectedEOF {
+		t.Errorf("Read after truncation = %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+}
+
+// tamperConn flips a bit in the nth byte written.
+type tamperConn struct {
+	net.Conn
+	n int
+}
+
+func (c *tamperConn) Write(p []byte) (int, error) {
+	if c.n >= 0 && c.n < len(p) {
+		p = append([]byte(nil), p...)
+		p[c.n] ^= 1
+	}
+	c.n -= len(p)
+	return c.Conn.Write(p)
+}
+
+func TestConnTamper(t *testing.T) {
+	// The client writes a 21-byte hello and a 19-byte finished record,
+	// then a data record.
+	for _, n := range []int{0, 10, connHelloSize, connHelloSize + 5, 2*connHelloSize - 2 + recordHeaderSize + TagSize, 2*connHelloSize + 30} {
+		a, b := net.Pipe()
+		client := Client(&tamperConn{a, n}, connKey)
+		server := Server(b, connKey)
+		go func() {
+			client.Write([]byte("hello, world"))
+			client.Close()
+		}()
+		b.SetDeadline(time.Now().Add(5 * time.Second))
+		got, err := io.ReadAll(server)
+		if err == nil {
+			t.Errorf("byte %d modified: ReadAll = %q, expected an error", n, got)
+		}
+		server.Close()
+	}
+}
+
+func TestConnRekey(t *testing.T) {
+	client, server := pipe(connKey, connKey)
+	for _, c := range []*Conn{client, server} {
+		c.in.rekeyAfter = 3
+		c.out.rekeyAfter = 3
+	}
+	go func() {
+		io.Copy(server, server)
+		server.Close()
+	}()
+	key := client.out.key
+	for i := 0; i < 10; i++ {
+		msg := []byte{byte(i)}
+		if _, err := client.Write(msg); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 1)
+		if _, err := io.ReadFull(client, buf); err != nil || buf[0] != byte(i) {
+			t.Fatalf("record %d: Read = %x, %v", i, buf, err)
+		}
+	}
+	if bytes.Equal(key, client.out.key) {
+		t.Errorf("the sending key was never replaced")
+	}
+	if client.out.seq >= 3 {
+		t.Errorf("sequence number %d was not reset", client.out.seq)
+	}
+	client.Close()
+}
+
+func TestConnConcurrent(t *testing.T) {
+	client, server := pipe(connKey, connKey)
+	const n = 100
+	msg := bytes.Repeat([]byte{'x'}, 1000)
+	errc := make(chan error, 2)
+	go func() {
+		for i := 0; i < n; i++ {
+			if _, err := server.Write(msg); err != nil {
+				errc <- err
+				return
+			}
+		}
+		errc <- server.Close()
+	}()
+	go func() {
+		_, err := io.Copy(io.Discard, server)
+		errc <- err
+	}()
+	go func() {
+		for i := 0; i < n; i++ {
+			if _, err := client.Write(msg); err != nil {
+				errc <- err
+				return
+			}
+		}
+	}()
+	got, err := io.ReadAll(client)
+	if err != nil || len(got) != n*len(msg) {
+		t.Errorf("ReadAll = %d bytes, %v; want %d", len(got), err, n*len(msg))
+	}
+	if err := <-errc; err != nil && !errors.Is(err, net.ErrClosed) && err != io.ErrClosedPipe {
+		t.Errorf("server: %v", err)
+	}
+	client.Close()
+}
+
+func TestConnBadHello(t *testing.T) {
+	a, b := net.Pipe()
+	server := Server(b, connKey)
+	go func() {
+		a.Write([]byte("GET / HTTP/1.1\r\nHost: x\r\n\r\n"))
+		a.Close()
+	}()
+	if err := server.Handshake(); err == nil {
+		t.Errorf("Handshake accepted an HTTP request")
+	}
+	server.Close()
+}
+
+func TestConnCloseDuringHandshake(t *testing.T) {
+	// The peer never answers, so the handshake blocks on the pipe.
+	a, b := net.Pipe()
+	defer b.Close()
+	c := Client(a, connKey)
+	readErr := make(chan error, 1)
+	go func() {
+		_, err := c.Read(make([]byte, 1))
+		readErr <- err
+	}()
+	time.Sleep(10 * time.Millisecond) // let the handshake start
+
+	closed := make(chan error, 1)
+	go func() { closed <- c.Close() }()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close blocked on a stalled handshake")
+	}
+	select {
+	case err := <-readErr:
+		if err == nil {
+			t.Errorf("Read succeeded after Close")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("handshake did not return after Close")
+	}
+}
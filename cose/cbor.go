@@ -0,0 +1,282 @@
+package cose
+
+// A minimal CBOR (RFC 8949) encoder and decoder, covering the data model
+// COSE needs: integers, byte and text strings, arrays, maps, tags,
+// booleans and null. Floating-point numbers and indefinite-length
+// items are not supported.
+//
+// The encoder produces the core deterministic encoding of section 4.2.1:
+// shortest-form integers and lengths, definite lengths only, and
+// map keys sorted by the bytewise order of their encodings.
+// The decoder accepts any well-formed input within that data model,
+// but rejects duplicate map keys and trailing data.
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"sort"
+	"unicode/utf8"
+)
+
+const (
+	majorUint   = 0
+	majorNegInt = 1
+	majorBytes  = 2
+	majorText   = 3
+	majorArray  = 4
+	majorMap    = 5
+	majorTag    = 6
+	majorSimple = 7
+
+	simpleFalse = 20
+	simpleTrue  = 21
+	simpleNull  = 22
+
+	maxDepth = 16
+)
+
+// A cborTag is a tagged data item.
+type cborTag struct {
+	Number  uint64
+	Content interface{}
+}
+
+func appendHead(b []byte, major byte, n uint64) []byte {
+	major <<= 5
+	switch {
+	case n < 24:
+		return append(b, major|byte(n))
+	case n <= math.MaxUint8:
+		return append(b, major|24, byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, major|25), uint16(n))
+	case n <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32(append(b, major|26), uint32(n))
+	default:
+		return binary.BigEndian.AppendUint64(append(b, major|27), n)
+	}
+}
+
+// marshalCBOR appends the deterministic encoding of v to b.
+// Maps may be Headers or map[interface{}]interface{}.
+func marshalCBOR(b []byte, v interface{}) ([]byte, error) {
+	switch v := v.(type) {
+	case nil:
+		return append(b, majorSimple<<5|simpleNull), nil
+	case bool:
+		if v {
+			return append(b, majorSimple<<5|simpleTrue), nil
+		}
+		return append(b, majorSimple<<5|simpleFalse), nil
+	case int:
+		return marshalCBOR(b, int64(v))
+	case int64:
+		if v < 0 {
+			return appendHead(b, majorNegInt, uint64(-1-v)), nil
+		}
+		return appendHead(b, majorUint, uint64(v)), nil
+	case uint64:
+		return appendHead(b, majorUint, v), nil
+	case []byte:
+		return append(appendHead(b, majorBytes, uint64(len(v))), v...), nil
+	case string:
+		if !utf8.ValidString(v) {
+			return nil, errors.New("cbor: invalid UTF-8 in text string")
+		}
+		return append(appendHead(b, majorText, uint64(len(v))), v...), nil
+	case []interface{}:
+		b = appendHead(b, majorArray, uint64(len(v)))
+		for _, x := range v {
+			var err error
+			if b, err = marshalCBOR(b, x); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	case Headers:
+		return marshalMap(b, v)
+	case map[interface{}]interface{}:
+		return marshalMap(b, v)
+	case cborTag:
+		return marshalCBOR(appendHead(b, majorTag, v.Number), v.Content)
+	}
+	return nil, errors.New("cbor: unsupported type")
+}
+
+func marshalMap(b []byte, m map[interface{}]interface{}) ([]byte, error) {
+	type pair struct{ k, v []byte }
+	pairs := make([]pair, 0, len(m))
+	for k, v := range m {
+		kb, err := marshalCBOR(nil, k)
+		if err != nil {
+			return nil, err
+		}
+		vb, err := marshalCBOR(nil, v)
+		if err != nil {
+			return nil, err
+		}
+		pairs = append(pairs, pair{kb, vb})
+	}
+	sort.Slice(pairs, func(i, j int) bool { return bytes.Compare(pairs[i].k, pairs[j].k) < 0 })
+	b = appendHead(b, majorMap, uint64(len(pairs)))
+	for i, p := range pairs {
+		if i > 0 && bytes.Equal(p.k, pairs[i-1].k) {
+			return nil, errors.New("cbor: duplicate map key")
+		}
+		b = append(append(b, p.k...), p.v...)
+	}
+	return b, nil
+}
+
+// unmarshalCBOR decodes a single data item that makes up all of data.
+// Integers decode to int64, maps to Headers, arrays to []interface{}
+// and tags to cborTag.
+func unmarshalCBOR(data []byte) (interface{}, error) {
+	d := decoder{data}
+	v, err := d.item(0)
+	if err != nil {
+		return nil, err
+	}
+	if len(d.b) != 0 {
+		return nil, errors.New("cbor: trailing data")
+	}
+	return v, nil
+}
+
+type decoder struct {
+	b []byte
+}
+
+var errTruncated = errors.New("cbor: unexpected end of data")
+
+// head reads the head of a data item: its major type, its additional
+// information, and the argument that follows from them.
+func (d *decoder) head() (major, ai byte, n uint64, err error) {
+	if len(d.b) == 0 {
+		return 0, 0, 0, errTruncated
+	}
+	major, ai = d.b[0]>>5, d.b[0]&31
+	d.b = d.b[1:]
+	var size int
+	switch {
+	case ai < 24:
+		return major, ai, uint64(ai), nil
+	case ai == 24:
+		size = 1
+	case ai == 25:
+		size = 2
+	case ai == 26:
+		size = 4
+	case ai == 27:
+		size = 8
+	case ai == 31:
+		return 0, 0, 0, errors.New("cbor: indefinite-length items are not supported")
+	default:
+		return 0, 0, 0, errors.New("cbor: malformed data item")
+	}
+	if len(d.b) < size {
+		return 0, 0, 0, errTruncated
+	}
+	for _, c := range d.b[:size] {
+		n = n<<8 | uint64(c)
+	}
+	d.b = d.b[size:]
+	return major, ai, n, nil
+}
+
+func (d *decoder) item(depth int) (interface{}, error) {
+	if depth > maxDepth {
+		return nil, errors.New("cbor: nesting too deep")
+	}
+	major, ai, n, err := d.head()
+	if err != nil {
+		return nil, err
+	}
+	switch major {
+	case majorUint:
+		if n > math.MaxInt64 {
+			return nil, errors.New("cbor: integer out of range")
+		}
+		return int64(n), nil
+	case majorNegInt:
+		if n > math.MaxInt64 {
+			return nil, errors.New("cbor: integer out of range")
+		}
+		return -1 - int64(n), nil
+	case majorBytes, majorText:
+		if n > uint64(len(d.b)) {
+			return nil, errTruncated
+		}
+		s := d.b[:n]
+		d.b = d.b[n:]
+		if major == majorText {
+			if !utf8.Valid(s) {
+				return nil, errors.New("cbor: invalid UTF-8 in text string")
+			}
+			return string(s), nil
+		}
+		return append([]byte{}, s...), nil
+	case majorArray:
+		// Every item takes at least a byte, which bounds the allocation.
+		if n > uint64(len(d.b)) {
+			return nil, errTruncated
+		}
+		a := make([]interface{}, n)
+		for i := range a {
+			if a[i], err = d.item(depth + 1); err != nil {
+				return nil, err
+			}
+		}
+		return a, nil
+	case majorMap:
+		if n > uint64(len(d.b))/2 {
+			return nil, errTruncated
+		}
+		m := make(Headers, n)
+		for i := uint64(0); i < n; i++ {
+			k, err := d.item(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			switch k.(type) {
+			case int64, string:
+			default:
+				return nil, errors.New("cbor: unsupported map key type")
+			}
+			if _, ok := m[k]; ok {
+				return nil, errors.New("cbor: duplicate map key")
+			}
+			if m[k], err = d.item(depth + 1); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	case majorTag:
+		v, err := d.item(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		return cborTag{n, v}, nil
+	default: // majorSimple
+		// For major type 7, ai 25 to 27 are floating-point numbers,
+		// not arguments, and ai 24 is only for simple values 32 to 255,
+		// none of which are supported.
+		if ai >= 25 {
+			return nil, errors.New("cbor: floating-point numbers are not supported")
+		}
+		if ai == 24 {
+			return nil, errors.New("cbor: unsupported simple value")
+		}
+		switch n {
+		case simpleFalse:
+			return false, nil
+		case simpleTrue:
+			return true, nil
+		case simpleNull:
+			return nil, nil
+		}
+		return nil, errors.New("cbor: unsupported simple value")
+	}
+}
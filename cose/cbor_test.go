@@ -0,0 +1,138 @@
+package cose
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func unhex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// Examples from Appendix A of RFC 8949.
+var cborExamples = []struct {
+	v   interface{}
+	enc string
+}{
+	{int64(0), "00"},
+	{int64(1), "01"},
+	{int64(10), "0a"},
+	{int64(23), "17"},
+	{int64(24), "1818"},
+	{int64(25), "1819"},
+	{int64(100), "1864"},
+	{int64(1000), "1903e8"},
+	{int64(1000000), "1a000f4240"},
+	{int64(1000000000000), "1b000000e8d4a51000"},
+	{int64(-1), "20"},
+	{int64(-10), "29"},
+	{int64(-100), "3863"},
+	{int64(-1000), "3903e7"},
+	{int64(math.MinInt64), "3b7fffffffffffffff"},
+	{false, "f4"},
+	{true, "f5"},
+	{nil, "f6"},
+	{[]byte{}, "40"},
+	{[]byte{1, 2, 3, 4}, "4401020304"},
+	{"", "60"},
+	{"a", "6161"},
+	{"IETF", "6449455446"},
+	{"\"\\", "62225c"},
+	{"ü", "62c3bc"},
+	{"水", "63e6b0b4"},
+	{[]interface{}{}, "80"},
+	{[]interface{}{int64(1), int64(2), int64(3)}, "83010203"},
+	{[]interface{}{int64(1), []interface{}{int64(2), int64(3)}, []interface{}{int64(4), int64(5)}}, "8301820203820405"},
+	{Headers{}, "a0"},
+	{Headers{int64(1): int64(2), int64(3): int64(4)}, "a201020304"},
+	{Headers{"a": int64(1), "b": []interface{}{int64(2), int64(3)}}, "a26161016162820203"},
+	{Headers{"a": "A", "b": "B", "c": "C", "d": "D", "e": "E"}, "a56161614161626142616361436164614461656145"},
+	{cborTag{1, int64(1363896240)}, "c11a514b67b0"},
+	{cborTag{24, []byte("dIETF")}, "d818456449455446"},
+}
+
+func TestCBORExamples(t *testing.T) {
+	for _, ex := range cborExamples {
+		enc, err := marshalCBOR(nil, ex.v)
+		if err != nil || hex.EncodeToString(enc) != ex.enc {
+			t.Errorf("marshalCBOR(%#v) = %x, %v; want %s", ex.v, enc, err, ex.enc)
+		}
+		v, err := unmarshalCBOR(unhex(ex.enc))
+		if err != nil || !reflect.DeepEqual(v, ex.v) {
+			t.Errorf("unmarshalCBOR(%s) = %#v, %v; want %#v", ex.enc, v, err, ex.v)
+		}
+	}
+}
+
+func TestCBORDeterministic(t *testing.T) {
+	// Keys sort by their encoding: positive integers, then negative
+	// integers, then shorter strings before longer ones.
+	h := Headers{"aa": int64(0), "b": int64(0), int64(-1): int64(0), int64(10): int64(0), int64(100): int64(0), 1: int64(0)}
+	enc, err := marshalCBOR(nil, h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "a6" + "0100" + "0a00" + "186400" + "2000" + "616200" + "62616100"
+	if hex.EncodeToString(enc) != want {
+		t.Errorf("marshalCBOR = %x, want %s", enc, want)
+	}
+	for i := 0; i < 10; i++ {
+		if again, _ := marshalCBOR(nil, h); !bytes.Equal(again, enc) {
+			t.Fatalf("marshalCBOR is not deterministic")
+		}
+	}
+
+	if _, err := marshalCBOR(nil, Headers{1: int64(0), int64(1): int64(0)}); err == nil {
+		t.Errorf("marshalCBOR accepted keys 1 and int64(1)")
+	}
+	for _, v := range []interface{}{1.5, "\xff", struct{}{}, []interface{}{uint8(1)}} {
+		if _, err := marshalCBOR(nil, v); err == nil {
+			t.Errorf("marshalCBOR(%#v) succeeded", v)
+		}
+	}
+}
+
+func TestCBORMalformed(t *testing.T) {
+	deep := bytes.Repeat([]byte{0x81}, maxDepth+2)
+	deep = append(deep, 0)
+	for _, s := range []string{
+		"",
+		"18",                 // truncated argument
+		"1c",                 // reserved additional information
+		"1f",                 // indefinite
+		"5f4101ff",           // indefinite byte string
+		"9f01ff",             // indefinite array
+		"42ff",               // truncated byte string
+		"62c3",               // truncated text string
+		"61ff",               // invalid UTF-8
+		"8201",               // truncated array
+		"a101",               // truncated map
+		"a2010201",           // duplicate key
+		"a1f600",             // null key
+		"1bffffffffffffffff", // out of int64 range
+		"3bffffffffffffffff", // out of int64 range
+		"f93c00",             // float
+		"f90014",             // float, not false
+		"fa00000015",         // float, not true
+		"fb0000000000000016", // float, not null
+		"f814",               // two-byte encoding of false
+		"f820",               // simple value
+		"f0",                 // simple value
+		"0000",               // trailing data
+		"9bffffffffffffffff", // huge array
+		"bbffffffffffffffff", // huge map
+		"c1",                 // tag without content
+		hex.EncodeToString(deep),
+	} {
+		if v, err := unmarshalCBOR(unhex(s)); err == nil {
+			t.Errorf("unmarshalCBOR(%s) = %#v, expected an error", s, v)
+		}
+	}
+}
@@ -0,0 +1,418 @@
+// Package cose implements COSE_Encrypt0 and COSE_Encrypt messages
+// (RFC 9052) with Deoxys-II as the content encryption algorithm.
+//
+// Deoxys-II has no registered COSE algorithm identifier,
+// so it uses AlgDeoxysII128 from the private-use range:
+// Deoxys-II with a 16-byte key, a 15-byte IV and a 16-byte tag.
+// COSE_Encrypt recipients use either direct encryption (AlgDirect)
+// or AlgKeyWrap, which wraps the content key with Deoxys-II under
+// a zero nonce and authenticates the recipient's protected header
+// through an Enc_structure with context "Enc_Recipient".
+//
+// Messages are written with their CBOR tags (16 and 96)
+// and read with or without them. Detached content,
+// partial IVs, counter signatures and critical header
+// parameters are not supported.
+//
+// RFC 9052:
+//
+//	https://www.rfc-editor.org/rfc/rfc9052.html
+package cose
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+
+	"github.com/magical/deoxys"
+)
+
+// Algorithm identifiers.
+const (
+	AlgDeoxysII128 int64 = -65600 // private use
+	AlgKeyWrap     int64 = -65601 // private use
+	AlgDirect      int64 = -6
+)
+
+// Common header parameter labels.
+const (
+	HeaderAlg         int64 = 1
+	HeaderCrit        int64 = 2
+	HeaderContentType int64 = 3
+	HeaderKID         int64 = 4
+	HeaderIV          int64 = 5
+	HeaderPartialIV   int64 = 6
+)
+
+const (
+	tagEncrypt0 = 16
+	tagEncrypt  = 96
+
+	keySize = 16
+)
+
+// Headers is a COSE header map. Labels are integers or strings,
+// and values are CBOR data items: integers, []byte, string, bool, nil,
+// []interface{} and Headers. Decoded integers are always int64.
+type Headers map[interface{}]interface{}
+
+// normalize returns a copy of h with int labels converted to int64.
+func normalize(h Headers) (Headers, error) {
+	out := make(Headers, len(h))
+	for k, v := range h {
+		switch l := k.(type) {
+		case int:
+			k = int64(l)
+		case int64, string:
+		default:
+			return nil, errors.New("cose: header label must be an integer or a string")
+		}
+		if _, ok := out[k]; ok {
+			return nil, errors.New("cose: duplicate header label")
+		}
+		out[k] = v
+	}
+	return out, nil
+}
+
+// callerHeaders normalizes the protected and unprotected headers
+// supplied to Seal0 or Seal, which may not set the parameters
+// this package manages.
+func callerHeaders(protected, unprotected Headers) (p, u Headers, err error) {
+	if p, err = normalize(protected); err != nil {
+		return nil, nil, err
+	}
+	if u, err = normalize(unprotected); err != nil {
+		return nil, nil, err
+	}
+	for _, h := range []Headers{p, u} {
+		for _, l := range []int64{HeaderAlg, HeaderCrit, HeaderIV, HeaderPartialIV} {
+			if _, ok := h[l]; ok {
+				return nil, nil, errors.New("cose: header parameter is set by the package")
+			}
+		}
+	}
+	return p, u, checkHeaders(p, u)
+}
+
+// checkHeaders checks that no label is in both buckets
+// and that there are no critical parameters.
+func checkHeaders(protected, unprotected Headers) error {
+	for k := range protected {
+		if _, ok := unprotected[k]; ok {
+			return errors.New("cose: header parameter in both protected and unprotected buckets")
+		}
+	}
+	if _, ok := protected[HeaderCrit]; ok {
+		return errors.New("cose: critical header parameters are not supported")
+	}
+	if _, ok := unprotected[HeaderCrit]; ok {
+		return errors.New("cose: critical header parameters are not supported")
+	}
+	return nil
+}
+
+// lookup returns the value of label from either bucket.
+func lookup(protected, unprotected Headers, label int64) interface{} {
+	if v, ok := protected[label]; ok {
+		return v
+	}
+	return unprotected[label]
+}
+
+func encodeProtected(h Headers) ([]byte, error) {
+	if len(h) == 0 {
+		return []byte{}, nil
+	}
+	return marshalCBOR(nil, h)
+}
+
+func decodeProtected(b []byte) (Headers, error) {
+	if len(b) == 0 {
+		return Headers{}, nil
+	}
+	v, err := unmarshalCBOR(b)
+	if err != nil {
+		return nil, err
+	}
+	h, ok := v.(Headers)
+	if !ok {
+		return nil, errors.New("cose: protected header is not a map")
+	}
+	return h, nil
+}
+
+// encStructure returns the Enc_structure that is
+// the additional data for an encryption layer.
+func encStructure(context string, protected, externalAAD []byte) []byte {
+	if externalAAD == nil {
+		externalAAD = []byte{}
+	}
+	b, err := marshalCBOR(nil, []interface{}{context, protected, externalAAD})
+	if err != nil {
+		panic("cose: " + err.Error())
+	}
+	return b
+}
+
+// layer is a decoded COSE_Encrypt0, COSE_Encrypt or COSE_recipient.
+type layer struct {
+	protectedBytes []byte
+	protected      Headers
+	unprotected    Headers
+	ciphertext     []byte
+	recipients     []interface{}
+}
+
+// parseLayer decodes an array of n items with the structure of
+// COSE_Encrypt0 (n = 3), COSE_Encrypt (n = 4) or COSE_recipient (n = 3).
+func parseLayer(v interface{}, n int) (*layer, error) {
+	a, ok := v.([]interface{})
+	if !ok || len(a) != n {
+		return nil, errors.New("cose: malformed message")
+	}
+	pb, ok1 := a[0].([]byte)
+	u, ok2 := a[1].(Headers)
+	ct, ok3 := a[2].([]byte)
+	if !ok1 || !ok2 {
+		return nil, errors.New("cose: malformed message")
+	}
+	if !ok3 {
+		return nil, errors.New("cose: detached content is not supported")
+	}
+	p, err := decodeProtected(pb)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkHeaders(p, u); err != nil {
+		return nil, err
+	}
+	l := &layer{protectedBytes: pb, protected: p, unprotected: u, ciphertext: ct}
+	if n == 4 {
+		if l.recipients, ok = a[3].([]interface{}); !ok || len(l.recipients) == 0 {
+			return nil, errors.New("cose: malformed recipients")
+		}
+	}
+	return l, nil
+}
+
+// parseMessage decodes a message with the optional CBOR tag.
+func parseMessage(msg []byte, tag uint64, n int) (*layer, error) {
+	v, err := unmarshalCBOR(msg)
+	if err != nil {
+		return nil, err
+	}
+	if t, ok := v.(cborTag); ok {
+		if t.Number != tag {
+			return nil, errors.New("cose: wrong message type")
+		}
+		v = t.Content
+	}
+	return parseLayer(v, n)
+}
+
+// seal encrypts the content layer and returns its protected header bytes,
+// unprotected header and ciphertext.
+func seal(context string, key, plaintext, externalAAD []byte, p, u Headers) ([]byte, Headers, []byte, error) {
+	p[HeaderAlg] = AlgDeoxysII128
+	iv := make([]byte, deoxys.NonceSize)
+	if _, err := rand.Read(iv); err != nil {
+		return nil, nil, nil, err
+	}
+	u[HeaderIV] = iv
+	pb, err := encodeProtected(p)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	ad := encStructure(context, pb, externalAAD)
+	return pb, u, deoxys.New(key).Seal(nil, iv, plaintext, ad), nil
+}
+
+// open decrypts the content layer l.
+func (l *layer) open(context string, key, externalAAD []byte) ([]byte, error) {
+	if alg, ok := lookup(l.protected, l.unprotected, HeaderAlg).(int64); !ok || alg != AlgDeoxysII128 {
+		return nil, errors.New("cose: unsupported content encryption algorithm")
+	}
+	if lookup(l.protected, l.unprotected, HeaderPartialIV) != nil {
+		return nil, errors.New("cose: partial IVs are not supported")
+	}
+	iv, ok := lookup(l.protected, l.unprotected, HeaderIV).([]byte)
+	if !ok || len(iv) != deoxys.NonceSize {
+		return nil, errors.New("cose: missing or wrong size IV")
+	}
+	ad := encStructure(context, l.protectedBytes, externalAAD)
+	return deoxys.New(key).Open(nil, iv, l.ciphertext, ad)
+}
+
+// Seal0 encrypts plaintext with a 16-byte key and returns
+// a tagged COSE_Encrypt0 message. The external additional data,
+// if any, is authenticated but not included in the message.
+//
+// The protected and unprotected headers may carry parameters
+// such as HeaderKID or HeaderContentType; Seal0 adds the
+// algorithm to the protected header and a random IV
+// to the unprotected header.
+func Seal0(key, plaintext, externalAAD []byte, protected, unprotected Headers) ([]byte, error) {
+	if len(key) != keySize {
+		return nil, errors.New("cose: wrong size key")
+	}
+	p, u, err := callerHeaders(protected, unprotected)
+	if err != nil {
+		return nil, err
+	}
+	pb, u, ct, err := seal("Encrypt0", key, plaintext, externalAAD, p, u)
+	if err != nil {
+		return nil, err
+	}
+	return marshalCBOR(nil, cborTag{tagEncrypt0, []interface{}{pb, u, ct}})
+}
+
+// Open0 decrypts a COSE_Encrypt0 message with a 16-byte key
+// and returns the plaintext and the protected and unprotected headers.
+func Open0(key, msg, externalAAD []byte) (plaintext []byte, protected, unprotected Headers, err error) {
+	if len(key) != keySize {
+		return nil, nil, nil, errors.New("cose: wrong size key")
+	}
+	l, err := parseMessage(msg, tagEncrypt0, 3)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	pt, err := l.open("Encrypt0", key, externalAAD)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return pt, l.protected, l.unprotected, nil
+}
+
+// A Recipient holds a key and how to use it.
+type Recipient struct {
+	Alg   int64  // AlgDirect or AlgKeyWrap
+	Key   []byte // 16 bytes
+	KeyID []byte // optional HeaderKID parameter
+}
+
+func (r *Recipient) check() error {
+	if r.Alg != AlgDirect && r.Alg != AlgKeyWrap {
+		return errors.New("cose: unsupported recipient algorithm")
+	}
+	if len(r.Key) != keySize {
+		return errors.New("cose: wrong size key")
+	}
+	return nil
+}
+
+// encode returns the COSE_recipient structure carrying cek.
+func (r *Recipient) encode(cek []byte) []interface{} {
+	u := Headers{}
+	if r.KeyID != nil {
+		u[HeaderKID] = r.KeyID
+	}
+	if r.Alg == AlgDirect {
+		u[HeaderAlg] = AlgDirect
+		return []interface{}{[]byte{}, u, []byte{}}
+	}
+	pb, err := encodeProtected(Headers{HeaderAlg: AlgKeyWrap})
+	if err != nil {
+		panic("cose: " + err.Error())
+	}
+	var nonce [deoxys.NonceSize]byte
+	wrapped := deoxys.New(r.Key).Seal(nil, nonce[:], cek, encStructure("Enc_Recipient", pb, nil))
+	return []interface{}{pb, u, wrapped}
+}
+
+// decode returns the content key from a COSE_recipient structure,
+// or false if the structure is not for r.
+func (r *Recipient) decode(v interface{}) ([]byte, bool) {
+	l, err := parseLayer(v, 3)
+	if err != nil {
+		return nil, false
+	}
+	if alg, ok := lookup(l.protected, l.unprotected, HeaderAlg).(int64); !ok || alg != r.Alg {
+		return nil, false
+	}
+	if r.KeyID != nil {
+		if kid, ok := lookup(l.protected, l.unprotected, HeaderKID).([]byte); !ok || !bytes.Equal(kid, r.KeyID) {
+			return nil, false
+		}
+	}
+	if r.Alg == AlgDirect {
+		if len(l.protectedBytes) != 0 || len(l.ciphertext) != 0 {
+			return nil, false
+		}
+		return r.Key, true
+	}
+	var nonce [deoxys.NonceSize]byte
+	cek, err := deoxys.New(r.Key).Open(nil, nonce[:], l.ciphertext, encStructure("Enc_Recipient", l.protectedBytes, nil))
+	if err != nil || len(cek) != keySize {
+		return nil, false
+	}
+	return cek, true
+}
+
+// Seal encrypts plaintext for each of the recipients and returns
+// a tagged COSE_Encrypt message. It is otherwise like Seal0.
+// Direct encryption is only possible with a single recipient;
+// otherwise a random content key is wrapped for each recipient.
+func Seal(recipients []Recipient, plaintext, externalAAD []byte, protected, unprotected Headers) ([]byte, error) {
+	if len(recipients) == 0 {
+		return nil, errors.New("cose: no recipients")
+	}
+	for i := range recipients {
+		if err := recipients[i].check(); err != nil {
+			return nil, err
+		}
+	}
+	var cek []byte
+	if len(recipients) == 1 && recipients[0].Alg == AlgDirect {
+		cek = recipients[0].Key
+	} else {
+		for i := range recipients {
+			if recipients[i].Alg == AlgDirect {
+				return nil, errors.New("cose: direct encryption with more than one recipient")
+			}
+		}
+		cek = make([]byte, keySize)
+		if _, err := rand.Read(cek); err != nil {
+			return nil, err
+		}
+	}
+	p, u, err := callerHeaders(protected, unprotected)
+	if err != nil {
+		return nil, err
+	}
+	pb, u, ct, err := seal("Encrypt", cek, plaintext, externalAAD, p, u)
+	if err != nil {
+		return nil, err
+	}
+	rs := make([]interface{}, len(recipients))
+	for i := range recipients {
+		rs[i] = recipients[i].encode(cek)
+	}
+	return marshalCBOR(nil, cborTag{tagEncrypt, []interface{}{pb, u, ct, rs}})
+}
+
+// Open decrypts a COSE_Encrypt message with r and returns
+// the plaintext and the content layer's protected and unprotected headers.
+// It uses the first recipient structure whose algorithm matches r.Alg
+// and, if r.KeyID is set, whose key ID matches r.KeyID.
+func Open(r Recipient, msg, externalAAD []byte) (plaintext []byte, protected, unprotected Headers, err error) {
+	if err := r.check(); err != nil {
+		return nil, nil, nil, err
+	}
+	l, err := parseMessage(msg, tagEncrypt, 4)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	for _, v := range l.recipients {
+		cek, ok := r.decode(v)
+		if !ok {
+			continue
+		}
+		pt, err := l.open("Encrypt", cek, externalAAD)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		return pt, l.protected, l.unprotected, nil
+	}
+	return nil, nil, nil, errors.New("cose: no matching recipient")
+}
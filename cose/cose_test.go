@@ -0,0 +1,239 @@
+package cose
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/magical/deoxys"
+)
+
+var (
+	testKey  = []byte("0123456789abcdef")
+	otherKey = []byte("fedcba9876543210")
+	testMsg  = []byte("This is the content.")
+)
+
+// Regression vectors generated with this implementation.
+// testEncrypt0 has content type 0 in the protected header, key ID
+// "our-secret" and external additional data "ext"; testEncrypt wraps
+// the content key for testKey under key ID "k1".
+const (
+	testEncrypt0 = "d08349a2013a0001003f0300a2044a6f75722d736563726574054fec94e6ce5c109a15feea3457d3c0e458240f99b3a956ce64a7e586a1b146b7c08ebee8d1b9b8a26fb8fd207fa60b401fe772ffcbb6"
+	testEncrypt  = "d8608447a1013a0001003fa1054ffbb851f303c44311f7a439effe2b465824e425201e0543d04ee241b511a7c0607919a7790d4fc8b00ec2aafcb01031b1e1c7fc7b79818347a1013a00010040a104426b31582014116552e2a38262ecd43edd76afebfbdcc3e9fb769b1d69fd3d47b6ab381708"
+)
+
+func TestVectors(t *testing.T) {
+	pt, p, u, err := Open0(testKey, unhex(testEncrypt0), []byte("ext"))
+	if err != nil || !bytes.Equal(pt, testMsg) {
+		t.Errorf("Open0 = %q, %v; want %q", pt, err, testMsg)
+	}
+	if p[HeaderContentType] != int64(0) || string(u[HeaderKID].([]byte)) != "our-secret" {
+		t.Errorf("Open0 headers = %v, %v", p, u)
+	}
+	pt, _, _, err = Open(Recipient{AlgKeyWrap, testKey, []byte("k1")}, unhex(testEncrypt), nil)
+	if err != nil || !bytes.Equal(pt, testMsg) {
+		t.Errorf("Open = %q, %v; want %q", pt, err, testMsg)
+	}
+}
+
+func TestEncStructure(t *testing.T) {
+	// ["Encrypt0", h'a10101', h'']
+	want := "83" + "68456e637279707430" + "43a10101" + "40"
+	if got := hex.EncodeToString(encStructure("Encrypt0", unhex("a10101"), nil)); got != want {
+		t.Errorf("encStructure = %s, want %s", got, want)
+	}
+
+	// The ciphertext of an Encrypt0 message is Seal with the Enc_structure as AD.
+	msg, err := Seal0(testKey, testMsg, []byte("ext"), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := parseMessage(msg, tagEncrypt0, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iv := l.unprotected[HeaderIV].([]byte)
+	ad := encStructure("Encrypt0", l.protectedBytes, []byte("ext"))
+	if want := deoxys.New(testKey).Seal(nil, iv, testMsg, ad); !bytes.Equal(l.ciphertext, want) {
+		t.Errorf("ciphertext = %x, want %x", l.ciphertext, want)
+	}
+	if hex.EncodeToString(l.protectedBytes) != "a1013a0001003f" {
+		t.Errorf("protected header = %x, want a1013a0001003f", l.protectedBytes)
+	}
+}
+
+// tampered returns copies of msg with one bit flipped in each byte
+// of the authenticated fields: the protected header, the IV and
+// the ciphertext of the content layer and of recipient structure i.
+// The unprotected headers are not authenticated, and the other
+// recipient structures do not matter to recipient i.
+func tampered(t *testing.T, msg []byte, i int) [][]byte {
+	t.Helper()
+	var out [][]byte
+	var walk func(layer []interface{}, edit func())
+	walk = func(layer []interface{}, edit func()) {
+		flip := func(b []byte) {
+			for j := range b {
+				b[j] ^= 1
+				edit()
+				b[j] ^= 1
+			}
+		}
+		flip(layer[0].([]byte))
+		flip(layer[2].([]byte))
+		if iv, ok := layer[1].(Headers)[HeaderIV].([]byte); ok {
+			flip(iv)
+		}
+		if len(layer) == 4 {
+			walk(layer[3].([]interface{})[i].([]interface{}), edit)
+		}
+	}
+	v, err := unmarshalCBOR(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	walk(v.(cborTag).Content.([]interface{}), func() {
+		c, err := marshalCBOR(nil, v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, c)
+	})
+	return out
+}
+
+func TestEncrypt0(t *testing.T) {
+	msg, err := Seal0(testKey, testMsg, []byte("ext"), Headers{HeaderContentType: "text/plain"}, Headers{HeaderKID: []byte("k1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg[0] != 0xd0 {
+		t.Errorf("message starts with %#x, want tag 16", msg[0])
+	}
+	pt, p, u, err := Open0(testKey, msg, []byte("ext"))
+	if err != nil || !bytes.Equal(pt, testMsg) {
+		t.Fatalf("Open0 = %q, %v; want %q", pt, err, testMsg)
+	}
+	if p[HeaderAlg] != AlgDeoxysII128 || p[HeaderContentType] != "text/plain" || !bytes.Equal(u[HeaderKID].([]byte), []byte("k1")) {
+		t.Errorf("Open0 headers = %v, %v", p, u)
+	}
+
+	// Untagged messages are accepted.
+	if pt, _, _, err := Open0(testKey, msg[1:], []byte("ext")); err != nil || !bytes.Equal(pt, testMsg) {
+		t.Errorf("Open0(untagged) = %q, %v", pt, err)
+	}
+	if _, _, _, err := Open0(testKey, msg, nil); err == nil {
+		t.Errorf("Open0 with the wrong external AAD succeeded")
+	}
+	if _, _, _, err := Open0(otherKey, msg, []byte("ext")); err == nil {
+		t.Errorf("Open0 with the wrong key succeeded")
+	}
+	for _, c := range tampered(t, msg, -1) {
+		if _, _, _, err := Open0(testKey, c, []byte("ext")); err == nil {
+			t.Errorf("Open0(%x) succeeded", c)
+		}
+	}
+}
+
+func TestEncrypt(t *testing.T) {
+	recipients := []Recipient{
+		{AlgKeyWrap, testKey, []byte("k1")},
+		{AlgKeyWrap, otherKey, []byte("k2")},
+	}
+	msg, err := Seal(recipients, testMsg, []byte("ext"), nil, Headers{HeaderContentType: int64(0)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range recipients {
+		pt, _, u, err := Open(r, msg, []byte("ext"))
+		if err != nil || !bytes.Equal(pt, testMsg) || u[HeaderContentType] != int64(0) {
+			t.Errorf("%s: Open = %q, %v, %v", r.KeyID, pt, u, err)
+		}
+		// Without a key ID, every recipient is tried.
+		if pt, _, _, err := Open(Recipient{r.Alg, r.Key, nil}, msg, []byte("ext")); err != nil || !bytes.Equal(pt, testMsg) {
+			t.Errorf("%s: Open without key ID = %q, %v", r.KeyID, pt, err)
+		}
+	}
+	if _, _, _, err := Open(Recipient{AlgKeyWrap, testKey, []byte("k2")}, msg, []byte("ext")); err == nil {
+		t.Errorf("Open with the wrong key ID succeeded")
+	}
+	if _, _, _, err := Open(recipients[0], msg, nil); err == nil {
+		t.Errorf("Open with the wrong external AAD succeeded")
+	}
+	if _, _, _, err := Open0(testKey, msg, []byte("ext")); err == nil {
+		t.Errorf("Open0 accepted a COSE_Encrypt message")
+	}
+	for _, c := range tampered(t, msg, 1) {
+		if _, _, _, err := Open(recipients[1], c, []byte("ext")); err == nil {
+			t.Errorf("Open(%x) succeeded", c)
+		}
+	}
+}
+
+func TestEncryptDirect(t *testing.T) {
+	r := Recipient{AlgDirect, testKey, []byte("k1")}
+	msg, err := Seal([]Recipient{r}, testMsg, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pt, _, _, err := Open(r, msg, nil); err != nil || !bytes.Equal(pt, testMsg) {
+		t.Errorf("Open = %q, %v; want %q", pt, err, testMsg)
+	}
+	if _, _, _, err := Open(Recipient{AlgKeyWrap, testKey, nil}, msg, nil); err == nil {
+		t.Errorf("Open with the wrong algorithm succeeded")
+	}
+	two := []Recipient{r, {AlgKeyWrap, otherKey, nil}}
+	if _, err := Seal(two, testMsg, nil, nil, nil); err == nil {
+		t.Errorf("Seal accepted direct encryption with two recipients")
+	}
+}
+
+func TestHeaderErrors(t *testing.T) {
+	for _, h := range [][2]Headers{
+		{{HeaderAlg: AlgDeoxysII128}, nil},
+		{nil, {HeaderIV: make([]byte, 15)}},
+		{nil, {HeaderCrit: []interface{}{int64(3)}}},
+		{{HeaderContentType: int64(0)}, {HeaderContentType: int64(0)}},
+		{{1.5: int64(0)}, nil},
+	} {
+		if _, err := Seal0(testKey, nil, nil, h[0], h[1]); err == nil {
+			t.Errorf("Seal0 accepted headers %v, %v", h[0], h[1])
+		}
+	}
+	if _, err := Seal0(testKey[:15], nil, nil, nil, nil); err == nil {
+		t.Errorf("Seal0 accepted a 15-byte key")
+	}
+	if _, err := Seal(nil, nil, nil, nil, nil); err == nil {
+		t.Errorf("Seal accepted no recipients")
+	}
+	if _, err := Seal([]Recipient{{AlgDeoxysII128, testKey, nil}}, nil, nil, nil, nil); err == nil {
+		t.Errorf("Seal accepted a content algorithm as a recipient algorithm")
+	}
+
+	enc := func(v interface{}) []byte {
+		b, err := marshalCBOR(nil, v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	iv := make([]byte, deoxys.NonceSize)
+	prot := enc(Headers{HeaderAlg: AlgDeoxysII128})
+	ct := make([]byte, deoxys.TagSize)
+	for _, bad := range []interface{}{
+		cborTag{tagEncrypt, []interface{}{prot, Headers{HeaderIV: iv}, ct}},
+		[]interface{}{prot, Headers{HeaderIV: iv}},
+		[]interface{}{prot, Headers{HeaderIV: iv}, nil},
+		[]interface{}{prot, Headers{HeaderIV: iv[:12]}, ct},
+		[]interface{}{prot, Headers{HeaderIV: iv, HeaderPartialIV: []byte{1}}, ct},
+		[]interface{}{prot, Headers{HeaderIV: iv, HeaderAlg: AlgDeoxysII128}, ct},
+		[]interface{}{enc(Headers{HeaderAlg: int64(1)}), Headers{HeaderIV: iv}, ct},
+		[]interface{}{enc([]interface{}{}), Headers{HeaderIV: iv}, ct},
+		[]interface{}{[]byte{0xa1}, Headers{HeaderIV: iv}, ct},
+	} {
+		if _, _, _, err := Open0(testKey, enc(bad), nil); err == nil {
+			t.Errorf("Open0(%x) succeeded", enc(bad))
+		}
+	}
+}
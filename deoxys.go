@@ -31,6 +31,26 @@ var sbox = [256]uint8{
 	0x8c, 0xa1, 0x89, 0x0d, 0xbf, 0xe6, 0x42, 0x68, 0x41, 0x99, 0x2d, 0x0f, 0xb0, 0x54, 0xbb, 0x16,
 }
 
+// Inverse AES Sbox
+var invSbox = [256]uint8{
+	0x52, 0x09, 0x6a, 0xd5, 0x30, 0x36, 0xa5, 0x38, 0xbf, 0x40, 0xa3, 0x9e, 0x81, 0xf3, 0xd7, 0xfb,
+	0x7c, 0xe3, 0x39, 0x82, 0x9b, 0x2f, 0xff, 0x87, 0x34, 0x8e, 0x43, 0x44, 0xc4, 0xde, 0xe9, 0xcb,
+	0x54, 0x7b, 0x94, 0x32, 0xa6, 0xc2, 0x23, 0x3d, 0xee, 0x4c, 0x95, 0x0b, 0x42, 0xfa, 0xc3, 0x4e,
+	0x08, 0x2e, 0xa1, 0x66, 0x28, 0xd9, 0x24, 0xb2, 0x76, 0x5b, 0xa2, 0x49, 0x6d, 0x8b, 0xd1, 0x25,
+	0x72, 0xf8, 0xf6, 0x64, 0x86, 0x68, 0x98, 0x16, 0xd4, 0xa4, 0x5c, 0xcc, 0x5d, 0x65, 0xb6, 0x92,
+	0x6c, 0x70, 0x48, 0x50, 0xfd, 0xed, 0xb9, 0xda, 0x5e, 0x15, 0x46, 0x57, 0xa7, 0x8d, 0x9d, 0x84,
+	0x90, 0xd8, 0xab, 0x00, 0x8c, 0xbc, 0xd3, 0x0a, 0xf7, 0xe4, 0x58, 0x05, 0xb8, 0xb3, 0x45, 0x06,
+	0xd0, 0x2c, 0x1e, 0x8f, 0xca, 0x3f, 0x0f, 0x02, 0xc1, 0xaf, 0xbd, 0x03, 0x01, 0x13, 0x8a, 0x6b,
+	0x3a, 0x91, 0x11, 0x41, 0x4f, 0x67, 0xdc, 0xea, 0x97, 0xf2, 0xcf, 0xce, 0xf0, 0xb4, 0xe6, 0x73,
+	0x96, 0xac, 0x74, 0x22, 0xe7, 0xad, 0x35, 0x85, 0xe2, 0xf9, 0x37, 0xe8, 0x1c, 0x75, 0xdf, 0x6e,
+	0x47, 0xf1, 0x1a, 0x71, 0x1d, 0x29, 0xc5, 0x89, 0x6f, 0xb7, 0x62, 0x0e, 0xaa, 0x18, 0xbe, 0x1b,
+	0xfc, 0x56, 0x3e, 0x4b, 0xc6, 0xd2, 0x79, 0x20, 0x9a, 0xdb, 0xc0, 0xfe, 0x78, 0xcd, 0x5a, 0xf4,
+	0x1f, 0xdd, 0xa8, 0x33, 0x88, 0x07, 0xc7, 0x31, 0xb1, 0x12, 0x10, 0x59, 0x27, 0x80, 0xec, 0x5f,
+	0x60, 0x51, 0x7f, 0xa9, 0x19, 0xb5, 0x4a, 0x0d, 0x2d, 0xe5, 0x7a, 0x9f, 0x93, 0xc9, 0x9c, 0xef,
+	0xa0, 0xe0, 0x3b, 0x4d, 0xae, 0x2a, 0xf5, 0xb0, 0xc8, 0xeb, 0xbb, 0x3c, 0x83, 0x53, 0x99, 0x61,
+	0x17, 0x2b, 0x04, 0x7e, 0xba, 0x77, 0xd6, 0x26, 0xe1, 0x69, 0x14, 0x63, 0x55, 0x21, 0x0c, 0x7d,
+}
+
 var rc = [17]uint8{0x2f, 0x5e, 0xbc, 0x63, 0xc6, 0x97, 0x35, 0x6a, 0xd4, 0xb3, 0x7d, 0xfa, 0xef, 0xc5, 0x91, 0x39, 0x72}
 
 const poly = 0x11b
@@ -114,6 +134,62 @@ func encryptBlockGo(subkey [][16]uint8, tweak, in, out []byte) {
 	}
 }
 
+func decryptBlockGo(subkey [][16]uint8, tweak, in, out []byte) {
+	var tw [16]uint8
+	for i := range tw {
+		tw[i] = tweak[i]
+	}
+	for r := 1; r < len(subkey); r++ {
+		tw = permute(tw)
+	}
+
+	// Initialize state
+	var s [16]uint8
+	for i := range s {
+		s[i] = in[i]
+	}
+
+	for r := len(subkey) - 1; r >= 1; r-- {
+		k := &subkey[r]
+
+		// Add tweakey
+		for i := range s {
+			s[i] ^= k[i] ^ tw[i]
+		}
+
+		// inverse mixcolumns
+		for i := 0; i < 16; i += 4 {
+			s0, s1, s2, s3 := s[i], s[i+1], s[i+2], s[i+3]
+			s[i+0] = mul14(s0) ^ mul11(s1) ^ mul13(s2) ^ mul9(s3)
+			s[i+1] = mul14(s1) ^ mul11(s2) ^ mul13(s3) ^ mul9(s0)
+			s[i+2] = mul14(s2) ^ mul11(s3) ^ mul13(s0) ^ mul9(s1)
+			s[i+3] = mul14(s3) ^ mul11(s0) ^ mul13(s1) ^ mul9(s2)
+		}
+
+		// inverse shiftrows
+		s[1], s[5], s[9], s[13] = s[13], s[1], s[5], s[9]
+		s[2], s[6], s[10], s[14] = s[10], s[14], s[2], s[6]
+		s[3], s[7], s[11], s[15] = s[7], s[11], s[15], s[3]
+
+		// inverse subbytes
+		for i, v := range s {
+			s[i] = invSbox[v]
+		}
+
+		// update tweak
+		tw = invPermute(tw)
+	}
+
+	// Add tweakey
+	for i := range s {
+		s[i] ^= subkey[0][i] ^ tw[i]
+	}
+
+	for i := range out {
+		out[i] = s[i]
+	}
+}
+
 func mul2(x uint8) uint8 {
 	t := int32(x) << 1
 	t ^= poly & (t << 23 >> 31)
@@ -127,9 +203,40 @@ func mul3(x uint8) uint8 {
 	return uint8(t)
 }
 
+func mul9(x uint8) uint8 {
+	x8 := mul2(mul2(mul2(x)))
+	return x8 ^ x
+}
+
+func mul11(x uint8) uint8 {
+	x2 := mul2(x)
+	x8 := mul2(mul2(x2))
+	return x8 ^ x2 ^ x
+}
+
+func mul13(x uint8) uint8 {
+	x4 := mul2(mul2(x))
+	x8 := mul2(x4)
+	return x8 ^ x4 ^ x
+}
+
+func mul14(x uint8) uint8 {
+	x2 := mul2(x)
+	x4 := mul2(x2)
+	x8 := mul2(x4)
+	return x8 ^ x4 ^ x2
+}
+
 func permute(p [16]uint8) [16]uint8 {
 	return [16]uint8{
 		p[1], p[6], p[11], p[12], p[5], p[10], p[15], p[0],
 		p[9], p[14], p[3], p[4], p[13], p[2], p[7], p[8],
 	}
 }
+
+func invPermute(p [16]uint8) [16]uint8 {
+	return [16]uint8{
+		p[7], p[0], p[13], p[10], p[11], p[4], p[1], p[14],
+		p[15], p[8], p[5], p[2], p[3], p[12], p[9], p[6],
+	}
+}
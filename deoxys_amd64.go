@@ -5,8 +5,11 @@ import "golang.org/x/sys/cpu"
 //go:noescape
 func encryptBlockAsm(subkey [][16]uint8, tweak, in, out []byte)
 
+//go:noescape
+func decryptBlockAsm(subkey [][16]uint8, tweak, in, out []byte)
+
 func supported() bool {
-	// for AESENC and PSHUFB
+	// for AESENC, AESDEC, AESIMC and PSHUFB
 	return cpu.X86.HasAES && cpu.X86.HasSSSE3
 }
 
@@ -17,3 +20,11 @@ func encryptBlock(subkey [][16]uint8, tweak, in, out []byte) {
 		encryptBlockGo(subkey, tweak, in, out)
 	}
 }
+
+func decryptBlock(subkey [][16]uint8, tweak, in, out []byte) {
+	if supported() {
+		decryptBlockAsm(subkey, tweak, in, out)
+	} else {
+		decryptBlockGo(subkey, tweak, in, out)
+	}
+}
@@ -4,6 +4,10 @@ DATA permutation<>+0(SB)/8, $0x000f0a050c0b0601
 DATA permutation<>+8(SB)/8, $0x0807020d04030e09
 GLOBL permutation<>(SB), (RODATA|NOPTR), $16
 
+DATA invPermutation<>+0(SB)/8, $0x0e01040b0a0d0007
+DATA invPermutation<>+8(SB)/8, $0x06090c030205080f
+GLOBL invPermutation<>(SB), (RODATA|NOPTR), $16
+
 TEXT ·encryptBlockAsm(SB), NOSPLIT, $0-96
     // TODO check bounds of in, out, and tweak?
 
@@ -49,3 +53,77 @@ loop:
 
 return:
     RET
+
+// Decryption uses the equivalent inverse cipher:
+// AESDEC applies InvMixColumns before adding the round key,
+// so every subtweakey except the first and last is passed through AESIMC.
+TEXT ·decryptBlockAsm(SB), NOSPLIT, $0-96
+    MOVQ subkey_len+8(FP), CX
+    MOVQ subkey_base+0(FP), BX
+
+    SUBQ $1, CX
+    JL dec_return
+
+    // Load the ciphertext and tweak
+    MOVQ in_base+48(FP), AX
+    MOVOU (AX), X0
+    MOVQ tweak_base+24(FP), AX
+    MOVOU (AX), X2
+
+    // Load the tweak permutations
+    MOVOU permutation<>(SB), X4
+    MOVOU invPermutation<>(SB), X5
+
+    // Advance the tweak and subkey to the last round
+    MOVQ CX, DX
+dec_advance:
+    TESTQ DX, DX
+    JZ dec_start
+    PSHUFB X4, X2
+    ADDQ $16, BX
+    SUBQ $1, DX
+    JMP dec_advance
+
+dec_start:
+    // XOR the last subtweakey into the ciphertext
+    MOVOU (BX), X1
+    PXOR X2, X1
+    PXOR X1, X0
+
+    TESTQ CX, CX
+    JZ dec_store
+    AESIMC X0, X0
+
+    SUBQ $1, CX
+    JZ dec_last
+
+dec_loop:
+    // Unpermute the tweak
+    PSHUFB X5, X2
+
+    // Get the previous subtweakey
+    SUBQ $16, BX
+    MOVOU (BX), X1
+    PXOR X2, X1
+    AESIMC X1, X1
+
+    // Decrypt
+    AESDEC X1, X0
+
+    SUBQ $1, CX
+    JNZ dec_loop
+
+dec_last:
+    PSHUFB X5, X2
+    SUBQ $16, BX
+    MOVOU (BX), X1
+    PXOR X2, X1
+    AESDECLAST X1, X0
+
+dec_store:
+    // Store the result
+    MOVQ out_base+72(FP), BX
+    MOVOU X0, (BX)
+
+dec_return:
+    RET
@@ -5,3 +5,7 @@ package deoxys
 func encryptBlock(subkey [][16]uint8, tweak, in, out []byte) {
 	encryptBlockGo(subkey, tweak, in, out)
 }
+
+func decryptBlock(subkey [][16]uint8, tweak, in, out []byte) {
+	decryptBlockGo(subkey, tweak, in, out)
+}
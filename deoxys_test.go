@@ -1,6 +1,7 @@
 package deoxys
 
 import (
+	"bytes"
 	"encoding/hex"
 	"testing"
 )
@@ -48,6 +49,40 @@ func TestDeoxys(t *testing.T) {
 	}
 }
 
+func TestDecrypt(t *testing.T) {
+	key := make([]byte, 16)
+	tweak := make([]byte, 16)
+	msg := make([]byte, 16)
+	ct := make([]byte, 16)
+	out := make([]byte, 16)
+	outGo := make([]byte, 16)
+	subkey := make([][16]byte, numRounds)
+
+	for n := 0; n < 64; n++ {
+		for i := 0; i < 16; i++ {
+			key[i] = uint8(n*7 + i)
+			tweak[i] = uint8(n*13 + i*5)
+			msg[i] = uint8(n*31 + i*3)
+		}
+		expandKey(key, subkey)
+
+		encryptBlockGo(subkey, tweak, msg, ct)
+		encryptBlock(subkey, tweak, msg, out)
+		if !bytes.Equal(out, ct) {
+			t.Errorf("%d: encryptBlock = %x, encryptBlockGo = %x", n, out, ct)
+		}
+
+		decryptBlockGo(subkey, tweak, ct, outGo)
+		if !bytes.Equal(outGo, msg) {
+			t.Errorf("%d: decryptBlockGo(%x) = %x, want %x", n, ct, outGo, msg)
+		}
+		decryptBlock(subkey, tweak, ct, out)
+		if !bytes.Equal(out, msg) {
+			t.Errorf("%d: decryptBlock(%x) = %x, want %x", n, ct, out, msg)
+		}
+	}
+}
+
 func TestMul(t *testing.T) {
 	tests := []struct {
 		a, b, r uint
@@ -75,6 +110,21 @@ func TestMul2(t *testing.T) {
 	}
 }
 
+func TestInvMul(t *testing.T) {
+	for x := 0; x < 256; x++ {
+		for _, tt := range []struct {
+			f func(uint8) uint8
+			a uint
+		}{{mul9, 9}, {mul11, 11}, {mul13, 13}, {mul14, 14}} {
+			got := uint(tt.f(uint8(x)))
+			want := mul(tt.a, uint(x))
+			if got != want {
+				t.Errorf("mul%d(%d) = %d, expected %d", tt.a, x, got, want)
+			}
+		}
+	}
+}
+
 func TestMul3(t *testing.T) {
 	for x := 0; x < 256; x++ {
 		got := uint(mul3(uint8(x)))
@@ -125,3 +175,19 @@ func BenchmarkDeoxys(b *testing.B) {
 		encryptBlock(subkey, tweak, msg, out)
 	}
 }
+
+func BenchmarkDecrypt(b *testing.B) {
+	b.StopTimer()
+	key := make([]byte, 16)
+	tweak := make([]byte, 16)
+	msg := make([]byte, 16)
+	out := make([]byte, 16)
+	subkey := make([][16]byte, numRounds)
+
+	expandKey(key, subkey)
+	b.SetBytes(int64(len(msg)))
+	b.StartTimer()
+	for i := 0; i < b.N; i++ {
+		decryptBlock(subkey, tweak, msg, out)
+	}
+}
@@ -0,0 +1,117 @@
+package deoxys
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// maxDRBGRequest is the largest number of bytes
+// produced between two rekeyings of a DRBG.
+const maxDRBGRequest = 1 << 16
+
+// DRBG is a deterministic random bit generator
+// in the style of NIST SP 800-90A CTR_DRBG,
+// with Deoxys-BC as the block cipher.
+//
+// Output blocks are encryptions of zero with a counter as the tweak.
+// After every request the generator replaces its key and counter
+// with fresh output, so a later compromise of the state
+// does not reveal earlier output.
+//
+// Seeded from crypto/rand, a DRBG is a fast CSPRNG;
+// seeded with a fixed value, it is a reproducible one.
+// A DRBG is not safe for concurrent use.
+type DRBG struct {
+	subkey  [numRounds][16]uint8
+	counter [16]uint8
+}
+
+// NewDRBG returns a DRBG instantiated from the given seed
+// and optional personalization string.
+// The seed should contain at least 32 bytes of entropy.
+func NewDRBG(seed, personalization []byte) *DRBG {
+	g := new(DRBG)
+	expandKey(make([]byte, 16), g.subkey[:])
+	g.update(seed, personalization)
+	return g
+}
+
+// Reseed mixes fresh entropy and optional additional input into the state.
+func (g *DRBG) Reseed(entropy, additionalInput []byte) {
+	g.update(entropy, additionalInput)
+}
+
+// Generate fills p with random bytes.
+// A non-empty additionalInput is mixed into the state before
+// any output is produced; callers that need prediction resistance
+// should pass fresh entropy here.
+// Requests larger than 64 KiB are rejected.
+func (g *DRBG) Generate(p, additionalInput []byte) error {
+	if len(p) > maxDRBGRequest {
+		return errors.New("DRBG: request too large")
+	}
+	if len(additionalInput) > 0 {
+		g.update(additionalInput)
+	}
+	var tmp [16]byte
+	var zero [16]byte
+	for len(p) > 0 {
+		g.inc()
+		encryptBlock(g.subkey[:], g.counter[:], zero[:], tmp[:])
+		n := copy(p, tmp[:])
+		p = p[n:]
+	}
+	g.update(additionalInput)
+	return nil
+}
+
+// Read fills p with random bytes. It never returns an error.
+// Each call counts as one or more requests to Generate
+// with no additional input, so splitting a read in two
+// produces different output.
+func (g *DRBG) Read(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		k := len(p)
+		if k > maxDRBGRequest {
+			k = maxDRBGRequest
+		}
+		g.Generate(p[:k], nil)
+		p = p[k:]
+	}
+	return n, nil
+}
+
+func (g *DRBG) inc() {
+	for i := len(g.counter) - 1; i > 0; i-- {
+		g.counter[i]++
+		if g.counter[i] != 0 {
+			return
+		}
+	}
+}
+
+// update derives a new key and counter from the current state
+// and the MAC of the provided data under the current key.
+func (g *DRBG) update(data ...[]byte) {
+	d := mac{subkey: g.subkey}
+	d.Reset()
+	var length [8]byte
+	for _, p := range data {
+		binary.BigEndian.PutUint64(length[:], uint64(len(p)))
+		d.Write(length[:])
+		d.Write(p)
+	}
+	h := d.Sum(nil)
+
+	var key, v [16]byte
+	tweak := g.counter
+	tweak[0] = domainDRBGUpdateKey
+	encryptBlock(g.subkey[:], tweak[:], h, key[:])
+	tweak[0] = domainDRBGUpdateV
+	encryptBlock(g.subkey[:], tweak[:], h, v[:])
+
+	expandKey(key[:], g.subkey[:])
+	g.counter[0] = domainDRBG
+	copy(g.counter[1:], v[:])
+}
@@ -0,0 +1,84 @@
+package deoxys
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func TestDRBG(t *testing.T) {
+	// Two 40-byte reads from the seed seq(32), as this package produced
+	// them when the DRBG was added. TestDRBGInputs covers what they depend on.
+	expected := []string{
+		"30a00fcb391bc4e257d2808336a6ec15b2d098c92eee150dfecf7f7ba937cafc5c75d6a9b3cb9078",
+		"8a3b9da226f5b143644a36b13834776b8d04ae72ba71220151b6e2fc275c91f83038c7f867619d1e",
+	}
+	g := NewDRBG(seq(32), []byte("personalization"))
+	b := make([]byte, 40)
+	for i, want := range expected {
+		g.Read(b)
+		if got := hex.EncodeToString(b); got != want {
+			t.Errorf("Read %d: got %s, want %s", i, got, want)
+		}
+	}
+}
+
+func TestDRBGInputs(t *testing.T) {
+	read := func(g *DRBG) []byte {
+		b := make([]byte, 32)
+		g.Read(b)
+		return b
+	}
+	seed := seq(32)
+	base := read(NewDRBG(seed, nil))
+
+	if b := read(NewDRBG(seed, nil)); !bytes.Equal(b, base) {
+		t.Errorf("same seed: got %x, want %x", b, base)
+	}
+	if b := read(NewDRBG(seed, []byte("x"))); bytes.Equal(b, base) {
+		t.Errorf("personalization string did not change the output")
+	}
+	if b := read(NewDRBG(ones(32), nil)); bytes.Equal(b, base) {
+		t.Errorf("seed did not change the output")
+	}
+
+	g := NewDRBG(seed, nil)
+	g.Reseed(ones(32), nil)
+	if b := read(g); bytes.Equal(b, base) {
+		t.Errorf("Reseed did not change the output")
+	}
+
+	g = NewDRBG(seed, nil)
+	b := make([]byte, 32)
+	if err := g.Generate(b, []byte("additional input")); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(b, base) {
+		t.Errorf("additional input did not change the output")
+	}
+}
+
+func TestDRBGLargeRequest(t *testing.T) {
+	g := NewDRBG(seq(32), nil)
+	if err := g.Generate(make([]byte, maxDRBGRequest+1), nil); err == nil {
+		t.Errorf("Generate(%d bytes) succeeded, expected an error", maxDRBGRequest+1)
+	}
+
+	// Read splits large reads into several requests.
+	b := make([]byte, 3*maxDRBGRequest+5)
+	if n, err := g.Read(b); n != len(b) || err != nil {
+		t.Errorf("Read(%d bytes) = %d, %v", len(b), n, err)
+	}
+	if bytes.Equal(b[:16], b[maxDRBGRequest:maxDRBGRequest+16]) {
+		t.Errorf("consecutive requests produced the same output")
+	}
+}
+
+func BenchmarkDRBG(b *testing.B) {
+	g := NewDRBG(seq(32), nil)
+	buf := make([]byte, 1024)
+	b.SetBytes(int64(len(buf)))
+	for i := 0; i < b.N; i++ {
+		g.Read(buf)
+	}
+}
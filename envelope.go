@@ -0,0 +1,164 @@
+package deoxys
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+)
+
+// An Algorithm identifies the AEAD that sealed an Envelope.
+type Algorithm uint8
+
+const (
+	// AlgDeoxysII128 is Deoxys-II with a 128-bit key, as implemented by AEAD.
+	AlgDeoxysII128 Algorithm = 1
+)
+
+const (
+	envelopeMagic   = "DXEN"
+	envelopeVersion = 1
+	envelopeArmor   = "DEOXYS ENVELOPE"
+)
+
+// Envelope is a self-describing encrypted message.
+// Its binary form is
+//
+//	magic "DXEN" || version (1 byte) || algorithm (1 byte) ||
+//	key ID length (1 byte) || key ID || nonce || ciphertext and tag
+//
+// Everything before the ciphertext is the header,
+// which is authenticated as additional data.
+type Envelope struct {
+	Algorithm  Algorithm
+	KeyID      []byte // at most 255 bytes
+	Nonce      []byte
+	Ciphertext []byte // includes the tag
+}
+
+// SealEnvelope encrypts and authenticates plaintext into an Envelope.
+// The key ID identifies the key to the recipient and is not secret.
+// If nonce is nil, a random nonce is used.
+// The additional data, if any, must be given to Open as well;
+// it is not stored in the envelope.
+func SealEnvelope(aead *AEAD, keyID, nonce, plaintext, additionalData []byte) (*Envelope, error) {
+	if len(keyID) > 255 {
+		return nil, errors.New("SealEnvelope: key ID too long")
+	}
+	if nonce == nil {
+		nonce = make([]byte, NonceSize)
+		if _, err := rand.Read(nonce); err != nil {
+			return nil, err
+		}
+	} else if len(nonce) != NonceSize {
+		return nil, errors.New("SealEnvelope: wrong size nonce")
+	}
+	e := &Envelope{
+		Algorithm: AlgDeoxysII128,
+		KeyID:     append([]byte(nil), keyID...),
+		Nonce:     append([]byte(nil), nonce...),
+	}
+	ad := append(e.header(), additionalData...)
+	e.Ciphertext = aead.Seal(nil, e.Nonce, plaintext, ad)
+	return e, nil
+}
+
+// Open authenticates the envelope and returns the decrypted plaintext.
+// The caller is expected to have picked aead by e.KeyID.
+func (e *Envelope) Open(aead *AEAD, additionalData []byte) ([]byte, error) {
+	if err := e.check(); err != nil {
+		return nil, err
+	}
+	ad := append(e.header(), additionalData...)
+	return aead.Open(nil, e.Nonce, e.Ciphertext, ad)
+}
+
+func (e *Envelope) check() error {
+	if e.Algorithm != AlgDeoxysII128 {
+		return errors.New("Envelope: unknown algorithm")
+	}
+	if len(e.KeyID) > 255 {
+		return errors.New("Envelope: key ID too long")
+	}
+	if len(e.Nonce) != NonceSize {
+		return errors.New("Envelope: wrong size nonce")
+	}
+	if len(e.Ciphertext) < TagSize {
+		return errors.New("Envelope: ciphertext too short")
+	}
+	return nil
+}
+
+func (e *Envelope) header() []byte {
+	b := make([]byte, 0, len(envelopeMagic)+3+len(e.KeyID)+len(e.Nonce))
+	b = append(b, envelopeMagic...)
+	b = append(b, envelopeVersion, byte(e.Algorithm), byte(len(e.KeyID)))
+	b = append(b, e.KeyID...)
+	b = append(b, e.Nonce...)
+	return b
+}
+
+// MarshalBinary implements the encoding.BinaryMarshaler interface.
+func (e *Envelope) MarshalBinary() ([]byte, error) {
+	if err := e.check(); err != nil {
+		return nil, err
+	}
+	return append(e.header(), e.Ciphertext...), nil
+}
+
+// UnmarshalBinary implements the encoding.BinaryUnmarshaler interface.
+// It rejects unknown versions and algorithms.
+func (e *Envelope) UnmarshalBinary(data []byte) error {
+	p := data
+	if len(p) < len(envelopeMagic)+3 || string(p[:len(envelopeMagic)]) != envelopeMagic {
+		return errors.New("Envelope: not an envelope")
+	}
+	p = p[len(envelopeMagic):]
+	if p[0] != envelopeVersion {
+		return errors.New("Envelope: unsupported version")
+	}
+	alg := Algorithm(p[1])
+	if alg != AlgDeoxysII128 {
+		return errors.New("Envelope: unknown algorithm")
+	}
+	n := int(p[2])
+	p = p[3:]
+	if len(p) < n+NonceSize+TagSize {
+		return errors.New("Envelope: truncated envelope")
+	}
+	*e = Envelope{
+		Algorithm:  alg,
+		KeyID:      append([]byte(nil), p[:n]...),
+		Nonce:      append([]byte(nil), p[n:n+NonceSize]...),
+		Ciphertext: append([]byte(nil), p[n+NonceSize:]...),
+	}
+	return nil
+}
+
+// MarshalText returns the envelope in ASCII-armored form,
+// a PEM block of type "DEOXYS ENVELOPE".
+// It implements the encoding.TextMarshaler interface.
+func (e *Envelope) MarshalText() ([]byte, error) {
+	b, err := e.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: envelopeArmor, Bytes: b}), nil
+}
+
+// UnmarshalText parses an ASCII-armored envelope.
+// The input must hold exactly one PEM block, without headers.
+// It implements the encoding.TextUnmarshaler interface.
+func (e *Envelope) UnmarshalText(text []byte) error {
+	if !bytes.HasPrefix(bytes.TrimSpace(text), []byte("-----BEGIN "+envelopeArmor+"-----")) {
+		return errors.New("Envelope: not an armored envelope")
+	}
+	block, rest := pem.Decode(text)
+	if block == nil || block.Type != envelopeArmor || len(block.Headers) != 0 {
+		return errors.New("Envelope: not an armored envelope")
+	}
+	if len(bytes.TrimSpace(rest)) != 0 {
+		return errors.New("Envelope: trailing data after armored envelope")
+	}
+	return e.UnmarshalBinary(block.Bytes)
+}
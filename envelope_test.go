@@ -0,0 +1,135 @@
+package deoxys
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestEnvelopeRoundTrip(t *testing.T) {
+	m := New([]byte("16-byte password"))
+	msg := []byte("A witty saying means nothing.")
+	e, err := SealEnvelope(m, []byte("key-1"), nil, msg, []byte("context"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := e.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var e2 Envelope
+	if err := e2.UnmarshalBinary(b); err != nil {
+		t.Fatal(err)
+	}
+	if string(e2.KeyID) != "key-1" {
+		t.Errorf("KeyID = %q, want %q", e2.KeyID, "key-1")
+	}
+	pt, err := e2.Open(m, []byte("context"))
+	if err != nil || !bytes.Equal(pt, msg) {
+		t.Errorf("Open = %q, %v; want %q", pt, err, msg)
+	}
+	if _, err := e2.Open(m, nil); err == nil {
+		t.Errorf("Open with the wrong additional data succeeded")
+	}
+
+	text, err := e.MarshalText()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(text, []byte("-----BEGIN DEOXYS ENVELOPE-----\n")) {
+		t.Errorf("MarshalText = %q", text)
+	}
+	var e3 Envelope
+	if err := e3.UnmarshalText(text); err != nil {
+		t.Fatal(err)
+	}
+	if pt, err := e3.Open(m, []byte("context")); err != nil || !bytes.Equal(pt, msg) {
+		t.Errorf("Open(armored) = %q, %v; want %q", pt, err, msg)
+	}
+}
+
+func TestEnvelopeFormat(t *testing.T) {
+	m := New(seq(16))
+	e, err := SealEnvelope(m, []byte("k"), seq(NonceSize), []byte("hi"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := e.MarshalBinary()
+	want := "4458454e" + "01" + "01" + "01" + "6b" + hex.EncodeToString(seq(NonceSize))
+	if got := hex.EncodeToString(b); !strings.HasPrefix(got, want) || len(b) != len(want)/2+2+TagSize {
+		t.Errorf("MarshalBinary = %s, want header %s", got, want)
+	}
+	// The ciphertext is Seal with the header as additional data.
+	ct := m.Seal(nil, seq(NonceSize), []byte("hi"), b[:len(want)/2])
+	if !bytes.Equal(e.Ciphertext, ct) {
+		t.Errorf("Ciphertext = %x, want %x", e.Ciphertext, ct)
+	}
+}
+
+func TestEnvelopeHeaderAuthenticated(t *testing.T) {
+	m := New([]byte("16-byte password"))
+	e, _ := SealEnvelope(m, []byte("key-1"), nil, []byte("msg"), nil)
+	b, _ := e.MarshalBinary()
+	b[len(envelopeMagic)+3] ^= 1 // first byte of the key ID
+	var e2 Envelope
+	if err := e2.UnmarshalBinary(b); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e2.Open(m, nil); err == nil {
+		t.Errorf("Open succeeded after the key ID was modified")
+	}
+}
+
+func TestEnvelopeStrictParsing(t *testing.T) {
+	m := New([]byte("16-byte password"))
+	e, _ := SealEnvelope(m, []byte("key-1"), nil, nil, nil)
+	b, _ := e.MarshalBinary()
+	with := func(i int, v byte) []byte {
+		c := append([]byte(nil), b...)
+		c[i] = v
+		return c
+	}
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"magic", with(0, 'X')},
+		{"version", with(4, 2)},
+		{"algorithm", with(5, 0)},
+		{"key ID length", with(6, 200)},
+		{"truncated", b[:len(b)-1]},
+	}
+	for _, tt := range tests {
+		var e2 Envelope
+		if err := e2.UnmarshalBinary(tt.data); err == nil {
+			t.Errorf("%s: UnmarshalBinary succeeded, expected an error", tt.name)
+		}
+	}
+
+	text, _ := e.MarshalText()
+	for _, bad := range []string{
+		"",
+		"junk\n" + string(text),
+		string(text) + "junk\n",
+		strings.Replace(string(text), "DEOXYS ENVELOPE", "PRIVATE KEY", -1),
+		strings.Replace(string(text), "-----\n", "-----\nHeader: x\n\n", 1),
+	} {
+		var e2 Envelope
+		if err := e2.UnmarshalText([]byte(bad)); err == nil {
+			t.Errorf("UnmarshalText(%q) succeeded, expected an error", bad)
+		}
+	}
+}
+
+func TestSealEnvelopeErrors(t *testing.T) {
+	m := New([]byte("16-byte password"))
+	if _, err := SealEnvelope(m, make([]byte, 256), nil, nil, nil); err == nil {
+		t.Errorf("SealEnvelope accepted a 256-byte key ID")
+	}
+	if _, err := SealEnvelope(m, nil, make([]byte, 12), nil, nil); err == nil {
+		t.Errorf("SealEnvelope accepted a 12-byte nonce")
+	}
+}
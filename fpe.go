@@ -0,0 +1,240 @@
+package deoxys
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"math"
+	"math/big"
+	"unicode/utf8"
+)
+
+const (
+	fpeRounds    = 10
+	fpeMaxLen    = 4096
+	fpeMinDomain = 1000000
+)
+
+// FPE implements format-preserving encryption of strings
+// over a fixed alphabet, such as digits or alphanumerics.
+//
+// It follows the structure of NIST SP 800-38G FF1: a ten-round Feistel
+// network on the two halves of the string, treated as numbers in the
+// radix of the alphabet. The round function is a CBC-MAC of the
+// second half under Deoxys-BC, with the round number, the string length
+// and a hash of the radix and caller's tweak in the Deoxys tweak.
+//
+// Like FF1, FPE requires the message space to contain
+// at least one million strings.
+type FPE struct {
+	subkey   [numRounds][16]uint8
+	alphabet []rune
+	index    map[rune]uint16
+	radix    int
+	minLen   int
+	maxLen   int
+}
+
+// NewFPE returns an FPE that encrypts strings of between minLen and maxLen
+// characters, inclusive, drawn from alphabet.
+// The alphabet must contain between 2 and 65536 distinct characters,
+// and len(alphabet)^minLen must be at least one million.
+func NewFPE(key []byte, alphabet string, minLen, maxLen int) (*FPE, error) {
+	f := &FPE{
+		alphabet: []rune(alphabet),
+		index:    make(map[rune]uint16),
+		minLen:   minLen,
+		maxLen:   maxLen,
+	}
+	f.radix = len(f.alphabet)
+	if f.radix < 2 || f.radix > 1<<16 {
+		return nil, errors.New("NewFPE: alphabet must have between 2 and 65536 characters")
+	}
+	for i, r := range f.alphabet {
+		if r == utf8.RuneError {
+			return nil, errors.New("NewFPE: alphabet is not valid UTF-8")
+		}
+		if _, ok := f.index[r]; ok {
+			return nil, errors.New("NewFPE: alphabet contains duplicate characters")
+		}
+		f.index[r] = uint16(i)
+	}
+	if minLen < 2 || maxLen < minLen || maxLen > fpeMaxLen {
+		return nil, errors.New("NewFPE: invalid length range")
+	}
+	size := 1
+	for i := 0; i < minLen && size < fpeMinDomain; i++ {
+		size *= f.radix
+	}
+	if size < fpeMinDomain {
+		return nil, errors.New("NewFPE: domain too small")
+	}
+	expandKey(key, f.subkey[:])
+	return f, nil
+}
+
+// Encrypt encrypts s under the given tweak.
+// The result has the same length as s and is drawn from the same alphabet.
+func (f *FPE) Encrypt(tweak []byte, s string) (string, error) {
+	x, err := f.numerals(s)
+	if err != nil {
+		return "", err
+	}
+	y, err := f.EncryptNumerals(tweak, x)
+	if err != nil {
+		return "", err
+	}
+	return f.string(y), nil
+}
+
+// Decrypt decrypts s, which was encrypted under the given tweak.
+func (f *FPE) Decrypt(tweak []byte, s string) (string, error) {
+	x, err := f.numerals(s)
+	if err != nil {
+		return "", err
+	}
+	y, err := f.DecryptNumerals(tweak, x)
+	if err != nil {
+		return "", err
+	}
+	return f.string(y), nil
+}
+
+// EncryptNumerals encrypts a string of numerals,
+// each of which is an index into the alphabet.
+func (f *FPE) EncryptNumerals(tweak []byte, x []uint16) ([]uint16, error) {
+	return f.crypt(tweak, x, false)
+}
+
+// DecryptNumerals decrypts a string of numerals.
+func (f *FPE) DecryptNumerals(tweak []byte, x []uint16) ([]uint16, error) {
+	return f.crypt(tweak, x, true)
+}
+
+func (f *FPE) numerals(s string) ([]uint16, error) {
+	x := make([]uint16, 0, len(s))
+	for _, r := range s {
+		i, ok := f.index[r]
+		if !ok {
+			return nil, errors.New("FPE: character not in alphabet")
+		}
+		x = append(x, i)
+	}
+	return x, nil
+}
+
+func (f *FPE) string(x []uint16) string {
+	r := make([]rune, len(x))
+	for i, v := range x {
+		r[i] = f.alphabet[v]
+	}
+	return string(r)
+}
+
+func (f *FPE) crypt(tweak []byte, x []uint16, decrypt bool) ([]uint16, error) {
+	n := len(x)
+	if n < f.minLen || n > f.maxLen {
+		return nil, errors.New("FPE: input length out of range")
+	}
+	for _, v := range x {
+		if int(v) >= f.radix {
+			return nil, errors.New("FPE: numeral out of range")
+		}
+	}
+
+	u := n / 2
+	v := n - u
+	radix := big.NewInt(int64(f.radix))
+	modU := new(big.Int).Exp(radix, big.NewInt(int64(u)), nil)
+	modV := new(big.Int).Exp(radix, big.NewInt(int64(v)), nil)
+	// b is the number of bytes needed to hold a half of v numerals,
+	// and d the number of bytes of round function output.
+	b := (int(math.Ceil(float64(v)*math.Log2(float64(f.radix)))) + 7) / 8
+	d := 4*((b+3)/4) + 4
+
+	var t [16]uint8
+	h := sha256.New()
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], uint32(f.radix))
+	h.Write(buf[:])
+	h.Write(tweak)
+	copy(t[4:], h.Sum(nil))
+	binary.BigEndian.PutUint16(t[2:4], uint16(n))
+
+	a := f.num(x[:u])
+	bb := f.num(x[u:])
+	y := new(big.Int)
+	for k := 0; k < fpeRounds; k++ {
+		i := k
+		if decrypt {
+			i = fpeRounds - 1 - k
+		}
+		t[1] = uint8(i)
+		m := modU
+		if i%2 == 1 {
+			m = modV
+		}
+		if !decrypt {
+			f.round(&t, bb, b, d, y)
+			c := new(big.Int).Add(a, y)
+			c.Mod(c, m)
+			a, bb = bb, c
+		} else {
+			f.round(&t, a, b, d, y)
+			c := new(big.Int).Sub(bb, y)
+			c.Mod(c, m)
+			a, bb = c, a
+		}
+	}
+
+	out := make([]uint16, n)
+	f.str(a, out[:u])
+	f.str(bb, out[u:])
+	return out, nil
+}
+
+// round computes the round function of a half into y.
+func (f *FPE) round(t *[16]uint8, half *big.Int, b, d int, y *big.Int) {
+	// CBC-MAC of the half, padded on the left to a whole number of blocks.
+	q := make([]byte, (b+blockSize-1)/blockSize*blockSize)
+	half.FillBytes(q)
+	t[0] = domainFPE
+	var r [16]byte
+	for len(q) > 0 {
+		xor(r[:], q[:blockSize])
+		encryptBlock(f.subkey[:], t[:], r[:], r[:])
+		q = q[blockSize:]
+	}
+
+	// Expand to d bytes.
+	s := append([]byte(nil), r[:]...)
+	t[0] = domainFPEExpand
+	for j := uint32(1); len(s) < d; j++ {
+		in := r
+		binary.BigEndian.PutUint32(in[12:], binary.BigEndian.Uint32(in[12:])^j)
+		var out [16]byte
+		encryptBlock(f.subkey[:], t[:], in[:], out[:])
+		s = append(s, out[:]...)
+	}
+	y.SetBytes(s[:d])
+}
+
+func (f *FPE) num(x []uint16) *big.Int {
+	r := big.NewInt(int64(f.radix))
+	z := new(big.Int)
+	for _, v := range x {
+		z.Mul(z, r)
+		z.Add(z, big.NewInt(int64(v)))
+	}
+	return z
+}
+
+func (f *FPE) str(z *big.Int, out []uint16) {
+	r := big.NewInt(int64(f.radix))
+	z = new(big.Int).Set(z)
+	m := new(big.Int)
+	for i := len(out) - 1; i >= 0; i-- {
+		z.DivMod(z, r, m)
+		out[i] = uint16(m.Int64())
+	}
+}
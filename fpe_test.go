@@ -0,0 +1,370 @@
+package deoxys
+
+import (
+	"crypto/aes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"math/big"
+	"strings"
+	"testing"
+)
+
+const (
+	digits       = "0123456789"
+	alphanumeric = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+)
+
+func TestFPE(t *testing.T) {
+	// Ciphertexts recorded from this package under the key seq(16),
+	// so that stored values keep decrypting to the same plaintexts.
+	// TestFPEReference checks the Feistel network they come from.
+	f, err := NewFPE(seq(16), digits, 6, 19)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		plaintext, ciphertext string
+	}{
+		{"123456", "343974"},
+		{"4111111111111111", "0067164242931485"},
+		{"0000000000000000000", "2358712398491869830"},
+	}
+	for _, tt := range tests {
+		got, err := f.Encrypt([]byte("tweak"), tt.plaintext)
+		if got != tt.ciphertext || err != nil {
+			t.Errorf("Encrypt(%q) = %q, %v; want %q", tt.plaintext, got, err, tt.ciphertext)
+		}
+	}
+
+	g, err := NewFPE(seq(16), alphanumeric, 4, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := g.Encrypt(nil, "HelloWorld42"); got != "2qGedtwnEovN" {
+		t.Errorf("Encrypt(%q) = %q, want %q", "HelloWorld42", got, "2qGedtwnEovN")
+	}
+}
+
+func TestFPERoundTrip(t *testing.T) {
+	key := []byte("16-byte password")
+	tests := []struct {
+		alphabet       string
+		minLen, maxLen int
+	}{
+		{digits, 6, 40},
+		{alphanumeric, 4, 64},
+		{"01", 20, 70},
+		{"αβγδεζηθικ", 6, 12},
+	}
+	for _, tt := range tests {
+		f, err := NewFPE(key, tt.alphabet, tt.minLen, tt.maxLen)
+		if err != nil {
+			t.Errorf("NewFPE(%q, %d, %d): %v", tt.alphabet, tt.minLen, tt.maxLen, err)
+			continue
+		}
+		a := []rune(tt.alphabet)
+		for n := tt.minLen; n <= tt.maxLen; n++ {
+			r := make([]rune, n)
+			for i := range r {
+				r[i] = a[(i*7+n)%len(a)]
+			}
+			s := string(r)
+			c, err := f.Encrypt([]byte("tweak"), s)
+			if err != nil {
+				t.Errorf("Encrypt(%q): %v", s, err)
+				continue
+			}
+			if len([]rune(c)) != n || strings.Trim(c, tt.alphabet) != "" {
+				t.Errorf("Encrypt(%q) = %q, which is not in the same format", s, c)
+			}
+			if c == s {
+				t.Errorf("Encrypt(%q) = %q", s, c)
+			}
+			if p, err := f.Decrypt([]byte("tweak"), c); p != s || err != nil {
+				t.Errorf("Decrypt(Encrypt(%q)) = %q, %v", s, p, err)
+			}
+			if c2, _ := f.Encrypt([]byte("other tweak"), s); c2 == c {
+				t.Errorf("Encrypt(%q) is the same under two tweaks", s)
+			}
+		}
+	}
+}
+
+func TestFPEBounds(t *testing.T) {
+	key := []byte("16-byte password")
+	for _, tt := range []struct {
+		alphabet       string
+		minLen, maxLen int
+	}{
+		{"", 6, 10},
+		{"0", 6, 10},
+		{"00123456789", 6, 10},
+		{digits, 5, 10},   // 10^5 < 10^6
+		{digits, 7, 6},    // empty range
+		{"01", 19, 30},    // 2^19 < 10^6
+		{digits, 6, 5000}, // too long
+	} {
+		if _, err := NewFPE(key, tt.alphabet, tt.minLen, tt.maxLen); err == nil {
+			t.Errorf("NewFPE(%q, %d, %d) succeeded, expected an error", tt.alphabet, tt.minLen, tt.maxLen)
+		}
+	}
+	if _, err := NewFPE(key, "01", 20, 20); err != nil {
+		t.Errorf("NewFPE(binary, 20, 20): %v", err)
+	}
+
+	f, _ := NewFPE(key, digits, 6, 8)
+	for _, s := range []string{"12345", "123456789", "12345a", ""} {
+		if _, err := f.Encrypt(nil, s); err == nil {
+			t.Errorf("Encrypt(%q) succeeded, expected an error", s)
+		}
+		if _, err := f.Decrypt(nil, s); err == nil {
+			t.Errorf("Decrypt(%q) succeeded, expected an error", s)
+		}
+	}
+	if _, err := f.EncryptNumerals(nil, []uint16{1, 2, 3, 4, 5, 10}); err == nil {
+		t.Errorf("EncryptNumerals with a numeral out of range succeeded")
+	}
+
+	// The extremes of the domain round-trip.
+	for _, s := range []string{"000000", "999999", "00000000", "99999999"} {
+		c, err := f.Encrypt(nil, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p, _ := f.Decrypt(nil, c); p != s {
+			t.Errorf("Decrypt(Encrypt(%q)) = %q", s, p)
+		}
+	}
+}
+
+func TestFPEPermutation(t *testing.T) {
+	// Over a whole domain, encryption is a permutation.
+	f, err := NewFPE([]byte("16-byte password"), "01", 20, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[string]bool)
+	x := make([]uint16, 20)
+	for v := 0; v < 1<<12; v++ {
+		for i := range x {
+			x[i] = uint16(v >> uint(i) & 1)
+		}
+		y, err := f.EncryptNumerals(nil, x)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if seen[f.string(y)] {
+			t.Fatalf("EncryptNumerals(%v) collides", x)
+		}
+		seen[f.string(y)] = true
+	}
+}
+
+// ff1Round is the part of an FF1 round that depends on the block cipher:
+// given the round number i and NUM(B) as a b-byte string,
+// it returns the d-byte string S.
+type ff1Round func(i int, numB []byte, d int) []byte
+
+// ff1 is the Feistel network of FF1 from NIST SP 800-38G,
+// Algorithms 7 and 8, with the round function supplied by the caller.
+func ff1(radix int, x []uint16, decrypt bool, round func(n, b int) ff1Round) []uint16 {
+	n := len(x)
+	u, v := n/2, n-n/2
+	r := big.NewInt(int64(radix))
+	num := func(x []uint16) *big.Int {
+		z := new(big.Int)
+		for _, c := range x {
+			z.Mul(z, r).Add(z, big.NewInt(int64(c)))
+		}
+		return z
+	}
+	str := func(z *big.Int, m int) []uint16 {
+		out := make([]uint16, m)
+		z = new(big.Int).Set(z)
+		d := new(big.Int)
+		for i := m - 1; i >= 0; i-- {
+			z.DivMod(z, r, d)
+			out[i] = uint16(d.Int64())
+		}
+		return out
+	}
+	pow := func(m int) *big.Int { return new(big.Int).Exp(r, big.NewInt(int64(m)), nil) }
+
+	// b = ceil(ceil(v * log2(radix)) / 8), d = 4 * ceil(b / 4) + 4
+	bits := new(big.Int).Sub(pow(v), big.NewInt(1)).BitLen()
+	b := (bits + 7) / 8
+	d := 4*((b+3)/4) + 4
+	f := round(n, b)
+	a, bb := x[:u], x[u:]
+	for k := 0; k < 10; k++ {
+		i := k
+		if decrypt {
+			i = 9 - k
+		}
+		m := u
+		if i%2 == 1 {
+			m = v
+		}
+		if !decrypt {
+			numB := make([]byte, b)
+			num(bb).FillBytes(numB)
+			y := new(big.Int).SetBytes(f(i, numB, d))
+			c := new(big.Int).Add(num(a), y)
+			c.Mod(c, pow(m))
+			a, bb = bb, str(c, m)
+		} else {
+			numA := make([]byte, b)
+			num(a).FillBytes(numA)
+			y := new(big.Int).SetBytes(f(i, numA, d))
+			c := new(big.Int).Sub(num(bb), y)
+			c.Mod(c, pow(m))
+			a, bb = str(c, m), a
+		}
+	}
+	return append(append([]uint16(nil), a...), bb...)
+}
+
+// aesFF1 is the FF1 round function with AES, as in SP 800-38G.
+func aesFF1(key []byte, radix int, tweak []byte) func(n, b int) ff1Round {
+	c, err := aes.NewCipher(key)
+	if err != nil {
+		panic(err)
+	}
+	return func(n, b int) ff1Round {
+		p := []byte{1, 2, 1, byte(radix >> 16), byte(radix >> 8), byte(radix), 10, byte(n / 2)}
+		p = binary.BigEndian.AppendUint32(p, uint32(n))
+		p = binary.BigEndian.AppendUint32(p, uint32(len(tweak)))
+		return func(i int, numB []byte, d int) []byte {
+			q := append([]byte(nil), tweak...)
+			q = append(q, make([]byte, (16-(len(tweak)+b+1)%16)%16)...)
+			q = append(q, byte(i))
+			q = append(q, numB...)
+			var r [16]byte
+			for pq := append(append([]byte(nil), p...), q...); len(pq) > 0; pq = pq[16:] {
+				xor(r[:], pq[:16])
+				c.Encrypt(r[:], r[:])
+			}
+			s := append([]byte(nil), r[:]...)
+			for j := uint32(1); len(s) < d; j++ {
+				in := r
+				binary.BigEndian.PutUint32(in[12:], binary.BigEndian.Uint32(in[12:])^j)
+				c.Encrypt(in[:], in[:])
+				s = append(s, in[:]...)
+			}
+			return s[:d]
+		}
+	}
+}
+
+// deoxysFF1 is the round function of FPE as its documentation describes
+// it: a CBC-MAC of NUM(B) under Deoxys-BC, with the round number, the
+// length and a hash of the radix and tweak in the Deoxys tweak,
+// expanded like the AES round function of FF1.
+func deoxysFF1(key []byte, radix int, tweak []byte) func(n, b int) ff1Round {
+	subkey := make([][16]byte, numRounds)
+	expandKey(key, subkey)
+	h := sha256.New()
+	binary.Write(h, binary.BigEndian, uint32(radix))
+	h.Write(tweak)
+	sum := h.Sum(nil)
+	return func(n, b int) ff1Round {
+		return func(i int, numB []byte, d int) []byte {
+			var t [16]byte
+			t[1] = byte(i)
+			binary.BigEndian.PutUint16(t[2:], uint16(n))
+			copy(t[4:], sum)
+
+			t[0] = domainFPE
+			q := append(make([]byte, (16-b%16)%16), numB...)
+			var r [16]byte
+			for ; len(q) > 0; q = q[16:] {
+				xor(r[:], q[:16])
+				encryptBlock(subkey, t[:], r[:], r[:])
+			}
+			t[0] = domainFPEExpand
+			s := append([]byte(nil), r[:]...)
+			for j := uint32(1); len(s) < d; j++ {
+				in := r
+				binary.BigEndian.PutUint32(in[12:], binary.BigEndian.Uint32(in[12:])^j)
+				encryptBlock(subkey, t[:], in[:], in[:])
+				s = append(s, in[:]...)
+			}
+			return s[:d]
+		}
+	}
+}
+
+func TestFF1Samples(t *testing.T) {
+	// The FF1-AES128 samples published by NIST,
+	// which check the Feistel network used by TestFPEReference.
+	key, _ := hex.DecodeString("2b7e151628aed2a6abf7158809cf4f3c")
+	const base36 = "0123456789abcdefghijklmnopqrstuvwxyz"
+	tests := []struct {
+		radix                 int
+		tweak                 string
+		plaintext, ciphertext string
+	}{
+		{10, "", "0123456789", "2433477484"},
+		{10, "39383736353433323130", "0123456789", "6124200773"},
+		{36, "3737373770717273373737", "0123456789abcdefghi", "a9tv40mll9kdu509eum"},
+	}
+	for _, tt := range tests {
+		tweak, _ := hex.DecodeString(tt.tweak)
+		x := make([]uint16, len(tt.plaintext))
+		for i := range x {
+			x[i] = uint16(strings.IndexByte(base36, tt.plaintext[i]))
+		}
+		y := ff1(tt.radix, x, false, aesFF1(key, tt.radix, tweak))
+		got := make([]byte, len(y))
+		for i, c := range y {
+			got[i] = base36[c]
+		}
+		if string(got) != tt.ciphertext {
+			t.Errorf("FF1(%q, tweak %s) = %s, want %s", tt.plaintext, tt.tweak, got, tt.ciphertext)
+		}
+		z := ff1(tt.radix, y, true, aesFF1(key, tt.radix, tweak))
+		for i := range z {
+			if z[i] != x[i] {
+				t.Errorf("FF1 decryption of %s = %v, want %v", tt.ciphertext, z, x)
+				break
+			}
+		}
+	}
+}
+
+func TestFPEReference(t *testing.T) {
+	key := []byte("16-byte password")
+	for _, tt := range []struct {
+		alphabet string
+		n        int
+	}{
+		{digits, 6}, {digits, 7}, {digits, 16}, {digits, 19}, {digits, 40},
+		{alphanumeric, 12}, {alphanumeric, 64}, {"01", 20}, {"01", 70},
+	} {
+		f, err := NewFPE(key, tt.alphabet, tt.n, tt.n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		radix := len([]rune(tt.alphabet))
+		x := make([]uint16, tt.n)
+		for i := range x {
+			x[i] = uint16((i*7 + 3) % radix)
+		}
+		for _, tweak := range []string{"", "tweak"} {
+			round := deoxysFF1(key, radix, []byte(tweak))
+			want := ff1(radix, x, false, round)
+			got, err := f.EncryptNumerals([]byte(tweak), x)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if f.string(got) != f.string(want) {
+				t.Errorf("%q, %d numerals: EncryptNumerals = %v, want %v", tt.alphabet, tt.n, got, want)
+			}
+			back, _ := f.DecryptNumerals([]byte(tweak), want)
+			if ref := ff1(radix, want, true, round); f.string(ref) != f.string(x) || f.string(back) != f.string(x) {
+				t.Errorf("%q, %d numerals: decryption does not match the reference", tt.alphabet, tt.n)
+			}
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package deoxys
+
+const (
+	// HeaderSampleSize is the size of the ciphertext sample
+	// that a header protection mask is computed from.
+	HeaderSampleSize = 16
+
+	// DefaultHeaderMaskSize is the mask size used by QUIC:
+	// one byte for the flags and four for the packet number.
+	DefaultHeaderMaskSize = 5
+)
+
+// HeaderProtector computes header protection masks in the style of
+// QUIC (RFC 9001, section 5.4).
+//
+// The mask is the first bytes of the Deoxys-BC encryption of a 16-byte
+// sample of the packet's ciphertext, under the tweak
+//
+//	domain (1 byte) || 15 zero bytes
+//
+// A tweakable block cipher needs no separate construction for this, as
+// AES and ChaCha20 do in QUIC: the tweak domain keeps the masks
+// independent of everything else computed with the same key. Using a
+// separate key for header protection, as QUIC does, is still recommended;
+// DeriveKey can provide one.
+type HeaderProtector struct {
+	subkey   [numRounds][16]uint8
+	maskSize int
+}
+
+// NewHeaderProtector returns a HeaderProtector using the given 16-byte key,
+// which computes masks of maskSize bytes. It panics if maskSize is not
+// between 1 and 16.
+func NewHeaderProtector(key []byte, maskSize int) *HeaderProtector {
+	if maskSize < 1 || maskSize > blockSize {
+		panic("deoxys: invalid header mask size")
+	}
+	h := &HeaderProtector{maskSize: maskSize}
+	expandKey(key, h.subkey[:])
+	return h
+}
+
+// MaskSize returns the size of the masks computed by h.
+func (h *HeaderProtector) MaskSize() int { return h.maskSize }
+
+// Mask appends the mask for a sample to dst and returns the result.
+// It panics if the sample is not HeaderSampleSize bytes long.
+func (h *HeaderProtector) Mask(dst, sample []byte) []byte {
+	if len(sample) != HeaderSampleSize {
+		panic("deoxys: wrong size header sample")
+	}
+	var tweak [16]uint8
+	var out [blockSize]byte
+	tweak[0] = domainHeaderProtection
+	encryptBlock(h.subkey[:], tweak[:], sample, out[:])
+	return append(dst, out[:h.maskSize]...)
+}
@@ -0,0 +1,195 @@
+package deoxys
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"testing"
+)
+
+func TestHeaderProtector(t *testing.T) {
+	// RFC 9001 only has AES and ChaCha20 masks, so the expected mask is
+	// this package's output for the key seq(16) and the client Initial
+	// sample from appendix A.2. It must be the Deoxys-BC encryption of the
+	// sample under the header protection tweak, truncated to the mask size.
+	sample, _ := hex.DecodeString("d1b1c98dd7689fb8ec11d242b123dc9b")
+	const want = "6e45dcc47ccf6308db6ffd28c025423f"
+	var subkey [numRounds][16]uint8
+	var block [16]byte
+	expandKey(seq(16), subkey[:])
+	encryptBlock(subkey[:], []byte{domainHeaderProtection, 15: 0}, sample, block[:])
+	if got := hex.EncodeToString(block[:]); got != want {
+		t.Errorf("E(sample) = %s, want %s", got, want)
+	}
+
+	full := NewHeaderProtector(seq(16), 16).Mask(nil, sample)
+	if got := hex.EncodeToString(full); got != want {
+		t.Errorf("Mask = %s, want %s", got, want)
+	}
+	h := NewHeaderProtector(seq(16), DefaultHeaderMaskSize)
+	if got := h.Mask([]byte("prefix"), sample); !bytes.Equal(got, append([]byte("prefix"), full[:5]...)) {
+		t.Errorf("Mask did not append a %d-byte mask: %x", DefaultHeaderMaskSize, got)
+	}
+
+	// The mask must differ from a plain Deoxys-BC block under the zero tweak,
+	// and from the same sample under another key.
+	var zero [16]byte
+	c := NewHeaderProtector(seq(16), 16)
+	encryptBlock(c.subkey[:], zero[:], sample, block[:])
+	if bytes.Equal(full, block[:]) {
+		t.Errorf("mask does not use its tweak domain")
+	}
+	if bytes.Equal(full, NewHeaderProtector(make([]byte, 16), 16).Mask(nil, sample)) {
+		t.Errorf("mask does not depend on the key")
+	}
+}
+
+func TestHeaderProtectorPanics(t *testing.T) {
+	for _, size := range []int{0, -1, 17} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("NewHeaderProtector accepted mask size %d", size)
+				}
+			}()
+			NewHeaderProtector(seq(16), size)
+		}()
+	}
+	h := NewHeaderProtector(seq(16), DefaultHeaderMaskSize)
+	for _, n := range []int{0, 15, 17} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Mask accepted a %d-byte sample", n)
+				}
+			}()
+			h.Mask(nil, make([]byte, n))
+		}()
+	}
+}
+
+// The rest of this file follows the packet protection of RFC 9001,
+// sections 5.3 and 5.4, with Deoxys-II as the AEAD and HeaderProtector
+// for header protection.
+
+type packetKeys struct {
+	aead *AEAD
+	iv   []byte
+	hp   *HeaderProtector
+}
+
+func newPacketKeys(secret []byte) *packetKeys {
+	return &packetKeys{
+		aead: New(DeriveKey(secret, []byte("quic key"), nil, 16)),
+		iv:   DeriveKey(secret, []byte("quic iv"), nil, NonceSize),
+		hp:   NewHeaderProtector(DeriveKey(secret, []byte("quic hp"), nil, 16), DefaultHeaderMaskSize),
+	}
+}
+
+// nonce is the IV exclusive-ored with the packet number (section 5.3).
+func (k *packetKeys) nonce(pn uint64) []byte {
+	nonce := append([]byte(nil), k.iv...)
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], pn)
+	for i := range b {
+		nonce[NonceSize-8+i] ^= b[i]
+	}
+	return nonce
+}
+
+// headerMask returns the bits of the first byte that header
+// protection covers: 4 for long headers and 5 for short headers.
+func headerMask(first byte) byte {
+	if first&0x80 != 0 {
+		return 0x0f
+	}
+	return 0x1f
+}
+
+// protect seals a packet whose header ends with a packet number
+// of pnLen bytes, encoded in the low bits of the first byte.
+func (k *packetKeys) protect(header []byte, pn uint64, pnLen int, payload []byte) []byte {
+	pnOffset := len(header)
+	packet := append([]byte(nil), header...)
+	for i := pnLen - 1; i >= 0; i-- {
+		packet = append(packet, byte(pn>>(8*i)))
+	}
+	packet = k.aead.Seal(packet, k.nonce(pn), payload, packet)
+
+	// Section 5.4.2: the sample starts 4 bytes after the start of
+	// the packet number, whatever its length.
+	sampleOffset := pnOffset + 4
+	mask := k.hp.Mask(nil, packet[sampleOffset:sampleOffset+HeaderSampleSize])
+
+	// Section 5.4.1.
+	packet[0] ^= mask[0] & headerMask(packet[0])
+	for i := 0; i < pnLen; i++ {
+		packet[pnOffset+i] ^= mask[1+i]
+	}
+	return packet
+}
+
+// unprotect reverses protect, given the length of the header
+// before the packet number.
+func (k *packetKeys) unprotect(packet []byte, pnOffset int) (uint64, []byte, error) {
+	packet = append([]byte(nil), packet...)
+	sampleOffset := pnOffset + 4
+	mask := k.hp.Mask(nil, packet[sampleOffset:sampleOffset+HeaderSampleSize])
+	packet[0] ^= mask[0] & headerMask(packet[0])
+	pnLen := int(packet[0]&0x03) + 1
+	var pn uint64
+	for i := 0; i < pnLen; i++ {
+		packet[pnOffset+i] ^= mask[1+i]
+		pn = pn<<8 | uint64(packet[pnOffset+i])
+	}
+	header := packet[:pnOffset+pnLen]
+	payload, err := k.aead.Open(nil, k.nonce(pn), packet[len(header):], header)
+	return pn, payload, err
+}
+
+func TestHeaderProtectionPackets(t *testing.T) {
+	keys := newPacketKeys([]byte("client in secret"))
+	// The sample needs 4 bytes after the packet number offset plus
+	// 16 bytes, which the tag always covers when the packet number
+	// and payload are at least 4 bytes long together.
+	payload := []byte("\x06\x00\x40\xf1\x01\x00\x00\xed\x03\x03")
+	dcid, _ := hex.DecodeString("8394c8f03e515708")
+
+	for _, tt := range []struct {
+		name   string
+		header []byte
+	}{
+		// A long header Initial packet: flags, version, DCID, SCID,
+		// token length and a 2-byte length field.
+		{"long", append(append([]byte{0xc0, 0, 0, 0, 1, 8}, dcid...), 0, 0, 0x40, 0x75)},
+		// A short header packet with the same DCID.
+		{"short", append([]byte{0x40}, dcid...)},
+	} {
+		for pnLen := 1; pnLen <= 4; pnLen++ {
+			pn := uint64(0x654360564)
+			pn &= 1<<(8*pnLen) - 1
+			header := append([]byte(nil), tt.header...)
+			header[0] |= byte(pnLen - 1)
+
+			packet := keys.protect(header, pn, pnLen, payload)
+			if packet[0]&^headerMask(packet[0]) != header[0]&^headerMask(header[0]) {
+				t.Errorf("%s, %d-byte packet number: unprotected bits of the first byte changed", tt.name, pnLen)
+			}
+			if !bytes.Equal(packet[1:len(header)], header[1:]) {
+				t.Errorf("%s, %d-byte packet number: header protection changed the header", tt.name, pnLen)
+			}
+
+			gotPN, gotPayload, err := keys.unprotect(packet, len(header))
+			if err != nil || gotPN != pn || !bytes.Equal(gotPayload, payload) {
+				t.Errorf("%s, %d-byte packet number: unprotect = %#x, %q, %v", tt.name, pnLen, gotPN, gotPayload, err)
+			}
+
+			// Changing the sample changes the recovered packet number
+			// length or value, and the packet fails to open.
+			packet[len(header)+4] ^= 1
+			if _, _, err := keys.unprotect(packet, len(header)); err == nil {
+				t.Errorf("%s, %d-byte packet number: modified packet opened", tt.name, pnLen)
+			}
+		}
+	}
+}
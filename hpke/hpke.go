@@ -0,0 +1,371 @@
+// Package hpke implements Hybrid Public Key Encryption (RFC 9180)
+// with DHKEM(X25519, HKDF-SHA256), HKDF-SHA256 and Deoxys-II-128.
+//
+// Deoxys-II is not in the IANA HPKE AEAD registry,
+// so it uses the unregistered identifier AEADDeoxysII128,
+// with Nk = 16, Nn = 15 and Nt = 16.
+// Messages sealed with this package can only be opened by
+// implementations that agree on that identifier.
+//
+// All four modes are supported: base, psk, auth and auth_psk.
+//
+// RFC 9180:
+//
+//	https://www.rfc-editor.org/rfc/rfc9180.html
+package hpke
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"math"
+
+	"github.com/magical/deoxys"
+	"golang.org/x/crypto/hkdf"
+)
+
+// Algorithm identifiers.
+const (
+	KEMX25519HKDFSHA256 uint16 = 0x0020
+	KDFHKDFSHA256       uint16 = 0x0001
+	AEADDeoxysII128     uint16 = 0xffde // not registered with IANA
+)
+
+// Modes.
+const (
+	ModeBase    uint8 = 0x00
+	ModePSK     uint8 = 0x01
+	ModeAuth    uint8 = 0x02
+	ModeAuthPSK uint8 = 0x03
+)
+
+const (
+	nSecret = 32 // KEM shared secret size
+	nEnc    = 32 // encapsulated key size
+	nH      = 32 // HKDF-SHA256 output size
+	nK      = 16
+	nN      = deoxys.NonceSize
+	nT      = deoxys.TagSize
+)
+
+var (
+	kemSuiteID  = []byte{'K', 'E', 'M', 0x00, 0x20}
+	hpkeSuiteID = []byte{'H', 'P', 'K', 'E', 0x00, 0x20, 0x00, 0x01, 0xff, 0xde}
+)
+
+func labeledExtract(suiteID, salt []byte, label string, ikm []byte) []byte {
+	in := make([]byte, 0, 7+len(suiteID)+len(label)+len(ikm))
+	in = append(in, "HPKE-v1"...)
+	in = append(in, suiteID...)
+	in = append(in, label...)
+	in = append(in, ikm...)
+	return hkdf.Extract(sha256.New, in, salt)
+}
+
+func labeledExpand(suiteID, prk []byte, label string, info []byte, n int) []byte {
+	in := make([]byte, 2, 9+len(suiteID)+len(label)+len(info))
+	binary.BigEndian.PutUint16(in, uint16(n))
+	in = append(in, "HPKE-v1"...)
+	in = append(in, suiteID...)
+	in = append(in, label...)
+	in = append(in, info...)
+	out := make([]byte, n)
+	if _, err := hkdf.Expand(sha256.New, prk, in).Read(out); err != nil {
+		panic("hpke: " + err.Error())
+	}
+	return out
+}
+
+// DeriveKeyPair deterministically derives an X25519 key pair
+// from at least 32 bytes of input keying material,
+// as DeriveKeyPair in section 7.1.3 of RFC 9180.
+func DeriveKeyPair(ikm []byte) (*ecdh.PrivateKey, error) {
+	if len(ikm) < nSecret {
+		return nil, errors.New("hpke: input keying material too short")
+	}
+	prk := labeledExtract(kemSuiteID, nil, "dkp_prk", ikm)
+	sk := labeledExpand(kemSuiteID, prk, "sk", nil, 32)
+	return ecdh.X25519().NewPrivateKey(sk)
+}
+
+func checkKey(k interface{ Curve() ecdh.Curve }) error {
+	if k.Curve() != ecdh.X25519() {
+		return errors.New("hpke: not an X25519 key")
+	}
+	return nil
+}
+
+func extractAndExpand(dh, kemContext []byte) []byte {
+	prk := labeledExtract(kemSuiteID, nil, "eae_prk", dh)
+	return labeledExpand(kemSuiteID, prk, "shared_secret", kemContext, nSecret)
+}
+
+// encap runs Encap, or AuthEncap if skS is not nil,
+// with the ephemeral key skE.
+func encap(pkR *ecdh.PublicKey, skS, skE *ecdh.PrivateKey) (shared, enc []byte, err error) {
+	if err := checkKey(pkR); err != nil {
+		return nil, nil, err
+	}
+	dh, err := skE.ECDH(pkR)
+	if err != nil {
+		return nil, nil, err
+	}
+	enc = skE.PublicKey().Bytes()
+	kemContext := append(append([]byte(nil), enc...), pkR.Bytes()...)
+	if skS != nil {
+		if err := checkKey(skS); err != nil {
+			return nil, nil, err
+		}
+		dh2, err := skS.ECDH(pkR)
+		if err != nil {
+			return nil, nil, err
+		}
+		dh = append(dh, dh2...)
+		kemContext = append(kemContext, skS.PublicKey().Bytes()...)
+	}
+	return extractAndExpand(dh, kemContext), enc, nil
+}
+
+// decap runs Decap, or AuthDecap if pkS is not nil.
+func decap(enc []byte, skR *ecdh.PrivateKey, pkS *ecdh.PublicKey) ([]byte, error) {
+	if err := checkKey(skR); err != nil {
+		return nil, err
+	}
+	pkE, err := ecdh.X25519().NewPublicKey(enc)
+	if err != nil {
+		return nil, err
+	}
+	dh, err := skR.ECDH(pkE)
+	if err != nil {
+		return nil, err
+	}
+	kemContext := append(append([]byte(nil), enc...), skR.PublicKey().Bytes()...)
+	if pkS != nil {
+		if err := checkKey(pkS); err != nil {
+			return nil, err
+		}
+		dh2, err := skR.ECDH(pkS)
+		if err != nil {
+			return nil, err
+		}
+		dh = append(dh, dh2...)
+		kemContext = append(kemContext, pkS.Bytes()...)
+	}
+	return extractAndExpand(dh, kemContext), nil
+}
+
+// context is the state shared by Sender and Recipient.
+type context struct {
+	aead           *deoxys.AEAD
+	baseNonce      [nN]byte
+	seq            uint64
+	exporterSecret []byte
+}
+
+func keySchedule(mode uint8, shared, info, psk, pskID []byte) (*context, error) {
+	hasPSK := mode == ModePSK || mode == ModeAuthPSK
+	if (len(psk) == 0) != (len(pskID) == 0) {
+		return nil, errors.New("hpke: inconsistent PSK inputs")
+	}
+	if hasPSK != (len(psk) != 0) {
+		return nil, errors.New("hpke: PSK input does not match mode")
+	}
+	if hasPSK && len(psk) < 32 {
+		return nil, errors.New("hpke: PSK too short")
+	}
+
+	ksc := []byte{mode}
+	ksc = append(ksc, labeledExtract(hpkeSuiteID, nil, "psk_id_hash", pskID)...)
+	ksc = append(ksc, labeledExtract(hpkeSuiteID, nil, "info_hash", info)...)
+	secret := labeledExtract(hpkeSuiteID, shared, "secret", psk)
+
+	c := &context{
+		aead:           deoxys.New(labeledExpand(hpkeSuiteID, secret, "key", ksc, nK)),
+		exporterSecret: labeledExpand(hpkeSuiteID, secret, "exp", ksc, nH),
+	}
+	copy(c.baseNonce[:], labeledExpand(hpkeSuiteID, secret, "base_nonce", ksc, nN))
+	return c, nil
+}
+
+// nextNonce returns the nonce for the current sequence number.
+// The sequence number is limited to 64 bits, well below the 2^120
+// allowed by a 15-byte nonce.
+func (c *context) nextNonce() ([]byte, error) {
+	if c.seq == math.MaxUint64 {
+		return nil, errors.New("hpke: message limit reached")
+	}
+	nonce := c.baseNonce
+	var seq [8]byte
+	binary.BigEndian.PutUint64(seq[:], c.seq)
+	for i, b := range seq {
+		nonce[nN-8+i] ^= b
+	}
+	return nonce[:], nil
+}
+
+func (c *context) export(exporterContext []byte, length int) ([]byte, error) {
+	if length < 0 || length > 255*nH {
+		return nil, errors.New("hpke: invalid export length")
+	}
+	return labeledExpand(hpkeSuiteID, c.exporterSecret, "sec", exporterContext, length), nil
+}
+
+// A Sender is an HPKE context for encrypting messages to a recipient.
+// Messages must be opened in the order they were sealed.
+type Sender struct {
+	c *context
+}
+
+// Seal encrypts and authenticates plaintext, with aad as additional data.
+func (s *Sender) Seal(aad, plaintext []byte) ([]byte, error) {
+	nonce, err := s.c.nextNonce()
+	if err != nil {
+		return nil, err
+	}
+	s.c.seq++
+	return s.c.aead.Seal(nil, nonce, plaintext, aad), nil
+}
+
+// Export derives length bytes of secret from the context,
+// as the secret export interface in section 5.3 of RFC 9180.
+func (s *Sender) Export(exporterContext []byte, length int) ([]byte, error) {
+	return s.c.export(exporterContext, length)
+}
+
+// A Recipient is an HPKE context for decrypting messages from a Sender.
+type Recipient struct {
+	c *context
+}
+
+// Open authenticates and decrypts ciphertext, with aad as additional data.
+// The sequence number only advances when Open succeeds.
+func (r *Recipient) Open(aad, ciphertext []byte) ([]byte, error) {
+	nonce, err := r.c.nextNonce()
+	if err != nil {
+		return nil, err
+	}
+	pt, err := r.c.aead.Open(nil, nonce, ciphertext, aad)
+	if err != nil {
+		return nil, err
+	}
+	r.c.seq++
+	return pt, nil
+}
+
+// Export derives length bytes of secret from the context,
+// as the secret export interface in section 5.3 of RFC 9180.
+func (r *Recipient) Export(exporterContext []byte, length int) ([]byte, error) {
+	return r.c.export(exporterContext, length)
+}
+
+func setupS(mode uint8, pkR *ecdh.PublicKey, info, psk, pskID []byte, skS, skE *ecdh.PrivateKey) ([]byte, *Sender, error) {
+	if skE == nil {
+		var err error
+		if skE, err = ecdh.X25519().GenerateKey(rand.Reader); err != nil {
+			return nil, nil, err
+		}
+	}
+	shared, enc, err := encap(pkR, skS, skE)
+	if err != nil {
+		return nil, nil, err
+	}
+	c, err := keySchedule(mode, shared, info, psk, pskID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return enc, &Sender{c}, nil
+}
+
+func setupR(mode uint8, enc []byte, skR *ecdh.PrivateKey, info, psk, pskID []byte, pkS *ecdh.PublicKey) (*Recipient, error) {
+	shared, err := decap(enc, skR, pkS)
+	if err != nil {
+		return nil, err
+	}
+	c, err := keySchedule(mode, shared, info, psk, pskID)
+	if err != nil {
+		return nil, err
+	}
+	return &Recipient{c}, nil
+}
+
+// SetupBaseS sets up a base mode context for encrypting to pkR.
+// It returns the encapsulated key enc, which the recipient needs
+// to set up the matching context.
+func SetupBaseS(pkR *ecdh.PublicKey, info []byte) (enc []byte, s *Sender, err error) {
+	return setupS(ModeBase, pkR, info, nil, nil, nil, nil)
+}
+
+// SetupBaseR sets up a base mode context for decrypting with skR.
+func SetupBaseR(enc []byte, skR *ecdh.PrivateKey, info []byte) (*Recipient, error) {
+	return setupR(ModeBase, enc, skR, info, nil, nil, nil)
+}
+
+// SetupPSKS sets up a psk mode context for encrypting to pkR,
+// authenticated by a pre-shared key of at least 32 bytes
+// and its identifier.
+func SetupPSKS(pkR *ecdh.PublicKey, info, psk, pskID []byte) (enc []byte, s *Sender, err error) {
+	return setupS(ModePSK, pkR, info, psk, pskID, nil, nil)
+}
+
+// SetupPSKR sets up a psk mode context for decrypting with skR.
+func SetupPSKR(enc []byte, skR *ecdh.PrivateKey, info, psk, pskID []byte) (*Recipient, error) {
+	return setupR(ModePSK, enc, skR, info, psk, pskID, nil)
+}
+
+// SetupAuthS sets up an auth mode context for encrypting to pkR,
+// authenticated by the sender's private key skS.
+func SetupAuthS(pkR *ecdh.PublicKey, info []byte, skS *ecdh.PrivateKey) (enc []byte, s *Sender, err error) {
+	if skS == nil {
+		return nil, nil, errors.New("hpke: missing sender private key")
+	}
+	return setupS(ModeAuth, pkR, info, nil, nil, skS, nil)
+}
+
+// SetupAuthR sets up an auth mode context for decrypting with skR
+// messages from the holder of the private key matching pkS.
+func SetupAuthR(enc []byte, skR *ecdh.PrivateKey, info []byte, pkS *ecdh.PublicKey) (*Recipient, error) {
+	if pkS == nil {
+		return nil, errors.New("hpke: missing sender public key")
+	}
+	return setupR(ModeAuth, enc, skR, info, nil, nil, pkS)
+}
+
+// SetupAuthPSKS sets up an auth_psk mode context,
+// combining SetupPSKS and SetupAuthS.
+func SetupAuthPSKS(pkR *ecdh.PublicKey, info, psk, pskID []byte, skS *ecdh.PrivateKey) (enc []byte, s *Sender, err error) {
+	if skS == nil {
+		return nil, nil, errors.New("hpke: missing sender private key")
+	}
+	return setupS(ModeAuthPSK, pkR, info, psk, pskID, skS, nil)
+}
+
+// SetupAuthPSKR sets up an auth_psk mode context,
+// combining SetupPSKR and SetupAuthR.
+func SetupAuthPSKR(enc []byte, skR *ecdh.PrivateKey, info, psk, pskID []byte, pkS *ecdh.PublicKey) (*Recipient, error) {
+	if pkS == nil {
+		return nil, errors.New("hpke: missing sender public key")
+	}
+	return setupR(ModeAuthPSK, enc, skR, info, psk, pskID, pkS)
+}
+
+// Seal is single-shot base mode encryption to pkR.
+// It returns the encapsulated key and the ciphertext.
+func Seal(pkR *ecdh.PublicKey, info, aad, plaintext []byte) (enc, ciphertext []byte, err error) {
+	enc, s, err := SetupBaseS(pkR, info)
+	if err != nil {
+		return nil, nil, err
+	}
+	ciphertext, err = s.Seal(aad, plaintext)
+	return enc, ciphertext, err
+}
+
+// Open is single-shot base mode decryption with skR.
+func Open(skR *ecdh.PrivateKey, enc, info, aad, ciphertext []byte) ([]byte, error) {
+	r, err := SetupBaseR(enc, skR, info)
+	if err != nil {
+		return nil, err
+	}
+	return r.Open(aad, ciphertext)
+}
@@ -0,0 +1,297 @@
+package hpke
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/hex"
+	"testing"
+)
+
+func unhex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func newKey(t *testing.T) *ecdh.PrivateKey {
+	t.Helper()
+	k, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+var (
+	testPSK   = bytes.Repeat([]byte{4}, 32)
+	testPSKID = []byte("Ennyn Durin aran Moria")
+	testInfo  = []byte("Ode on a Grecian Urn")
+	testMsg   = []byte("Beauty is truth, truth beauty")
+)
+
+// setup returns a matching sender and recipient for mode.
+// The sender uses skE if it is not nil.
+func setup(t *testing.T, mode uint8, skR, skS, skE *ecdh.PrivateKey) (enc []byte, s *Sender, r *Recipient) {
+	t.Helper()
+	var psk, pskID []byte
+	var pkS *ecdh.PublicKey
+	if mode == ModePSK || mode == ModeAuthPSK {
+		psk, pskID = testPSK, testPSKID
+	}
+	if mode == ModeAuth || mode == ModeAuthPSK {
+		pkS = skS.PublicKey()
+	} else {
+		skS = nil
+	}
+	enc, s, err := setupS(mode, skR.PublicKey(), testInfo, psk, pskID, skS, skE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err = setupR(mode, enc, skR, testInfo, psk, pskID, pkS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return enc, s, r
+}
+
+// TestKEMVectors checks DHKEM(X25519, HKDF-SHA256) against RFC 9180,
+// appendix A.1.1. The rest of that appendix uses AES-128-GCM,
+// so only the KEM outputs apply here.
+func TestKEMVectors(t *testing.T) {
+	skE, err := DeriveKeyPair(unhex("7268600d403fce431561aef583ee1613527cff655c1343f29812e66706df3234"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	skR, err := DeriveKeyPair(unhex("6db9df30aa07dd42ee5e8181afdb977e538f5e1fec8a06223f33f7013e525037"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name      string
+		got, want []byte
+	}{
+		{"skEm", skE.Bytes(), unhex("52c4a758a802cd8b936eceea314432798d5baf2d7e9235dc084ab1b9cfa2f736")},
+		{"skRm", skR.Bytes(), unhex("4612c550263fc8ad58375df3f557aac531d26850903e55a9f23f21d8534e8ac8")},
+		{"pkRm", skR.PublicKey().Bytes(), unhex("3948cfe0ad1ddb695d780e59077195da6c56506b027329794ab02bca80815c4d")},
+	} {
+		if !bytes.Equal(tt.got, tt.want) {
+			t.Errorf("%s = %x, want %x", tt.name, tt.got, tt.want)
+		}
+	}
+
+	const wantEnc = "37fda3567bdbd628e88668c3c8d7e97d1d1253b6d4ea6d44c150f741f1bf4431"
+	const wantShared = "fe0e18c9f024ce43799ae393c7e8fe8fce9d218875e8227b0187c04e7d2ea1fc"
+	shared, enc, err := encap(skR.PublicKey(), nil, skE)
+	if err != nil || hex.EncodeToString(enc) != wantEnc || hex.EncodeToString(shared) != wantShared {
+		t.Errorf("Encap = %x, %x, %v; want %s, %s", shared, enc, err, wantShared, wantEnc)
+	}
+	shared, err = decap(unhex(wantEnc), skR, nil)
+	if err != nil || hex.EncodeToString(shared) != wantShared {
+		t.Errorf("Decap = %x, %v; want %s", shared, err, wantShared)
+	}
+}
+
+// Regression vectors generated with this implementation; they guard
+// against changes but are not checked against any other implementation.
+// The KEM alone is checked against RFC 9180 in TestKEMVectors.
+// The key pairs are derived with DeriveKeyPair from 32 bytes of 1s (skE),
+// 2s (skR) and 3s (skS); ct0 and ct1 seal testMsg with additional data
+// "Count-0" and "Count-1", and exp is Export("TestContext", 32).
+var vectors = []struct {
+	mode     uint8
+	ct0, ct1 string
+	exp      string
+}{
+	{
+		ModeBase,
+		"1bab6c31e431851a983e3a8364e15a5830a5341f0ba71bbade87fe075fd7f127520e725c1939f1582aa7f638dd",
+		"ef70a0913fc70ee5314c6210bf2cd68e06027a322040e657976afb80256b3de2c793081abddf874fb23dd1ce45",
+		"5a5b7afba21e6767d498d2246cf6cc9cef6ac34ace2bc7608daf67013e2a7af5",
+	},
+	{
+		ModePSK,
+		"e96acbed4be0113bb23cb5ad25f8917db889e8a900dbcb8c69c8d1b00c30ee9224c7b0fa3f7ae0fb2edae7f6b5",
+		"c1b8efd1b9c9d1d4072c66e1ca2d696888fcbcb8e2f611b386f230a2998fe249dbc77f62b2d0ca645e366a784a",
+		"e8479c29f3a7d24ca8e1c6ac31a66a4d9208adc452a79aa209df9b82053dcfa6",
+	},
+	{
+		ModeAuth,
+		"903bbed4f577858fa79b606c2e2dcbd9a0d431976751189a02f73828097b92a5fb5a71689da7222ab7589c924c",
+		"52c3afdba09fb42cb430f478df3e17d6ad390c4241f6798aa9b00fd266272a824c7a915254b3854ccac65aaf19",
+		"f5d012a8b38a7482d8c68271511f7cf697d9ea0a5643acd28313b7346dd3d2f6",
+	},
+	{
+		ModeAuthPSK,
+		"1ef545072c07a299ad514d64c6c5c3e62f07bd71bc645be912f477b4ef9ef5d540ba976702de9dd766e89f34db",
+		"980ecd0488901611dc1e87b8f7ea8efe5db5701a3b8ebce8e2651413adbdf29656f25a397650d7e78aab526b5c",
+		"7117afbe47491993ae213b10de70a73a39ba8b394ef99594b5f4902faee5b4ba",
+	},
+}
+
+func TestVectors(t *testing.T) {
+	skE, _ := DeriveKeyPair(bytes.Repeat([]byte{1}, 32))
+	skR, _ := DeriveKeyPair(bytes.Repeat([]byte{2}, 32))
+	skS, _ := DeriveKeyPair(bytes.Repeat([]byte{3}, 32))
+	if got, want := skR.Bytes(), unhex("95fe8f32f2036438e7f4c0e9157d5f7d730bc04618e1dd569084f67d3919d1fe"); !bytes.Equal(got, want) {
+		t.Errorf("DeriveKeyPair = %x, want %x", got, want)
+	}
+	for _, v := range vectors {
+		enc, s, r := setup(t, v.mode, skR, skS, skE)
+		if want := skE.PublicKey().Bytes(); !bytes.Equal(enc, want) {
+			t.Errorf("mode %d: enc = %x, want %x", v.mode, enc, want)
+		}
+		for i, want := range []string{v.ct0, v.ct1} {
+			aad := []byte{'C', 'o', 'u', 'n', 't', '-', '0' + byte(i)}
+			ct, err := s.Seal(aad, testMsg)
+			if err != nil || !bytes.Equal(ct, unhex(want)) {
+				t.Errorf("mode %d: Seal #%d = %x, %v; want %s", v.mode, i, ct, err, want)
+			}
+			pt, err := r.Open(aad, unhex(want))
+			if err != nil || !bytes.Equal(pt, testMsg) {
+				t.Errorf("mode %d: Open #%d = %q, %v", v.mode, i, pt, err)
+			}
+		}
+		for _, x := range []interface {
+			Export([]byte, int) ([]byte, error)
+		}{s, r} {
+			if exp, err := x.Export([]byte("TestContext"), 32); err != nil || !bytes.Equal(exp, unhex(v.exp)) {
+				t.Errorf("mode %d: Export = %x, %v; want %s", v.mode, exp, err, v.exp)
+			}
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	skR, skS := newKey(t), newKey(t)
+	for mode := ModeBase; mode <= ModeAuthPSK; mode++ {
+		_, s, r := setup(t, mode, skR, skS, nil)
+		for i := 0; i < 10; i++ {
+			msg := bytes.Repeat([]byte{byte(i)}, i*7)
+			ct, err := s.Seal([]byte("aad"), msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(ct) != len(msg)+nT {
+				t.Errorf("mode %d: ciphertext is %d bytes, want %d", mode, len(ct), len(msg)+nT)
+			}
+			pt, err := r.Open([]byte("aad"), ct)
+			if err != nil || !bytes.Equal(pt, msg) {
+				t.Errorf("mode %d: Open = %x, %v; want %x", mode, pt, err, msg)
+			}
+		}
+	}
+}
+
+func TestSequence(t *testing.T) {
+	_, s, r := setup(t, ModeBase, newKey(t), nil, nil)
+	ct0, _ := s.Seal(nil, []byte("first"))
+	ct1, _ := s.Seal(nil, []byte("second"))
+	if _, err := r.Open(nil, ct1); err == nil {
+		t.Errorf("Open succeeded out of order")
+	}
+	// A failed Open does not advance the sequence number.
+	if pt, err := r.Open(nil, ct0); err != nil || string(pt) != "first" {
+		t.Errorf("Open(ct0) = %q, %v", pt, err)
+	}
+	if pt, err := r.Open(nil, ct1); err != nil || string(pt) != "second" {
+		t.Errorf("Open(ct1) = %q, %v", pt, err)
+	}
+
+	s.c.seq = 1<<64 - 1
+	if _, err := s.Seal(nil, nil); err == nil {
+		t.Errorf("Seal succeeded after the message limit")
+	}
+}
+
+func TestWrongInputs(t *testing.T) {
+	skR, skS := newKey(t), newKey(t)
+	enc, s, err := SetupAuthPSKS(skR.PublicKey(), testInfo, testPSK, testPSKID, skS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ct, _ := s.Seal(nil, testMsg)
+	open := func(r *Recipient, err error) bool {
+		if err != nil {
+			return false
+		}
+		_, err = r.Open(nil, ct)
+		return err == nil
+	}
+	if !open(SetupAuthPSKR(enc, skR, testInfo, testPSK, testPSKID, skS.PublicKey())) {
+		t.Fatalf("Open with the right inputs failed")
+	}
+	if open(SetupAuthPSKR(enc, newKey(t), testInfo, testPSK, testPSKID, skS.PublicKey())) {
+		t.Errorf("Open succeeded with the wrong recipient key")
+	}
+	if open(SetupAuthPSKR(enc, skR, testInfo, testPSK, testPSKID, newKey(t).PublicKey())) {
+		t.Errorf("Open succeeded with the wrong sender key")
+	}
+	if open(SetupAuthPSKR(enc, skR, []byte("other info"), testPSK, testPSKID, skS.PublicKey())) {
+		t.Errorf("Open succeeded with the wrong info")
+	}
+	if open(SetupAuthPSKR(enc, skR, testInfo, bytes.Repeat([]byte{5}, 32), testPSKID, skS.PublicKey())) {
+		t.Errorf("Open succeeded with the wrong PSK")
+	}
+	if open(SetupAuthPSKR(enc, skR, testInfo, testPSK, []byte("other id"), skS.PublicKey())) {
+		t.Errorf("Open succeeded with the wrong PSK ID")
+	}
+	if open(SetupAuthR(enc, skR, testInfo, skS.PublicKey())) {
+		t.Errorf("Open succeeded in the wrong mode")
+	}
+}
+
+func TestSetupErrors(t *testing.T) {
+	skR := newKey(t)
+	pkR := skR.PublicKey()
+	if _, _, err := SetupPSKS(pkR, nil, nil, nil); err == nil {
+		t.Errorf("SetupPSKS accepted an empty PSK")
+	}
+	if _, _, err := SetupPSKS(pkR, nil, testPSK, nil); err == nil {
+		t.Errorf("SetupPSKS accepted a PSK without an ID")
+	}
+	if _, _, err := SetupPSKS(pkR, nil, testPSK[:16], testPSKID); err == nil {
+		t.Errorf("SetupPSKS accepted a 16-byte PSK")
+	}
+	if _, _, err := SetupAuthS(pkR, nil, nil); err == nil {
+		t.Errorf("SetupAuthS accepted a nil sender key")
+	}
+	p256, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := SetupBaseS(p256.PublicKey(), nil); err == nil {
+		t.Errorf("SetupBaseS accepted a P-256 key")
+	}
+	if _, err := SetupBaseR(make([]byte, nEnc), skR, nil); err == nil {
+		t.Errorf("SetupBaseR accepted a low-order encapsulated key")
+	}
+	if _, err := SetupBaseR(make([]byte, nEnc-1), skR, nil); err == nil {
+		t.Errorf("SetupBaseR accepted a short encapsulated key")
+	}
+	if _, err := DeriveKeyPair(make([]byte, 31)); err == nil {
+		t.Errorf("DeriveKeyPair accepted 31 bytes")
+	}
+
+	_, s, _ := setup(t, ModeBase, skR, nil, nil)
+	if _, err := s.Export(nil, 255*nH+1); err == nil {
+		t.Errorf("Export accepted too long a length")
+	}
+}
+
+func TestSingleShot(t *testing.T) {
+	skR := newKey(t)
+	enc, ct, err := Seal(skR.PublicKey(), testInfo, []byte("aad"), testMsg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pt, err := Open(skR, enc, testInfo, []byte("aad"), ct)
+	if err != nil || !bytes.Equal(pt, testMsg) {
+		t.Errorf("Open = %q, %v; want %q", pt, err, testMsg)
+	}
+	if _, err := Open(skR, enc, testInfo, nil, ct); err == nil {
+		t.Errorf("Open with the wrong aad succeeded")
+	}
+}
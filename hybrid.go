@@ -0,0 +1,187 @@
+package deoxys
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+)
+
+// Hybrid public-key encryption: an ephemeral X25519 key agreement
+// followed by Deoxys-II.
+//
+// The X25519 shared secret is compressed into a 16-byte key with the MAC
+// under the all-zero key, and the encryption key is derived from it with
+// the KDF, using the ephemeral and recipient public keys as context.
+// Each derived key encrypts exactly one message, so the nonce is zero.
+
+const (
+	x25519Size = 32
+
+	hybridMagic      = "DXHR"
+	hybridVersion    = 1
+	hybridHeaderSize = len(hybridMagic) + 1 + 2 + x25519Size
+	wrappedKeySize   = 16 + TagSize
+
+	// MaxRecipients is the largest number of recipients SealToRecipients accepts.
+	MaxRecipients = 1<<16 - 1
+)
+
+var (
+	hybridBoxLabel  = []byte("deoxys hybrid box v1")
+	hybridWrapLabel = []byte("deoxys hybrid wrap v1")
+)
+
+// SealBox encrypts and authenticates plaintext to the holder of
+// the X25519 private key matching recipient.
+// The output is the ephemeral public key followed by the ciphertext.
+// The sender is anonymous: the recipient learns nothing about who sealed it.
+func SealBox(recipient *ecdh.PublicKey, plaintext, additionalData []byte) ([]byte, error) {
+	eph, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	key, err := hybridSenderKey(eph, recipient, hybridBoxLabel)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, x25519Size, x25519Size+len(plaintext)+TagSize)
+	copy(out, eph.PublicKey().Bytes())
+	var nonce [NonceSize]byte
+	return New(key).Seal(out, nonce[:], plaintext, additionalData), nil
+}
+
+// OpenBox decrypts a message sealed by SealBox.
+func OpenBox(priv *ecdh.PrivateKey, box, additionalData []byte) ([]byte, error) {
+	if len(box) < x25519Size+TagSize {
+		return nil, errors.New("OpenBox: message too short")
+	}
+	key, err := hybridRecipientKey(priv, box[:x25519Size], hybridBoxLabel)
+	if err != nil {
+		return nil, err
+	}
+	var nonce [NonceSize]byte
+	return New(key).Open(nil, nonce[:], box[x25519Size:], additionalData)
+}
+
+// SealToRecipients encrypts and authenticates plaintext so that
+// any one of the recipients can decrypt it.
+// A random file key encrypts the message, and is wrapped for each
+// recipient under a key agreed with a shared ephemeral X25519 key.
+//
+// The output is
+//
+//	magic "DXHR" || version (1 byte) || recipient count (2 bytes) ||
+//	ephemeral public key || wrapped file keys || ciphertext and tag
+//
+// Everything before the ciphertext is authenticated as additional data,
+// so recipients cannot be added or removed. The wrapped keys
+// do not reveal which public keys the message was sealed to.
+func SealToRecipients(recipients []*ecdh.PublicKey, plaintext, additionalData []byte) ([]byte, error) {
+	if len(recipients) == 0 || len(recipients) > MaxRecipients {
+		return nil, errors.New("SealToRecipients: wrong number of recipients")
+	}
+	ephPriv, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	fileKey := make([]byte, 16)
+	if _, err := rand.Read(fileKey); err != nil {
+		return nil, err
+	}
+
+	headerSize := hybridHeaderSize + len(recipients)*wrappedKeySize
+	out := make([]byte, hybridHeaderSize, headerSize+len(plaintext)+TagSize)
+	copy(out, hybridMagic)
+	out[len(hybridMagic)] = hybridVersion
+	binary.BigEndian.PutUint16(out[len(hybridMagic)+1:], uint16(len(recipients)))
+	eph := ephPriv.PublicKey().Bytes()
+	copy(out[hybridHeaderSize-x25519Size:], eph)
+
+	var nonce [NonceSize]byte
+	for _, r := range recipients {
+		kek, err := hybridSenderKey(ephPriv, r, hybridWrapLabel)
+		if err != nil {
+			return nil, err
+		}
+		out = New(kek).Seal(out, nonce[:], fileKey, out[:hybridHeaderSize])
+	}
+	return New(fileKey).Seal(out, nonce[:], plaintext, append(out[:headerSize:headerSize], additionalData...)), nil
+}
+
+// OpenFromRecipients decrypts a message sealed by SealToRecipients
+// with the private key of one of its recipients.
+func OpenFromRecipients(priv *ecdh.PrivateKey, sealed, additionalData []byte) ([]byte, error) {
+	n := len(hybridMagic)
+	if len(sealed) < hybridHeaderSize || string(sealed[:n]) != hybridMagic {
+		return nil, errors.New("OpenFromRecipients: not a hybrid-encrypted message")
+	}
+	if sealed[n] != hybridVersion {
+		return nil, errors.New("OpenFromRecipients: unsupported version")
+	}
+	count := int(binary.BigEndian.Uint16(sealed[n+1:]))
+	headerSize := hybridHeaderSize + count*wrappedKeySize
+	if count == 0 || len(sealed) < headerSize+TagSize {
+		return nil, errors.New("OpenFromRecipients: malformed message")
+	}
+	kek, err := hybridRecipientKey(priv, sealed[hybridHeaderSize-x25519Size:hybridHeaderSize], hybridWrapLabel)
+	if err != nil {
+		return nil, err
+	}
+	unwrap := New(kek)
+	var nonce [NonceSize]byte
+	var fileKey []byte
+	for i := 0; i < count; i++ {
+		w := sealed[hybridHeaderSize+i*wrappedKeySize:][:wrappedKeySize]
+		if k, err := unwrap.Open(nil, nonce[:], w, sealed[:hybridHeaderSize]); err == nil {
+			fileKey = k
+			break
+		}
+	}
+	if fileKey == nil {
+		return nil, errors.New("OpenFromRecipients: no matching recipient")
+	}
+	ad := append(sealed[:headerSize:headerSize], additionalData...)
+	return New(fileKey).Open(nil, nonce[:], sealed[headerSize:], ad)
+}
+
+// hybridSenderKey derives the key the ephemeral private key agrees with recipient.
+func hybridSenderKey(eph *ecdh.PrivateKey, recipient *ecdh.PublicKey, label []byte) ([]byte, error) {
+	if recipient.Curve() != ecdh.X25519() {
+		return nil, errors.New("deoxys: recipient is not an X25519 public key")
+	}
+	shared, err := eph.ECDH(recipient)
+	if err != nil {
+		return nil, err
+	}
+	return hybridKey(shared, eph.PublicKey().Bytes(), recipient.Bytes(), label), nil
+}
+
+// hybridRecipientKey derives the key priv agrees with the ephemeral public key eph.
+func hybridRecipientKey(priv *ecdh.PrivateKey, eph, label []byte) ([]byte, error) {
+	if priv.Curve() != ecdh.X25519() {
+		return nil, errors.New("deoxys: private key is not an X25519 key")
+	}
+	pub, err := ecdh.X25519().NewPublicKey(eph)
+	if err != nil {
+		return nil, err
+	}
+	shared, err := priv.ECDH(pub)
+	if err != nil {
+		return nil, err
+	}
+	return hybridKey(shared, eph, priv.PublicKey().Bytes(), label), nil
+}
+
+// hybridKey derives a 16-byte key from an X25519 shared secret,
+// binding both public keys into the derivation.
+func hybridKey(shared, eph, recipient, label []byte) []byte {
+	var zero [16]byte
+	m := NewMAC(zero[:])
+	m.Write(shared)
+	prk := m.Sum(nil)
+	context := make([]byte, 0, 2*x25519Size)
+	context = append(context, eph...)
+	context = append(context, recipient...)
+	return DeriveKey(prk, label, context, 16)
+}
@@ -0,0 +1,131 @@
+package deoxys
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"testing"
+)
+
+func newX25519(t *testing.T) *ecdh.PrivateKey {
+	t.Helper()
+	k, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func TestSealBox(t *testing.T) {
+	priv := newX25519(t)
+	msg := []byte("A witty saying means nothing.")
+	box, err := SealBox(priv.PublicKey(), msg, []byte("ad"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(box) != x25519Size+len(msg)+TagSize {
+		t.Errorf("SealBox returned %d bytes, want %d", len(box), x25519Size+len(msg)+TagSize)
+	}
+	pt, err := OpenBox(priv, box, []byte("ad"))
+	if err != nil || !bytes.Equal(pt, msg) {
+		t.Errorf("OpenBox = %q, %v; want %q", pt, err, msg)
+	}
+
+	if _, err := OpenBox(newX25519(t), box, []byte("ad")); err == nil {
+		t.Errorf("OpenBox with the wrong private key succeeded")
+	}
+	if _, err := OpenBox(priv, box, nil); err == nil {
+		t.Errorf("OpenBox with the wrong additional data succeeded")
+	}
+	for _, i := range []int{0, x25519Size, len(box) - 1} {
+		c := append([]byte(nil), box...)
+		c[i] ^= 1
+		if _, err := OpenBox(priv, c, []byte("ad")); err == nil {
+			t.Errorf("OpenBox succeeded with byte %d modified", i)
+		}
+	}
+	if _, err := OpenBox(priv, box[:x25519Size+TagSize-1], nil); err == nil {
+		t.Errorf("OpenBox of a short message succeeded")
+	}
+
+	// An all-zero ephemeral key is a low-order point.
+	zero := make([]byte, len(box))
+	if _, err := OpenBox(priv, zero, nil); err == nil {
+		t.Errorf("OpenBox accepted a low-order ephemeral key")
+	}
+
+	box2, _ := SealBox(priv.PublicKey(), msg, []byte("ad"))
+	if bytes.Equal(box[:x25519Size], box2[:x25519Size]) {
+		t.Errorf("SealBox reused an ephemeral key")
+	}
+}
+
+func TestSealToRecipients(t *testing.T) {
+	privs := []*ecdh.PrivateKey{newX25519(t), newX25519(t), newX25519(t)}
+	var pubs []*ecdh.PublicKey
+	for _, k := range privs {
+		pubs = append(pubs, k.PublicKey())
+	}
+	msg := []byte("A witty saying means nothing.")
+	sealed, err := SealToRecipients(pubs, msg, []byte("ad"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := hybridHeaderSize + len(pubs)*wrappedKeySize + len(msg) + TagSize; len(sealed) != want {
+		t.Errorf("SealToRecipients returned %d bytes, want %d", len(sealed), want)
+	}
+	for i, k := range privs {
+		pt, err := OpenFromRecipients(k, sealed, []byte("ad"))
+		if err != nil || !bytes.Equal(pt, msg) {
+			t.Errorf("recipient %d: OpenFromRecipients = %q, %v; want %q", i, pt, err, msg)
+		}
+	}
+	if _, err := OpenFromRecipients(newX25519(t), sealed, []byte("ad")); err == nil {
+		t.Errorf("OpenFromRecipients succeeded for a non-recipient")
+	}
+	if _, err := OpenFromRecipients(privs[0], sealed, nil); err == nil {
+		t.Errorf("OpenFromRecipients with the wrong additional data succeeded")
+	}
+
+	// Dropping a recipient changes the header.
+	n := len(hybridMagic)
+	dropped := append([]byte(nil), sealed[:hybridHeaderSize]...)
+	dropped[n+2]--
+	dropped = append(dropped, sealed[hybridHeaderSize+wrappedKeySize:]...)
+	if _, err := OpenFromRecipients(privs[1], dropped, []byte("ad")); err == nil {
+		t.Errorf("OpenFromRecipients succeeded with a recipient removed")
+	}
+
+	for i := 0; i < hybridHeaderSize+len(pubs)*wrappedKeySize; i++ {
+		c := append([]byte(nil), sealed...)
+		c[i] ^= 1
+		if _, err := OpenFromRecipients(privs[2], c, []byte("ad")); err == nil {
+			t.Errorf("OpenFromRecipients succeeded with header byte %d modified", i)
+		}
+	}
+}
+
+func TestHybridErrors(t *testing.T) {
+	if _, err := SealToRecipients(nil, nil, nil); err == nil {
+		t.Errorf("SealToRecipients accepted no recipients")
+	}
+	p256, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := SealBox(p256.PublicKey(), nil, nil); err == nil {
+		t.Errorf("SealBox accepted a P-256 key")
+	}
+	if _, err := SealToRecipients([]*ecdh.PublicKey{newX25519(t).PublicKey(), p256.PublicKey()}, nil, nil); err == nil {
+		t.Errorf("SealToRecipients accepted a P-256 key")
+	}
+	box, _ := SealBox(newX25519(t).PublicKey(), nil, nil)
+	if _, err := OpenBox(p256, box, nil); err == nil {
+		t.Errorf("OpenBox accepted a P-256 key")
+	}
+	for _, bad := range [][]byte{nil, []byte("DXHR"), []byte("junkjunkjunkjunkjunkjunkjunkjunkjunkjunkjunk")} {
+		if _, err := OpenFromRecipients(newX25519(t), bad, nil); err == nil {
+			t.Errorf("OpenFromRecipients(%q) succeeded", bad)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package deoxys
+
+import (
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"math/bits"
+)
+
+const idFeistelRounds = 10
+
+var idEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// IDCipher encrypts database identifiers into opaque, reversible IDs.
+//
+// Every method takes an entity type, such as "user" or "invoice",
+// which is hashed into the tweak, so the same identifier
+// maps to unrelated values for different types.
+//
+// 128-bit identifiers such as UUIDs are encrypted directly with Deoxys-BC.
+// Smaller domains use a ten-round Feistel network with Deoxys-BC as the
+// round function, and cycle walking for domains that are not a power of 4.
+// The encryption is deterministic: equal identifiers of the same type
+// always encrypt to equal values.
+type IDCipher struct {
+	subkey [numRounds][16]uint8
+}
+
+// NewIDCipher returns an IDCipher using the given 16-byte key.
+func NewIDCipher(key []byte) *IDCipher {
+	c := new(IDCipher)
+	expandKey(key, c.subkey[:])
+	return c
+}
+
+// EncryptUint64 encrypts a 64-bit identifier.
+func (c *IDCipher) EncryptUint64(typ string, id uint64) uint64 {
+	return c.feistel(typeHash(typ), 64, id, false)
+}
+
+// DecryptUint64 decrypts a 64-bit identifier produced by EncryptUint64.
+func (c *IDCipher) DecryptUint64(typ string, id uint64) uint64 {
+	return c.feistel(typeHash(typ), 64, id, true)
+}
+
+// EncryptRange encrypts an identifier in [0, n) to another identifier in [0, n).
+// It panics if id >= n.
+func (c *IDCipher) EncryptRange(typ string, id, n uint64) uint64 {
+	return c.cycleWalk(typ, id, n, false)
+}
+
+// DecryptRange decrypts an identifier produced by EncryptRange.
+// It panics if id >= n.
+func (c *IDCipher) DecryptRange(typ string, id, n uint64) uint64 {
+	return c.cycleWalk(typ, id, n, true)
+}
+
+func (c *IDCipher) cycleWalk(typ string, id, n uint64, decrypt bool) uint64 {
+	if id >= n {
+		panic("deoxys: identifier out of range")
+	}
+	// Use the smallest even width that covers n, so that the Feistel
+	// halves are equal. The permuted domain is then less than 4n,
+	// and walking takes fewer than four steps on average.
+	width := uint(bits.Len64(n - 1))
+	if width < 2 {
+		width = 2
+	}
+	width += width & 1
+	h := typeHash(typ)
+	for {
+		id = c.feistel(h, width, id, decrypt)
+		if id < n {
+			return id
+		}
+	}
+}
+
+// EncryptUUID encrypts a 128-bit identifier.
+func (c *IDCipher) EncryptUUID(typ string, id [16]byte) [16]byte {
+	tweak := idBlockTweak(typ)
+	encryptBlock(c.subkey[:], tweak[:], id[:], id[:])
+	return id
+}
+
+// DecryptUUID decrypts a 128-bit identifier produced by EncryptUUID.
+func (c *IDCipher) DecryptUUID(typ string, id [16]byte) [16]byte {
+	tweak := idBlockTweak(typ)
+	decryptBlock(c.subkey[:], tweak[:], id[:], id[:])
+	return id
+}
+
+// EncodeUint64 encrypts a 64-bit identifier and returns it
+// as 13 characters of lowercase, unpadded base32.
+func (c *IDCipher) EncodeUint64(typ string, id uint64) string {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], c.EncryptUint64(typ, id))
+	return idEncoding.EncodeToString(b[:])
+}
+
+// DecodeUint64 decodes and decrypts an identifier produced by EncodeUint64.
+// It accepts only the canonical encoding, so each identifier has one encoding.
+func (c *IDCipher) DecodeUint64(typ, s string) (uint64, error) {
+	b, err := idEncoding.DecodeString(s)
+	// The decoder ignores newlines and the unused low bit of the last
+	// character, so check that s is what EncodeUint64 would return.
+	if err != nil || len(b) != 8 || idEncoding.EncodeToString(b) != s {
+		return 0, errors.New("DecodeUint64: malformed identifier")
+	}
+	return c.DecryptUint64(typ, binary.BigEndian.Uint64(b)), nil
+}
+
+// EncodeUUID encrypts a 128-bit identifier and returns it
+// as 22 characters of unpadded base64url.
+func (c *IDCipher) EncodeUUID(typ string, id [16]byte) string {
+	id = c.EncryptUUID(typ, id)
+	return base64.RawURLEncoding.EncodeToString(id[:])
+}
+
+// DecodeUUID decodes and decrypts an identifier produced by EncodeUUID.
+// It accepts only the canonical encoding, so each identifier has one encoding.
+func (c *IDCipher) DecodeUUID(typ, s string) ([16]byte, error) {
+	var id [16]byte
+	// Even in strict mode the decoder ignores newlines.
+	b, err := base64.RawURLEncoding.Strict().DecodeString(s)
+	if err != nil || len(b) != len(id) || len(s) != base64.RawURLEncoding.EncodedLen(len(id)) {
+		return id, errors.New("DecodeUUID: malformed identifier")
+	}
+	copy(id[:], b)
+	return c.DecryptUUID(typ, id), nil
+}
+
+// feistel encrypts or decrypts x, a width-bit integer,
+// with a balanced Feistel network.
+// Round i computes the round function as
+//
+//	E_K^{d || typeHash[0:13] || width || i}(0^8 || R)
+//
+// truncated to width/2 bits.
+func (c *IDCipher) feistel(h [32]byte, width uint, x uint64, decrypt bool) uint64 {
+	half := width / 2
+	mask := uint64(1)<<half - 1
+	l, r := x>>half&mask, x&mask
+
+	var tweak [16]uint8
+	var in, out [16]byte
+	tweak[0] = domainIDFeistel
+	copy(tweak[1:14], h[:])
+	tweak[14] = uint8(width)
+	f := func(i int, r uint64) uint64 {
+		tweak[15] = uint8(i)
+		binary.BigEndian.PutUint64(in[8:], r)
+		encryptBlock(c.subkey[:], tweak[:], in[:], out[:])
+		return binary.BigEndian.Uint64(out[:8]) & mask
+	}
+
+	if !decrypt {
+		for i := 0; i < idFeistelRounds; i++ {
+			l, r = r, l^f(i, r)
+		}
+	} else {
+		for i := idFeistelRounds - 1; i >= 0; i-- {
+			l, r = r^f(i, l), l
+		}
+	}
+	return l<<half | r
+}
+
+func typeHash(typ string) [32]byte {
+	return sha256.Sum256([]byte(typ))
+}
+
+func idBlockTweak(typ string) [16]uint8 {
+	var tweak [16]uint8
+	h := typeHash(typ)
+	tweak[0] = domainIDBlock
+	copy(tweak[1:], h[:])
+	return tweak
+}
@@ -0,0 +1,145 @@
+package deoxys
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestIDCipher(t *testing.T) {
+	// Encodings recorded from this package under the key seq(16),
+	// so that stored IDs keep decoding to the same values.
+	c := NewIDCipher(seq(16))
+	tests := []struct {
+		typ  string
+		id   uint64
+		want string
+	}{
+		{"user", 1, "me3sfj7vcj44m"},
+		{"user", 2, "adqqhlwpa5v74"},
+		{"invoice", 1, "7jpqnesbnutda"},
+	}
+	for _, tt := range tests {
+		if got := c.EncodeUint64(tt.typ, tt.id); got != tt.want {
+			t.Errorf("EncodeUint64(%q, %d) = %s, want %s", tt.typ, tt.id, got, tt.want)
+		}
+	}
+
+	var u [16]byte
+	copy(u[:], seq(16))
+	if got, want := c.EncodeUUID("user", u), "sCqX2pSCYMngwKSF3zAkeg"; got != want {
+		t.Errorf("EncodeUUID(%q, %x) = %s, want %s", "user", u, got, want)
+	}
+	if got, want := c.EncryptRange("user", 42, 1000000), uint64(867278); got != want {
+		t.Errorf("EncryptRange(%q, 42, 1000000) = %d, want %d", "user", got, want)
+	}
+}
+
+func TestIDRoundTrip(t *testing.T) {
+	c := NewIDCipher([]byte("16-byte password"))
+	for _, id := range []uint64{0, 1, 2, 1<<32 - 1, 1 << 32, 1<<63 + 12345, 1<<64 - 1} {
+		e := c.EncryptUint64("user", id)
+		if e == id {
+			t.Errorf("EncryptUint64(%d) = %d", id, e)
+		}
+		if d := c.DecryptUint64("user", e); d != id {
+			t.Errorf("DecryptUint64(EncryptUint64(%d)) = %d", id, d)
+		}
+		if c.EncryptUint64("order", id) == e {
+			t.Errorf("EncryptUint64(%d) is the same for two types", id)
+		}
+
+		s := c.EncodeUint64("user", id)
+		if len(s) != 13 {
+			t.Errorf("EncodeUint64(%d) = %q, want 13 characters", id, s)
+		}
+		if d, err := c.DecodeUint64("user", s); d != id || err != nil {
+			t.Errorf("DecodeUint64(%q) = %d, %v; want %d", s, d, err, id)
+		}
+	}
+
+	var u [16]byte
+	copy(u[:], "0123456789abcdef")
+	e := c.EncryptUUID("user", u)
+	if d := c.DecryptUUID("user", e); d != u {
+		t.Errorf("DecryptUUID(EncryptUUID(%x)) = %x", u, d)
+	}
+	if c.EncryptUUID("order", u) == e {
+		t.Errorf("EncryptUUID is the same for two types")
+	}
+	s := c.EncodeUUID("user", u)
+	if d, err := c.DecodeUUID("user", s); d != u || err != nil {
+		t.Errorf("DecodeUUID(%q) = %x, %v; want %x", s, d, err, u)
+	}
+}
+
+func TestIDRange(t *testing.T) {
+	c := NewIDCipher([]byte("16-byte password"))
+	for _, n := range []uint64{1, 2, 3, 4, 5, 1000} {
+		seen := make(map[uint64]bool)
+		for id := uint64(0); id < n; id++ {
+			e := c.EncryptRange("user", id, n)
+			if e >= n {
+				t.Errorf("EncryptRange(%d, %d) = %d, out of range", id, n, e)
+			}
+			if seen[e] {
+				t.Errorf("EncryptRange(%d, %d) = %d, which was already produced", id, n, e)
+			}
+			seen[e] = true
+			if d := c.DecryptRange("user", e, n); d != id {
+				t.Errorf("DecryptRange(EncryptRange(%d, %d)) = %d", id, n, d)
+			}
+		}
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("EncryptRange(10, 10) did not panic")
+		}
+	}()
+	c.EncryptRange("user", 10, 10)
+}
+
+func TestIDDecodeErrors(t *testing.T) {
+	c := NewIDCipher([]byte("16-byte password"))
+	for _, s := range []string{"", "me3sfj7vcj44", "me3sfj7vcj44m1", "ME3SFJ7VCJ44M"} {
+		if _, err := c.DecodeUint64("user", s); err == nil {
+			t.Errorf("DecodeUint64(%q) succeeded, expected an error", s)
+		}
+	}
+	for _, s := range []string{"", "sCqX2pSCYMngwKSF3zAke", "sCqX2pSCYMngwKSF3zAkeg==", "sCqX2pSCYMngwKSF3zAke+"} {
+		if _, err := c.DecodeUUID("user", s); err == nil {
+			t.Errorf("DecodeUUID(%q) succeeded, expected an error", s)
+		}
+	}
+}
+
+func TestIDDecodeCanonical(t *testing.T) {
+	c := NewIDCipher([]byte("16-byte password"))
+	// Setting the unused low bits of the last character, or adding
+	// a newline, must not give another encoding of the same identifier.
+	s := c.EncodeUint64("user", 42)
+	last := strings.IndexByte("abcdefghijklmnopqrstuvwxyz234567", s[len(s)-1])
+	for _, bad := range []string{
+		s[:len(s)-1] + string("abcdefghijklmnopqrstuvwxyz234567"[last^1]),
+		s + "\n",
+		s[:6] + "\r\n" + s[6:],
+	} {
+		if id, err := c.DecodeUint64("user", bad); err == nil {
+			t.Errorf("DecodeUint64(%q) = %d, want an error", bad, id)
+		}
+	}
+
+	const alphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-_"
+	u := c.EncodeUUID("user", [16]byte{1, 2, 3})
+	last = strings.IndexByte(alphabet, u[len(u)-1])
+	for _, bad := range []string{
+		u[:len(u)-1] + string(alphabet[last^1]),
+		u[:len(u)-1] + string(alphabet[last^8]),
+		u + "\n",
+		u[:6] + "\r\n" + u[6:],
+	} {
+		if id, err := c.DecodeUUID("user", bad); err == nil {
+			t.Errorf("DecodeUUID(%q) = %x, want an error", bad, id)
+		}
+	}
+}
@@ -0,0 +1,392 @@
+// Package jwe implements JSON Web Encryption (RFC 7516)
+// with Deoxys-II as the content encryption algorithm.
+//
+// The content encryption algorithm is identified by the
+// private "enc" value "DX2-128": Deoxys-II with a 16-byte key,
+// a 15-byte initialization vector and a 16-byte authentication tag.
+// Two key management algorithms are supported: "dir", where the
+// shared key is used directly as the content encryption key, and
+// "DX2KW", where a random content encryption key is wrapped with
+// Deoxys-II under a zero nonce. Because Deoxys-II is misuse resistant,
+// a fixed nonce gives deterministic authenticated encryption, which
+// is what key wrapping needs.
+//
+// Both the compact and the JSON serializations are supported.
+// Other JOSE algorithms, compression ("zip") and
+// critical header extensions ("crit") are not.
+//
+// RFC 7516:
+//
+//	https://www.rfc-editor.org/rfc/rfc7516.html
+package jwe
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/magical/deoxys"
+)
+
+// Algorithm names.
+const (
+	EncDeoxysII128 = "DX2-128" // content encryption
+	AlgDirect      = "dir"     // direct use of a shared key
+	AlgKeyWrap     = "DX2KW"   // Deoxys-II key wrapping
+)
+
+const (
+	keySize        = 16
+	wrappedKeySize = keySize + deoxys.TagSize
+)
+
+var b64 = base64.RawURLEncoding.Strict()
+
+// A Header is a JOSE header.
+type Header map[string]interface{}
+
+// A Recipient holds a key and how to use it.
+type Recipient struct {
+	Alg   string // AlgDirect or AlgKeyWrap
+	Key   []byte // 16 bytes
+	KeyID string // optional "kid" header parameter
+}
+
+func (r *Recipient) check() error {
+	if r.Alg != AlgDirect && r.Alg != AlgKeyWrap {
+		return errors.New("jwe: unsupported key management algorithm " + r.Alg)
+	}
+	if len(r.Key) != keySize {
+		return errors.New("jwe: wrong size key")
+	}
+	return nil
+}
+
+// header returns the per-recipient header parameters.
+func (r *Recipient) header() Header {
+	h := Header{"alg": r.Alg}
+	if r.KeyID != "" {
+		h["kid"] = r.KeyID
+	}
+	return h
+}
+
+// wrap returns the encrypted key for cek.
+func (r *Recipient) wrap(cek []byte) []byte {
+	if r.Alg == AlgDirect {
+		return nil
+	}
+	var nonce [deoxys.NonceSize]byte
+	return deoxys.New(r.Key).Seal(nil, nonce[:], cek, nil)
+}
+
+// unwrap returns the content encryption key.
+func (r *Recipient) unwrap(encryptedKey []byte) ([]byte, error) {
+	if r.Alg == AlgDirect {
+		if len(encryptedKey) != 0 {
+			return nil, errors.New("jwe: encrypted key present with direct encryption")
+		}
+		return r.Key, nil
+	}
+	if len(encryptedKey) != wrappedKeySize {
+		return nil, errors.New("jwe: wrong size encrypted key")
+	}
+	var nonce [deoxys.NonceSize]byte
+	return deoxys.New(r.Key).Open(nil, nonce[:], encryptedKey, nil)
+}
+
+func newCEK(r []Recipient) ([]byte, error) {
+	if len(r) == 1 && r[0].Alg == AlgDirect {
+		return r[0].Key, nil
+	}
+	for i := range r {
+		if r[i].Alg == AlgDirect {
+			return nil, errors.New("jwe: direct encryption with more than one recipient")
+		}
+	}
+	cek := make([]byte, keySize)
+	if _, err := rand.Read(cek); err != nil {
+		return nil, err
+	}
+	return cek, nil
+}
+
+// seal encrypts plaintext under cek and returns the IV, ciphertext and tag.
+func seal(cek, plaintext, aad []byte) (iv, ciphertext, tag []byte, err error) {
+	iv = make([]byte, deoxys.NonceSize)
+	if _, err := rand.Read(iv); err != nil {
+		return nil, nil, nil, err
+	}
+	ct := deoxys.New(cek).Seal(nil, iv, plaintext, aad)
+	n := len(ct) - deoxys.TagSize
+	return iv, ct[:n], ct[n:], nil
+}
+
+func open(cek, iv, ciphertext, tag, aad []byte) ([]byte, error) {
+	if len(iv) != deoxys.NonceSize {
+		return nil, errors.New("jwe: wrong size initialization vector")
+	}
+	if len(tag) != deoxys.TagSize {
+		return nil, errors.New("jwe: wrong size authentication tag")
+	}
+	ct := append(append([]byte(nil), ciphertext...), tag...)
+	return deoxys.New(cek).Open(nil, iv, ct, aad)
+}
+
+// checkHeader checks the parameters this package understands.
+func checkHeader(h Header) error {
+	if h["enc"] != EncDeoxysII128 {
+		return errors.New("jwe: unsupported content encryption algorithm")
+	}
+	if _, ok := h["zip"]; ok {
+		return errors.New("jwe: compression is not supported")
+	}
+	if _, ok := h["crit"]; ok {
+		return errors.New("jwe: critical header parameters are not supported")
+	}
+	return nil
+}
+
+// mergeHeaders returns the union of headers,
+// which must not share any parameter names.
+func mergeHeaders(headers ...Header) (Header, error) {
+	out := Header{}
+	for _, h := range headers {
+		for k, v := range h {
+			if _, ok := out[k]; ok {
+				return nil, errors.New("jwe: duplicate header parameter " + k)
+			}
+			out[k] = v
+		}
+	}
+	return out, nil
+}
+
+func decodeHeader(s string) (Header, error) {
+	b, err := b64.DecodeString(s)
+	if err != nil {
+		return nil, errors.New("jwe: malformed protected header")
+	}
+	var h Header
+	if err := json.Unmarshal(b, &h); err != nil || h == nil {
+		return nil, errors.New("jwe: malformed protected header")
+	}
+	return h, nil
+}
+
+// EncryptCompact encrypts plaintext for r and returns the JWE
+// in compact serialization. The protected header holds "alg", "enc",
+// "kid" if r.KeyID is set, and any parameters in extra,
+// such as "typ" or "cty".
+func EncryptCompact(r Recipient, plaintext []byte, extra Header) (string, error) {
+	if err := r.check(); err != nil {
+		return "", err
+	}
+	h, err := mergeHeaders(r.header(), Header{"enc": EncDeoxysII128}, extra)
+	if err != nil {
+		return "", err
+	}
+	if err := checkHeader(h); err != nil {
+		return "", err
+	}
+	hb, err := json.Marshal(h)
+	if err != nil {
+		return "", err
+	}
+	protected := b64.EncodeToString(hb)
+
+	cek, err := newCEK([]Recipient{r})
+	if err != nil {
+		return "", err
+	}
+	iv, ct, tag, err := seal(cek, plaintext, []byte(protected))
+	if err != nil {
+		return "", err
+	}
+	return strings.Join([]string{
+		protected,
+		b64.EncodeToString(r.wrap(cek)),
+		b64.EncodeToString(iv),
+		b64.EncodeToString(ct),
+		b64.EncodeToString(tag),
+	}, "."), nil
+}
+
+// DecryptCompact decrypts a JWE in compact serialization with r
+// and returns the plaintext and the protected header.
+// The "alg" in the header must match r.Alg.
+func DecryptCompact(token string, r Recipient) ([]byte, Header, error) {
+	if err := r.check(); err != nil {
+		return nil, nil, err
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 5 {
+		return nil, nil, errors.New("jwe: malformed compact serialization")
+	}
+	h, err := decodeHeader(parts[0])
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := checkHeader(h); err != nil {
+		return nil, nil, err
+	}
+	if h["alg"] != r.Alg {
+		return nil, nil, errors.New("jwe: key management algorithm mismatch")
+	}
+	var fields [4][]byte
+	for i := range fields {
+		if fields[i], err = b64.DecodeString(parts[i+1]); err != nil {
+			return nil, nil, errors.New("jwe: malformed compact serialization")
+		}
+	}
+	cek, err := r.unwrap(fields[0])
+	if err != nil {
+		return nil, nil, err
+	}
+	pt, err := open(cek, fields[1], fields[2], fields[3], []byte(parts[0]))
+	if err != nil {
+		return nil, nil, err
+	}
+	return pt, h, nil
+}
+
+// jsonRecipient and jsonMessage are the JSON serialization.
+// The flattened form puts a single recipient's fields at the top level.
+type jsonRecipient struct {
+	Header       Header `json:"header,omitempty"`
+	EncryptedKey string `json:"encrypted_key,omitempty"`
+}
+
+type jsonMessage struct {
+	Protected   string          `json:"protected,omitempty"`
+	Unprotected Header          `json:"unprotected,omitempty"`
+	Recipients  []jsonRecipient `json:"recipients,omitempty"`
+	AAD         string          `json:"aad,omitempty"`
+	IV          string          `json:"iv"`
+	Ciphertext  string          `json:"ciphertext"`
+	Tag         string          `json:"tag"`
+
+	// Flattened syntax.
+	Header       Header `json:"header,omitempty"`
+	EncryptedKey string `json:"encrypted_key,omitempty"`
+}
+
+// EncryptJSON encrypts plaintext for each of the recipients and returns
+// the JWE in general JSON serialization. The protected header holds "enc";
+// "alg" and "kid" are in the per-recipient headers.
+// The additional authenticated data aad, if any, is stored in the message.
+// Direct encryption is only possible with a single recipient.
+func EncryptJSON(recipients []Recipient, plaintext, aad []byte) ([]byte, error) {
+	if len(recipients) == 0 {
+		return nil, errors.New("jwe: no recipients")
+	}
+	for i := range recipients {
+		if err := recipients[i].check(); err != nil {
+			return nil, err
+		}
+	}
+	cek, err := newCEK(recipients)
+	if err != nil {
+		return nil, err
+	}
+	hb, err := json.Marshal(Header{"enc": EncDeoxysII128})
+	if err != nil {
+		return nil, err
+	}
+	m := jsonMessage{Protected: b64.EncodeToString(hb)}
+	if aad != nil {
+		m.AAD = b64.EncodeToString(aad)
+	}
+	for i := range recipients {
+		m.Recipients = append(m.Recipients, jsonRecipient{
+			Header:       recipients[i].header(),
+			EncryptedKey: b64.EncodeToString(recipients[i].wrap(cek)),
+		})
+	}
+	iv, ct, tag, err := seal(cek, plaintext, m.fullAAD())
+	if err != nil {
+		return nil, err
+	}
+	m.IV = b64.EncodeToString(iv)
+	m.Ciphertext = b64.EncodeToString(ct)
+	m.Tag = b64.EncodeToString(tag)
+	return json.Marshal(m)
+}
+
+func (m *jsonMessage) fullAAD() []byte {
+	if m.AAD == "" {
+		return []byte(m.Protected)
+	}
+	return []byte(m.Protected + "." + m.AAD)
+}
+
+// DecryptJSON decrypts a JWE in general or flattened JSON serialization
+// with r, and returns the plaintext and the additional authenticated data.
+// It uses the first recipient whose "alg" matches r.Alg
+// and, if r.KeyID is set, whose "kid" matches r.KeyID.
+func DecryptJSON(data []byte, r Recipient) (plaintext, aad []byte, err error) {
+	if err := r.check(); err != nil {
+		return nil, nil, err
+	}
+	var m jsonMessage
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, nil, errors.New("jwe: malformed JSON serialization")
+	}
+	recipients := m.Recipients
+	if m.Header != nil || m.EncryptedKey != "" {
+		if recipients != nil {
+			return nil, nil, errors.New("jwe: both flattened and general JSON serialization")
+		}
+		recipients = []jsonRecipient{{m.Header, m.EncryptedKey}}
+	}
+	if len(recipients) == 0 {
+		return nil, nil, errors.New("jwe: no recipients")
+	}
+
+	var protected Header
+	if m.Protected != "" {
+		if protected, err = decodeHeader(m.Protected); err != nil {
+			return nil, nil, err
+		}
+	}
+	var fields [3][]byte
+	for i, s := range []string{m.IV, m.Ciphertext, m.Tag} {
+		if fields[i], err = b64.DecodeString(s); err != nil {
+			return nil, nil, errors.New("jwe: malformed JSON serialization")
+		}
+	}
+	if m.AAD != "" {
+		if aad, err = b64.DecodeString(m.AAD); err != nil {
+			return nil, nil, errors.New("jwe: malformed JSON serialization")
+		}
+	}
+
+	for _, jr := range recipients {
+		h, err := mergeHeaders(protected, m.Unprotected, jr.Header)
+		if err != nil {
+			return nil, nil, err
+		}
+		if h["alg"] != r.Alg || (r.KeyID != "" && h["kid"] != r.KeyID) {
+			continue
+		}
+		if err := checkHeader(h); err != nil {
+			return nil, nil, err
+		}
+		ek, err := b64.DecodeString(jr.EncryptedKey)
+		if err != nil {
+			return nil, nil, errors.New("jwe: malformed JSON serialization")
+		}
+		cek, err := r.unwrap(ek)
+		if err != nil {
+			continue
+		}
+		pt, err := open(cek, fields[0], fields[1], fields[2], m.fullAAD())
+		if err != nil {
+			return nil, nil, err
+		}
+		return pt, aad, nil
+	}
+	return nil, nil, errors.New("jwe: no matching recipient")
+}
@@ -0,0 +1,238 @@
+package jwe
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+var (
+	testKey  = []byte("0123456789abcdef")
+	otherKey = []byte("fedcba9876543210")
+	testMsg  = []byte("Live long and prosper.")
+)
+
+// Regression vectors generated with this implementation,
+// with testKey wrapped by AlgKeyWrap under key ID "k1".
+const (
+	testCompact = "eyJhbGciOiJEWDJLVyIsImVuYyI6IkRYMi0xMjgiLCJraWQiOiJrMSIsInR5cCI6IkpXVCJ9." +
+		"4hQ7j2hA_8FCU-EiuNbdAnDHbjIzRERDaVQzmeYIrIE.G0npG4HIvUbR5MgYST1C." +
+		"pkVqdl3hyQCJ00fNY-IFlLHCFECw2A.Ak9PXjYrj8PfEjPhtBIaAg"
+	testJSON = `{"protected":"eyJlbmMiOiJEWDItMTI4In0","recipients":[{"header":{"alg":"DX2KW","kid":"k1"},` +
+		`"encrypted_key":"c8aoEy1xNvNIS5OluWTlXjUSs78rdW2I9PbwYHYz9M0"}],"aad":"YWFk",` +
+		`"iv":"TKZrxeuiWLVeWAJEzSry","ciphertext":"QXzPqA_GSprBIKSUHF41dwpIDACzaQ","tag":"9edMWnGIBwTRYxRkJ0D6eA"}`
+)
+
+func TestVectors(t *testing.T) {
+	r := Recipient{AlgKeyWrap, testKey, "k1"}
+	pt, h, err := DecryptCompact(testCompact, r)
+	if err != nil || !bytes.Equal(pt, testMsg) {
+		t.Errorf("DecryptCompact = %q, %v; want %q", pt, err, testMsg)
+	}
+	if h["typ"] != "JWT" || h["kid"] != "k1" {
+		t.Errorf("DecryptCompact header = %v", h)
+	}
+	pt, aad, err := DecryptJSON([]byte(testJSON), r)
+	if err != nil || !bytes.Equal(pt, testMsg) || string(aad) != "aad" {
+		t.Errorf("DecryptJSON = %q, %q, %v; want %q, %q", pt, aad, err, testMsg, "aad")
+	}
+}
+
+func TestCompact(t *testing.T) {
+	for _, r := range []Recipient{
+		{AlgDirect, testKey, ""},
+		{AlgKeyWrap, testKey, "k1"},
+	} {
+		token, err := EncryptCompact(r, testMsg, Header{"cty": "text/plain"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts := strings.Split(token, ".")
+		if len(parts) != 5 {
+			t.Fatalf("%s: token has %d parts, want 5", r.Alg, len(parts))
+		}
+		if (parts[1] == "") != (r.Alg == AlgDirect) {
+			t.Errorf("%s: encrypted key = %q", r.Alg, parts[1])
+		}
+		pt, h, err := DecryptCompact(token, r)
+		if err != nil || !bytes.Equal(pt, testMsg) {
+			t.Errorf("%s: DecryptCompact = %q, %v; want %q", r.Alg, pt, err, testMsg)
+		}
+		if h["alg"] != r.Alg || h["enc"] != EncDeoxysII128 || h["cty"] != "text/plain" {
+			t.Errorf("%s: header = %v", r.Alg, h)
+		}
+
+		if _, _, err := DecryptCompact(token, Recipient{r.Alg, otherKey, ""}); err == nil {
+			t.Errorf("%s: DecryptCompact with the wrong key succeeded", r.Alg)
+		}
+		other := AlgKeyWrap
+		if r.Alg == AlgKeyWrap {
+			other = AlgDirect
+		}
+		if _, _, err := DecryptCompact(token, Recipient{other, testKey, ""}); err == nil {
+			t.Errorf("%s: DecryptCompact with alg %s succeeded", r.Alg, other)
+		}
+		for i := range parts {
+			if parts[i] == "" {
+				continue
+			}
+			p := append([]string(nil), parts...)
+			b := []byte(p[i])
+			if b[0] == 'A' {
+				b[0] = 'B'
+			} else {
+				b[0] = 'A'
+			}
+			p[i] = string(b)
+			if _, _, err := DecryptCompact(strings.Join(p, "."), r); err == nil {
+				t.Errorf("%s: DecryptCompact succeeded with part %d modified", r.Alg, i)
+			}
+		}
+	}
+}
+
+func TestCompactErrors(t *testing.T) {
+	r := Recipient{AlgKeyWrap, testKey, ""}
+	for _, extra := range []Header{
+		{"enc": "A128GCM"},
+		{"alg": "dir"},
+		{"zip": "DEF"},
+		{"crit": []string{"exp"}},
+	} {
+		if _, err := EncryptCompact(r, nil, extra); err == nil {
+			t.Errorf("EncryptCompact accepted extra header %v", extra)
+		}
+	}
+	for _, bad := range []Recipient{
+		{"A128KW", testKey, ""},
+		{AlgDirect, testKey[:15], ""},
+	} {
+		if _, err := EncryptCompact(bad, nil, nil); err == nil {
+			t.Errorf("EncryptCompact accepted recipient %+v", bad)
+		}
+	}
+
+	token, _ := EncryptCompact(r, testMsg, nil)
+	parts := strings.Split(token, ".")
+	header := func(h string) string {
+		return b64.EncodeToString([]byte(h)) + "." + strings.Join(parts[1:], ".")
+	}
+	for _, bad := range []string{
+		"",
+		"a.b.c.d",
+		token + ".",
+		token + "=",
+		header(`{"alg":"DX2KW","enc":"A128GCM"}`),
+		header(`{"alg":"DX2KW","enc":"DX2-128","crit":["b64"]}`),
+		header(`{"alg":"DX2KW","enc":"DX2-128","zip":"DEF"}`),
+		header(`null`),
+		header(`[]`),
+	} {
+		if _, _, err := DecryptCompact(bad, r); err == nil {
+			t.Errorf("DecryptCompact(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestKeyWrapDeterministic(t *testing.T) {
+	r := Recipient{AlgKeyWrap, testKey, ""}
+	cek := []byte("content key 0001")
+	w := r.wrap(cek)
+	if len(w) != wrappedKeySize || !bytes.Equal(w, r.wrap(cek)) {
+		t.Errorf("wrap is not deterministic: %x", w)
+	}
+	if k, err := r.unwrap(w); err != nil || !bytes.Equal(k, cek) {
+		t.Errorf("unwrap = %x, %v; want %x", k, err, cek)
+	}
+	w[0] ^= 1
+	if _, err := r.unwrap(w); err == nil {
+		t.Errorf("unwrap of a modified key succeeded")
+	}
+}
+
+func TestJSON(t *testing.T) {
+	recipients := []Recipient{
+		{AlgKeyWrap, testKey, "k1"},
+		{AlgKeyWrap, otherKey, "k2"},
+	}
+	data, err := EncryptJSON(recipients, testMsg, []byte("aad"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range recipients {
+		pt, aad, err := DecryptJSON(data, r)
+		if err != nil || !bytes.Equal(pt, testMsg) || string(aad) != "aad" {
+			t.Errorf("%s: DecryptJSON = %q, %q, %v", r.KeyID, pt, aad, err)
+		}
+		// Without a key ID, every recipient is tried.
+		if pt, _, err := DecryptJSON(data, Recipient{r.Alg, r.Key, ""}); err != nil || !bytes.Equal(pt, testMsg) {
+			t.Errorf("%s: DecryptJSON without key ID = %q, %v", r.KeyID, pt, err)
+		}
+	}
+	if _, _, err := DecryptJSON(data, Recipient{AlgKeyWrap, testKey, "k2"}); err == nil {
+		t.Errorf("DecryptJSON with the wrong key ID succeeded")
+	}
+	if _, _, err := DecryptJSON(data, Recipient{AlgKeyWrap, []byte("0000000000000000"), ""}); err == nil {
+		t.Errorf("DecryptJSON with the wrong key succeeded")
+	}
+
+	var m map[string]interface{}
+	json.Unmarshal(data, &m)
+	m["aad"] = b64.EncodeToString([]byte("AAD"))
+	tampered, _ := json.Marshal(m)
+	if _, _, err := DecryptJSON(tampered, recipients[0]); err == nil {
+		t.Errorf("DecryptJSON succeeded with modified aad")
+	}
+	delete(m, "aad")
+	tampered, _ = json.Marshal(m)
+	if _, _, err := DecryptJSON(tampered, recipients[0]); err == nil {
+		t.Errorf("DecryptJSON succeeded with aad removed")
+	}
+}
+
+func TestJSONFlattened(t *testing.T) {
+	r := Recipient{AlgDirect, testKey, ""}
+	data, err := EncryptJSON([]Recipient{r}, testMsg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m jsonMessage
+	if err := json.Unmarshal(data, &m); err != nil {
+		t.Fatal(err)
+	}
+	m.Header, m.EncryptedKey = m.Recipients[0].Header, m.Recipients[0].EncryptedKey
+	m.Recipients = nil
+	flat, _ := json.Marshal(m)
+	if pt, _, err := DecryptJSON(flat, r); err != nil || !bytes.Equal(pt, testMsg) {
+		t.Errorf("DecryptJSON(flattened) = %q, %v; want %q", pt, err, testMsg)
+	}
+
+	// A parameter may only appear in one header.
+	m.Unprotected = Header{"alg": AlgDirect}
+	dup, _ := json.Marshal(m)
+	if _, _, err := DecryptJSON(dup, r); err == nil {
+		t.Errorf("DecryptJSON accepted a duplicate header parameter")
+	}
+}
+
+func TestJSONErrors(t *testing.T) {
+	if _, err := EncryptJSON(nil, testMsg, nil); err == nil {
+		t.Errorf("EncryptJSON accepted no recipients")
+	}
+	two := []Recipient{{AlgDirect, testKey, ""}, {AlgKeyWrap, otherKey, ""}}
+	if _, err := EncryptJSON(two, testMsg, nil); err == nil {
+		t.Errorf("EncryptJSON accepted direct encryption with two recipients")
+	}
+	r := Recipient{AlgDirect, testKey, ""}
+	for _, bad := range []string{
+		``,
+		`{}`,
+		`{"iv":"","ciphertext":"","tag":"","recipients":[]}`,
+		`{"protected":"!!","recipients":[{"header":{"alg":"dir"}}],"iv":"","ciphertext":"","tag":""}`,
+	} {
+		if _, _, err := DecryptJSON([]byte(bad), r); err == nil {
+			t.Errorf("DecryptJSON(%q) succeeded", bad)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package deoxys
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+)
+
+// KDF is a key derivation function in the style of
+// NIST SP 800-108 counter mode, with Deoxys-BC as the PRF.
+//
+// The label and context are hashed with SHA-256 into a 32-byte digest H.
+// Output block i (counting from 1) is
+//
+//	E_K^{d || H[0:11] || i}(H[11:27])
+//
+// where d is a tweak domain that no other construction in this package uses
+// and i is a 32-bit big-endian counter. Every output block uses a different
+// tweak, so the output is indistinguishable from random as long as
+// Deoxys-BC is a secure tweakable block cipher.
+type KDF struct {
+	subkey  [numRounds][16]uint8
+	tweak   [16]uint8
+	in      [16]byte
+	counter uint32
+	buf     [16]byte
+	n       int // unread bytes at the end of buf
+}
+
+// NewKDF returns a KDF that derives keying material
+// from a 16-byte key, a label and a context.
+// The label typically names the purpose of the derived key,
+// and the context identifies the party or object it belongs to.
+func NewKDF(key, label, context []byte) *KDF {
+	k := new(KDF)
+	expandKey(key, k.subkey[:])
+
+	h := sha256.New()
+	var length [4]byte
+	binary.BigEndian.PutUint32(length[:], uint32(len(label)))
+	h.Write(length[:])
+	h.Write(label)
+	binary.BigEndian.PutUint32(length[:], uint32(len(context)))
+	h.Write(length[:])
+	h.Write(context)
+	sum := h.Sum(nil)
+
+	k.tweak[0] = domainKDF
+	copy(k.tweak[1:12], sum[0:11])
+	copy(k.in[:], sum[11:27])
+	return k
+}
+
+// Read fills p with derived keying material.
+// Consecutive reads continue the same output stream.
+// It returns an error only after 2^36 bytes have been read.
+func (k *KDF) Read(p []byte) (int, error) {
+	n := 0
+	for len(p) > 0 {
+		if k.n == 0 {
+			if k.counter == 1<<32-1 {
+				return n, errors.New("KDF: output limit reached")
+			}
+			k.counter++
+			binary.BigEndian.PutUint32(k.tweak[12:], k.counter)
+			encryptBlock(k.subkey[:], k.tweak[:], k.in[:], k.buf[:])
+			k.n = len(k.buf)
+		}
+		c := copy(p, k.buf[len(k.buf)-k.n:])
+		k.n -= c
+		n += c
+		p = p[c:]
+	}
+	return n, nil
+}
+
+// DeriveKey returns the first n bytes of NewKDF(key, label, context).
+func DeriveKey(key, label, context []byte, n int) []byte {
+	out := make([]byte, n)
+	if _, err := NewKDF(key, label, context).Read(out); err != nil {
+		panic(err)
+	}
+	return out
+}
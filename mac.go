@@ -0,0 +1,92 @@
+package deoxys
+
+import "hash"
+
+// MACSize is the size of a MAC in bytes.
+const MACSize = TagSize
+
+type mac struct {
+	subkey  [numRounds][16]uint8
+	counter [16]uint8
+	auth    [16]byte
+	buf     [16]byte
+	n       int
+}
+
+// NewMAC returns a hash.Hash computing the Deoxys-II MAC of its input.
+//
+// The MAC is the tag that AEAD.Seal produces for an empty message,
+// with the input as additional data and an all-zero nonce.
+// Since the nonce is fixed the MAC is deterministic:
+// equal inputs under the same key always produce equal MACs.
+func NewMAC(key []byte) hash.Hash {
+	d := new(mac)
+	expandKey(key, d.subkey[:])
+	d.Reset()
+	return d
+}
+
+func (d *mac) Size() int      { return MACSize }
+func (d *mac) BlockSize() int { return blockSize }
+
+func (d *mac) Reset() {
+	d.counter = [16]uint8{tagAdditionalData}
+	d.auth = [16]byte{}
+	d.buf = [16]byte{}
+	d.n = 0
+}
+
+func (d *mac) Write(p []byte) (int, error) {
+	nn := len(p)
+	if d.n > 0 {
+		k := copy(d.buf[d.n:], p)
+		d.n += k
+		p = p[k:]
+		if d.n < blockSize {
+			return nn, nil
+		}
+		d.block(d.buf[:])
+		d.n = 0
+	}
+	for len(p) >= blockSize {
+		d.block(p[:blockSize])
+		p = p[blockSize:]
+	}
+	d.n = copy(d.buf[:], p)
+	return nn, nil
+}
+
+func (d *mac) block(p []byte) {
+	var tmp [16]byte
+	encryptBlock(d.subkey[:], d.counter[:], p, tmp[:])
+	xor(d.auth[:], tmp[:])
+	d.inc()
+}
+
+func (d *mac) inc() {
+	for i := len(d.counter) - 1; i >= 0; i-- {
+		d.counter[i]++
+		if d.counter[i] != 0 {
+			return
+		}
+	}
+}
+
+// Sum appends the MAC of the data written so far to b.
+// It does not change the underlying state.
+func (d *mac) Sum(b []byte) []byte {
+	auth := d.auth
+	var tweak [16]uint8
+	if d.n > 0 {
+		var tmp [16]byte
+		copy(tmp[:], d.buf[:d.n])
+		tmp[d.n] = padByte
+		tweak = d.counter
+		tweak[0] |= tagPadding
+		encryptBlock(d.subkey[:], tweak[:], tmp[:], tmp[:])
+		xor(auth[:], tmp[:])
+	}
+	tweak = [16]uint8{tagNonce}
+	encryptBlock(d.subkey[:], tweak[:], auth[:], auth[:])
+	return append(b, auth[:]...)
+}
@@ -0,0 +1,63 @@
+package deoxys
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestMACMatchesSeal(t *testing.T) {
+	key := []byte("16-byte password")
+	nonce := make([]byte, NonceSize)
+	m := New(key)
+	data := seq(100)
+	for n := 0; n <= len(data); n++ {
+		want := m.Seal(nil, nonce, nil, data[:n])
+		for _, chunk := range []int{1, 5, 16, 17, 100} {
+			h := NewMAC(key)
+			for p := data[:n]; len(p) > 0; {
+				k := chunk
+				if k > len(p) {
+					k = len(p)
+				}
+				h.Write(p[:k])
+				p = p[k:]
+			}
+			got := h.Sum(nil)
+			if !bytes.Equal(got, want) {
+				t.Errorf("MAC(%d bytes, chunk %d) = %x, want %x", n, chunk, got, want)
+			}
+		}
+	}
+}
+
+func TestMACSumReset(t *testing.T) {
+	h := NewMAC([]byte("16-byte password"))
+	h.Write([]byte("A witty saying"))
+	s0 := h.Sum(nil)
+	s1 := h.Sum(nil)
+	if !bytes.Equal(s0, s1) {
+		t.Errorf("Sum changed the state: got %x, then %x", s0, s1)
+	}
+	h.Write([]byte(" means nothing."))
+	s2 := h.Sum([]byte("prefix"))
+	if !bytes.HasPrefix(s2, []byte("prefix")) || len(s2) != len("prefix")+h.Size() {
+		t.Errorf("Sum did not append to its argument: %x", s2)
+	}
+
+	h.Reset()
+	h.Write([]byte("A witty saying"))
+	if s3 := h.Sum(nil); !bytes.Equal(s3, s0) {
+		t.Errorf("after Reset: got %x, want %x", s3, s0)
+	}
+}
+
+func BenchmarkMAC(b *testing.B) {
+	h := NewMAC([]byte("16-byte password"))
+	msg := make([]byte, 1024)
+	b.SetBytes(int64(len(msg)))
+	for i := 0; i < b.N; i++ {
+		h.Reset()
+		h.Write(msg)
+		h.Sum(nil)
+	}
+}
@@ -0,0 +1,63 @@
+package deoxys
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+)
+
+// The serialized key schedule is laid out as
+//
+//	magic (4 bytes) || version (1 byte) || rounds (1 byte) || subkeys || crc32c (4 bytes)
+//
+// The checksum covers everything before it. It protects against
+// accidental corruption, not against tampering; a serialized key
+// schedule is as sensitive as the key itself.
+const (
+	scheduleMagic   = "DXKS"
+	scheduleVersion = 1
+	scheduleSize    = len(scheduleMagic) + 2 + numRounds*16 + 4
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// MarshalBinary returns the expanded key schedule in a versioned binary format.
+// It implements the encoding.BinaryMarshaler interface.
+func (m *AEAD) MarshalBinary() ([]byte, error) {
+	b := make([]byte, 0, scheduleSize)
+	b = append(b, scheduleMagic...)
+	b = append(b, scheduleVersion, numRounds)
+	for i := range m.subkey {
+		b = append(b, m.subkey[i][:]...)
+	}
+	b = binary.BigEndian.AppendUint32(b, crc32.Checksum(b, castagnoli))
+	return b, nil
+}
+
+// UnmarshalBinary loads a key schedule produced by MarshalBinary,
+// replacing the current key.
+// It implements the encoding.BinaryUnmarshaler interface.
+func (m *AEAD) UnmarshalBinary(data []byte) error {
+	if len(data) != scheduleSize {
+		return errors.New("UnmarshalBinary: wrong size key schedule")
+	}
+	if string(data[:len(scheduleMagic)]) != scheduleMagic {
+		return errors.New("UnmarshalBinary: not a key schedule")
+	}
+	p := data[len(scheduleMagic):]
+	if p[0] != scheduleVersion {
+		return errors.New("UnmarshalBinary: unsupported version")
+	}
+	if p[1] != numRounds {
+		return errors.New("UnmarshalBinary: wrong number of rounds")
+	}
+	p = p[2:]
+	sum := binary.BigEndian.Uint32(data[len(data)-4:])
+	if crc32.Checksum(data[:len(data)-4], castagnoli) != sum {
+		return errors.New("UnmarshalBinary: checksum mismatch")
+	}
+	for i := range m.subkey {
+		copy(m.subkey[i][:], p[i*16:])
+	}
+	return nil
+}
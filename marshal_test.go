@@ -0,0 +1,59 @@
+package deoxys
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestMarshalKeySchedule(t *testing.T) {
+	key := []byte("16-byte password")
+	nonce := make([]byte, NonceSize)
+	msg := []byte("A witty saying means nothing.")
+	m := New(key)
+
+	b, err := m.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b) != scheduleSize {
+		t.Errorf("MarshalBinary returned %d bytes, expected %d", len(b), scheduleSize)
+	}
+
+	m2 := new(AEAD)
+	if err := m2.UnmarshalBinary(b); err != nil {
+		t.Fatal(err)
+	}
+	c0 := m.Seal(nil, nonce, msg, nil)
+	c1 := m2.Seal(nil, nonce, msg, nil)
+	if !bytes.Equal(c0, c1) {
+		t.Errorf("Seal with loaded key schedule = %x, want %x", c1, c0)
+	}
+}
+
+func TestUnmarshalKeyScheduleErrors(t *testing.T) {
+	b, _ := New([]byte("16-byte password")).MarshalBinary()
+
+	corrupt := func(i int) []byte {
+		c := append([]byte(nil), b...)
+		c[i] ^= 1
+		return c
+	}
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"short", b[:len(b)-1]},
+		{"magic", corrupt(0)},
+		{"version", corrupt(4)},
+		{"rounds", corrupt(5)},
+		{"subkey", corrupt(6)},
+		{"checksum", corrupt(len(b) - 1)},
+	}
+	for _, tt := range tests {
+		m := new(AEAD)
+		if err := m.UnmarshalBinary(tt.data); err == nil {
+			t.Errorf("%s: UnmarshalBinary succeeded, expected an error", tt.name)
+		}
+	}
+}
@@ -0,0 +1,277 @@
+package noise
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"errors"
+	"io"
+)
+
+// A token is a handshake message token.
+type token uint8
+
+const (
+	tokenE token = iota
+	tokenS
+	tokenEE
+	tokenES
+	tokenSE
+	tokenSS
+)
+
+// A HandshakePattern is a Noise handshake pattern without pre-messages.
+type HandshakePattern struct {
+	Name     string
+	messages [][]token
+}
+
+// Supported handshake patterns.
+var (
+	HandshakeNN = HandshakePattern{
+		Name: "NN",
+		messages: [][]token{
+			{tokenE},
+			{tokenE, tokenEE},
+		},
+	}
+	HandshakeXX = HandshakePattern{
+		Name: "XX",
+		messages: [][]token{
+			{tokenE},
+			{tokenE, tokenEE, tokenS, tokenES},
+			{tokenS, tokenSE},
+		},
+	}
+)
+
+const (
+	dhLen  = 32
+	tagLen = 16 // every Noise cipher has a 16-byte tag
+)
+
+var errShortMessage = errors.New("noise: message too short")
+
+// A Config configures a HandshakeState.
+type Config struct {
+	Pattern   HandshakePattern
+	Initiator bool
+	Prologue  []byte
+
+	// StaticKeypair is the local X25519 static key,
+	// required by patterns that send one.
+	StaticKeypair *ecdh.PrivateKey
+
+	// Cipher is the cipher function. If nil, CipherDeoxysII is used.
+	Cipher CipherFunc
+
+	// Random is the source of ephemeral keys.
+	// If nil, crypto/rand.Reader is used.
+	Random io.Reader
+}
+
+// A HandshakeState runs one side of a Noise handshake.
+type HandshakeState struct {
+	ss        SymmetricState
+	s, e      *ecdh.PrivateKey
+	rs, re    *ecdh.PublicKey
+	pattern   HandshakePattern
+	initiator bool
+	random    io.Reader
+	msg       int // index of the next message in the pattern
+}
+
+// NewHandshakeState returns a HandshakeState for the protocol
+// Noise_<pattern>_25519_<cipher>_SHA256, by default
+// Noise_<pattern>_25519_DeoxysII_SHA256.
+func NewHandshakeState(c Config) (*HandshakeState, error) {
+	if len(c.Pattern.messages) == 0 {
+		return nil, errors.New("noise: empty handshake pattern")
+	}
+	if c.StaticKeypair != nil && c.StaticKeypair.Curve() != ecdh.X25519() {
+		return nil, errors.New("noise: static key is not an X25519 key")
+	}
+	hs := &HandshakeState{
+		s:         c.StaticKeypair,
+		pattern:   c.Pattern,
+		initiator: c.Initiator,
+		random:    c.Random,
+	}
+	if hs.random == nil {
+		hs.random = rand.Reader
+	}
+	for i, m := range c.Pattern.messages {
+		mine := (i%2 == 0) == c.Initiator
+		for _, t := range m {
+			if t == tokenS && mine && hs.s == nil {
+				return nil, errors.New("noise: pattern requires a static key")
+			}
+		}
+	}
+	fn := c.Cipher
+	if fn == nil {
+		fn = CipherDeoxysII
+	}
+	name := "Noise_" + c.Pattern.Name + "_25519_" + fn.CipherName() + "_SHA256"
+	hs.ss.InitializeSymmetric(fn, []byte(name))
+	hs.ss.MixHash(c.Prologue)
+	return hs, nil
+}
+
+// PeerStatic returns the remote party's static public key,
+// once it has been received.
+func (hs *HandshakeState) PeerStatic() *ecdh.PublicKey { return hs.rs }
+
+// HandshakeHash returns the handshake hash, which
+// identifies the session once the handshake is complete.
+func (hs *HandshakeState) HandshakeHash() []byte { return hs.ss.HandshakeHash() }
+
+func (hs *HandshakeState) generateEphemeral() error {
+	var b [dhLen]byte
+	if _, err := io.ReadFull(hs.random, b[:]); err != nil {
+		return err
+	}
+	e, err := ecdh.X25519().NewPrivateKey(b[:])
+	if err != nil {
+		return err
+	}
+	hs.e = e
+	return nil
+}
+
+func (hs *HandshakeState) mixDH(priv *ecdh.PrivateKey, pub *ecdh.PublicKey) error {
+	if priv == nil || pub == nil {
+		return errors.New("noise: missing key for DH")
+	}
+	shared, err := priv.ECDH(pub)
+	if err != nil {
+		return err
+	}
+	hs.ss.MixKey(shared)
+	return nil
+}
+
+// dh performs the DH for t, from this party's point of view.
+func (hs *HandshakeState) dh(t token) error {
+	switch {
+	case t == tokenEE:
+		return hs.mixDH(hs.e, hs.re)
+	case t == tokenSS:
+		return hs.mixDH(hs.s, hs.rs)
+	case (t == tokenES) == hs.initiator:
+		return hs.mixDH(hs.e, hs.rs)
+	default:
+		return hs.mixDH(hs.s, hs.re)
+	}
+}
+
+// next returns the tokens of the next message, checking
+// that it is this party's turn to write (or read).
+func (hs *HandshakeState) next(write bool) ([]token, error) {
+	if hs.msg >= len(hs.pattern.messages) {
+		return nil, errors.New("noise: handshake is complete")
+	}
+	if (hs.msg%2 == 0) == hs.initiator != write {
+		return nil, errors.New("noise: out of turn")
+	}
+	return hs.pattern.messages[hs.msg], nil
+}
+
+// finish advances to the next message, and returns
+// the transport CipherStates after the last one.
+func (hs *HandshakeState) finish() (*CipherState, *CipherState) {
+	hs.msg++
+	if hs.msg < len(hs.pattern.messages) {
+		return nil, nil
+	}
+	return hs.ss.Split()
+}
+
+// WriteMessage appends the next handshake message, carrying payload, to out.
+// After the last message of the handshake, it returns the
+// CipherStates for messages from the initiator and from the responder.
+func (hs *HandshakeState) WriteMessage(out, payload []byte) ([]byte, *CipherState, *CipherState, error) {
+	tokens, err := hs.next(true)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	start := len(out)
+	for _, t := range tokens {
+		switch t {
+		case tokenE:
+			if err := hs.generateEphemeral(); err != nil {
+				return nil, nil, nil, err
+			}
+			pub := hs.e.PublicKey().Bytes()
+			out = append(out, pub...)
+			hs.ss.MixHash(pub)
+		case tokenS:
+			if out, err = hs.ss.EncryptAndHash(out, hs.s.PublicKey().Bytes()); err != nil {
+				return nil, nil, nil, err
+			}
+		default:
+			if err := hs.dh(t); err != nil {
+				return nil, nil, nil, err
+			}
+		}
+	}
+	if out, err = hs.ss.EncryptAndHash(out, payload); err != nil {
+		return nil, nil, nil, err
+	}
+	if len(out)-start > MaxMessageSize {
+		return nil, nil, nil, errMessageTooLong
+	}
+	c1, c2 := hs.finish()
+	return out, c1, c2, nil
+}
+
+// ReadMessage processes the next handshake message and appends
+// its payload to out. After the last message of the handshake, it returns
+// the CipherStates for messages from the initiator and from the responder.
+// A HandshakeState that fails to read a message must not be used again.
+func (hs *HandshakeState) ReadMessage(out, message []byte) ([]byte, *CipherState, *CipherState, error) {
+	tokens, err := hs.next(false)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if len(message) > MaxMessageSize {
+		return nil, nil, nil, errMessageTooLong
+	}
+	for _, t := range tokens {
+		switch t {
+		case tokenE:
+			if len(message) < dhLen {
+				return nil, nil, nil, errShortMessage
+			}
+			if hs.re, err = ecdh.X25519().NewPublicKey(message[:dhLen]); err != nil {
+				return nil, nil, nil, err
+			}
+			hs.ss.MixHash(message[:dhLen])
+			message = message[dhLen:]
+		case tokenS:
+			n := dhLen
+			if hs.ss.cs.HasKey() {
+				n += tagLen
+			}
+			if len(message) < n {
+				return nil, nil, nil, errShortMessage
+			}
+			s, err := hs.ss.DecryptAndHash(nil, message[:n])
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			if hs.rs, err = ecdh.X25519().NewPublicKey(s); err != nil {
+				return nil, nil, nil, err
+			}
+			message = message[n:]
+		default:
+			if err := hs.dh(t); err != nil {
+				return nil, nil, nil, err
+			}
+		}
+	}
+	if out, err = hs.ss.DecryptAndHash(out, message); err != nil {
+		return nil, nil, nil, err
+	}
+	c1, c2 := hs.finish()
+	return out, c1, c2, nil
+}
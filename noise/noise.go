@@ -0,0 +1,289 @@
+// Package noise implements the Deoxys-II cipher functions for the
+// Noise Protocol Framework, along with the CipherState, SymmetricState
+// and HandshakeState objects needed to run handshakes with them.
+//
+// The cipher is named "DeoxysII". Noise cipher keys are 32 bytes;
+// Deoxys-II-128 uses the first 16 of them and ignores the rest, so
+// the cipher offers 128-bit security, not the 256 bits that the key
+// size suggests. Noise keys are HKDF outputs, so the first 16 bytes
+// are a uniformly random 128-bit key, and deriving a key from all 32
+// bytes would not make it any stronger. The 64-bit Noise nonce n is
+// encoded into the 15-byte Deoxys-II nonce as 7 zero bytes followed by
+// n in big-endian order. REKEY is the default from section 4.2 of the
+// specification: the first 32 bytes of encrypting 32 zero bytes
+// with the maximum nonce. Ciphers of other cipher functions that define
+// their own REKEY can provide it by implementing Rekeyer.
+//
+// The CipherFunc and Cipher interfaces have the same shape as those of
+// other Go Noise implementations, so CipherDeoxysII can be plugged into
+// them. The handshake code here depends only on the standard library,
+// with X25519 for DH and SHA-256 as the hash.
+//
+// Noise Protocol Framework, revision 34:
+//
+//	https://noiseprotocol.org/noise.html
+package noise
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"math"
+
+	"github.com/magical/deoxys"
+)
+
+// A Cipher is a Noise cipher instantiated with a key.
+type Cipher interface {
+	// Encrypt appends the encryption of plaintext with nonce n to out.
+	Encrypt(out []byte, n uint64, ad, plaintext []byte) []byte
+	// Decrypt appends the decryption of ciphertext with nonce n to out.
+	Decrypt(out []byte, n uint64, ad, ciphertext []byte) ([]byte, error)
+}
+
+// A Rekeyer is a Cipher with its own REKEY function,
+// for cipher functions whose specification defines one.
+type Rekeyer interface {
+	Cipher
+	// Rekey returns REKEY(k) for the cipher's key k.
+	Rekey() [32]byte
+}
+
+// A CipherFunc creates Ciphers from 32-byte keys.
+type CipherFunc interface {
+	Cipher(k [32]byte) Cipher
+	CipherName() string
+}
+
+// CipherDeoxysII is the "DeoxysII" cipher function.
+// It has a 128-bit security level: its Ciphers use only
+// the first 16 bytes of their 32-byte keys.
+var CipherDeoxysII CipherFunc = cipherFn{}
+
+type cipherFn struct{}
+
+func (cipherFn) Cipher(k [32]byte) Cipher {
+	return deoxysCipher{deoxys.New(k[:16])}
+}
+
+func (cipherFn) CipherName() string { return "DeoxysII" }
+
+type deoxysCipher struct {
+	aead *deoxys.AEAD
+}
+
+func nonce(n uint64) []byte {
+	var b [deoxys.NonceSize]byte
+	binary.BigEndian.PutUint64(b[deoxys.NonceSize-8:], n)
+	return b[:]
+}
+
+func (c deoxysCipher) Encrypt(out []byte, n uint64, ad, plaintext []byte) []byte {
+	return c.aead.Seal(out, nonce(n), plaintext, ad)
+}
+
+func (c deoxysCipher) Decrypt(out []byte, n uint64, ad, ciphertext []byte) ([]byte, error) {
+	return c.aead.Open(out, nonce(n), ciphertext, ad)
+}
+
+// Rekey returns REKEY(k) for the cipher c with key k.
+// If c is a Rekeyer, Rekey calls its Rekey method; otherwise it uses the
+// default REKEY of section 4.2 of the specification, the first 32 bytes of
+// the encryption of 32 zero bytes with the maximum nonce.
+func Rekey(c Cipher) [32]byte {
+	if r, ok := c.(Rekeyer); ok {
+		return r.Rekey()
+	}
+	var zeros, k [32]byte
+	copy(k[:], c.Encrypt(nil, math.MaxUint64, nil, zeros[:]))
+	return k
+}
+
+const (
+	hashLen = sha256.Size
+
+	// MaxMessageSize is the largest Noise message.
+	MaxMessageSize = 65535
+)
+
+var (
+	errNonceExhausted = errors.New("noise: nonce exhausted")
+	errMessageTooLong = errors.New("noise: message too long")
+)
+
+// A CipherState encrypts and decrypts messages with a key and a counter nonce.
+type CipherState struct {
+	fn     CipherFunc
+	c      Cipher
+	k      [32]byte
+	n      uint64
+	hasKey bool
+}
+
+// NewCipherState returns a CipherState for fn without a key.
+func NewCipherState(fn CipherFunc) *CipherState {
+	return &CipherState{fn: fn}
+}
+
+// InitializeKey sets the key and resets the nonce.
+func (cs *CipherState) InitializeKey(k [32]byte) {
+	cs.k = k
+	cs.c = cs.fn.Cipher(k)
+	cs.n = 0
+	cs.hasKey = true
+}
+
+// HasKey reports whether the CipherState has a key.
+func (cs *CipherState) HasKey() bool { return cs.hasKey }
+
+// SetNonce sets the nonce, for use with out-of-order transport messages.
+func (cs *CipherState) SetNonce(n uint64) { cs.n = n }
+
+// Nonce returns the nonce the next message will use.
+func (cs *CipherState) Nonce() uint64 { return cs.n }
+
+// EncryptWithAd appends the encryption of plaintext to out
+// and increments the nonce. Without a key, it appends the plaintext.
+func (cs *CipherState) EncryptWithAd(out, ad, plaintext []byte) ([]byte, error) {
+	if !cs.hasKey {
+		return append(out, plaintext...), nil
+	}
+	// The maximum nonce is reserved for REKEY.
+	if cs.n == math.MaxUint64 {
+		return nil, errNonceExhausted
+	}
+	out = cs.c.Encrypt(out, cs.n, ad, plaintext)
+	cs.n++
+	return out, nil
+}
+
+// DecryptWithAd appends the decryption of ciphertext to out
+// and increments the nonce. Without a key, it appends the ciphertext.
+// The nonce is not incremented if authentication fails.
+func (cs *CipherState) DecryptWithAd(out, ad, ciphertext []byte) ([]byte, error) {
+	if !cs.hasKey {
+		return append(out, ciphertext...), nil
+	}
+	if cs.n == math.MaxUint64 {
+		return nil, errNonceExhausted
+	}
+	out, err := cs.c.Decrypt(out, cs.n, ad, ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	cs.n++
+	return out, nil
+}
+
+// Rekey replaces the key with REKEY of the key, as computed by the
+// package-level Rekey. The nonce is unchanged.
+func (cs *CipherState) Rekey() {
+	if !cs.hasKey {
+		return
+	}
+	cs.k = Rekey(cs.c)
+	cs.c = cs.fn.Cipher(cs.k)
+}
+
+// hkdf is the HKDF function of section 4.3 of the specification.
+func hkdf(chainingKey, ikm []byte, n int) [][hashLen]byte {
+	mac := hmac.New(sha256.New, chainingKey)
+	mac.Write(ikm)
+	temp := mac.Sum(nil)
+
+	out := make([][hashLen]byte, n)
+	var prev []byte
+	for i := range out {
+		mac := hmac.New(sha256.New, temp)
+		mac.Write(prev)
+		mac.Write([]byte{byte(i + 1)})
+		mac.Sum(out[i][:0])
+		prev = out[i][:]
+	}
+	return out
+}
+
+// A SymmetricState holds the chaining key and handshake hash of a handshake.
+type SymmetricState struct {
+	cs CipherState
+	ck [hashLen]byte
+	h  [hashLen]byte
+}
+
+// InitializeSymmetric starts a SymmetricState for the protocol name.
+func (ss *SymmetricState) InitializeSymmetric(fn CipherFunc, protocolName []byte) {
+	if len(protocolName) <= hashLen {
+		ss.h = [hashLen]byte{}
+		copy(ss.h[:], protocolName)
+	} else {
+		ss.h = sha256.Sum256(protocolName)
+	}
+	ss.ck = ss.h
+	ss.cs = CipherState{fn: fn}
+}
+
+// MixKey mixes input keying material into the chaining key
+// and sets a new key.
+func (ss *SymmetricState) MixKey(ikm []byte) {
+	out := hkdf(ss.ck[:], ikm, 2)
+	ss.ck = out[0]
+	ss.cs.InitializeKey(out[1])
+}
+
+// MixHash mixes data into the handshake hash.
+func (ss *SymmetricState) MixHash(data []byte) {
+	h := sha256.New()
+	h.Write(ss.h[:])
+	h.Write(data)
+	h.Sum(ss.h[:0])
+}
+
+// MixKeyAndHash mixes input keying material into the chaining key,
+// the handshake hash and the key, as used for pre-shared keys.
+func (ss *SymmetricState) MixKeyAndHash(ikm []byte) {
+	out := hkdf(ss.ck[:], ikm, 3)
+	ss.ck = out[0]
+	ss.MixHash(out[1][:])
+	ss.cs.InitializeKey(out[2])
+}
+
+// HandshakeHash returns the handshake hash h.
+func (ss *SymmetricState) HandshakeHash() []byte {
+	return append([]byte(nil), ss.h[:]...)
+}
+
+// EncryptAndHash appends the encryption of plaintext to out,
+// with the handshake hash as additional data, and mixes
+// the ciphertext into the handshake hash.
+func (ss *SymmetricState) EncryptAndHash(out, plaintext []byte) ([]byte, error) {
+	n := len(out)
+	out, err := ss.cs.EncryptWithAd(out, ss.h[:], plaintext)
+	if err != nil {
+		return nil, err
+	}
+	ss.MixHash(out[n:])
+	return out, nil
+}
+
+// DecryptAndHash appends the decryption of ciphertext to out
+// and mixes the ciphertext into the handshake hash.
+func (ss *SymmetricState) DecryptAndHash(out, ciphertext []byte) ([]byte, error) {
+	out, err := ss.cs.DecryptWithAd(out, ss.h[:], ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	ss.MixHash(ciphertext)
+	return out, nil
+}
+
+// Split returns the CipherStates for transport messages:
+// the first for messages from the initiator, the second for
+// messages from the responder.
+func (ss *SymmetricState) Split() (*CipherState, *CipherState) {
+	out := hkdf(ss.ck[:], nil, 2)
+	c1, c2 := NewCipherState(ss.cs.fn), NewCipherState(ss.cs.fn)
+	c1.InitializeKey(out[0])
+	c2.InitializeKey(out[1])
+	return c1, c2
+}
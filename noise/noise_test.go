@@ -0,0 +1,357 @@
+package noise
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"math"
+	"testing"
+
+	"github.com/magical/deoxys"
+	xhkdf "golang.org/x/crypto/hkdf"
+)
+
+func testKey() (k [32]byte) {
+	for i := range k {
+		k[i] = byte(i)
+	}
+	return k
+}
+
+func TestCipherNonce(t *testing.T) {
+	k := testKey()
+	c := CipherDeoxysII.Cipher(k)
+	aead := deoxys.New(k[:16])
+	for _, n := range []uint64{0, 1, 0x0102030405060708, math.MaxUint64} {
+		nonce := make([]byte, deoxys.NonceSize)
+		for i := 0; i < 8; i++ {
+			nonce[deoxys.NonceSize-1-i] = byte(n >> (8 * i))
+		}
+		ct := c.Encrypt(nil, n, []byte("ad"), []byte("plaintext"))
+		if want := aead.Seal(nil, nonce, []byte("plaintext"), []byte("ad")); !bytes.Equal(ct, want) {
+			t.Errorf("Encrypt(n=%#x) = %x, want %x", n, ct, want)
+		}
+		pt, err := c.Decrypt(nil, n, []byte("ad"), ct)
+		if err != nil || string(pt) != "plaintext" {
+			t.Errorf("Decrypt(n=%#x) = %q, %v", n, pt, err)
+		}
+		if _, err := c.Decrypt(nil, n+1, []byte("ad"), ct); err == nil {
+			t.Errorf("Decrypt(n=%#x) succeeded with the wrong nonce", n+1)
+		}
+	}
+	if CipherDeoxysII.CipherName() != "DeoxysII" {
+		t.Errorf("CipherName = %q", CipherDeoxysII.CipherName())
+	}
+}
+
+func TestRekey(t *testing.T) {
+	k := testKey()
+	c := CipherDeoxysII.Cipher(k)
+	var zeros [32]byte
+	want := c.Encrypt(nil, math.MaxUint64, nil, zeros[:])[:32]
+	if got := Rekey(c); !bytes.Equal(got[:], want) {
+		t.Errorf("Rekey = %x, want %x", got, want)
+	}
+
+	a, b := NewCipherState(CipherDeoxysII), NewCipherState(CipherDeoxysII)
+	a.InitializeKey(k)
+	b.InitializeKey(k)
+	ct, _ := a.EncryptWithAd(nil, nil, []byte("one"))
+	b.DecryptWithAd(nil, nil, ct)
+	a.Rekey()
+	if a.Nonce() != 1 {
+		t.Errorf("Rekey changed the nonce to %d", a.Nonce())
+	}
+	ct, _ = a.EncryptWithAd(nil, nil, []byte("two"))
+	if _, err := b.DecryptWithAd(nil, nil, ct); err == nil {
+		t.Errorf("DecryptWithAd succeeded before rekeying")
+	}
+	b.Rekey()
+	if pt, err := b.DecryptWithAd(nil, nil, ct); err != nil || string(pt) != "two" {
+		t.Errorf("DecryptWithAd after rekeying = %q, %v", pt, err)
+	}
+}
+
+// xorRekeyer is a Cipher with a custom REKEY, which complements the key.
+type xorRekeyer struct {
+	Cipher
+	k [32]byte
+}
+
+func (c xorRekeyer) Rekey() [32]byte {
+	k := c.k
+	for i := range k {
+		k[i] ^= 0xff
+	}
+	return k
+}
+
+type xorRekeyerFn struct{}
+
+func (xorRekeyerFn) Cipher(k [32]byte) Cipher {
+	return xorRekeyer{CipherDeoxysII.Cipher(k), k}
+}
+
+func (xorRekeyerFn) CipherName() string { return "DeoxysII" }
+
+func TestRekeyer(t *testing.T) {
+	k := testKey()
+	want := k
+	for i := range want {
+		want[i] ^= 0xff
+	}
+	if got := Rekey(xorRekeyerFn{}.Cipher(k)); got != want {
+		t.Errorf("Rekey = %x, want %x", got, want)
+	}
+
+	a := NewCipherState(xorRekeyerFn{})
+	a.InitializeKey(k)
+	a.Rekey()
+	b := NewCipherState(CipherDeoxysII)
+	b.InitializeKey(want)
+	ct, _ := a.EncryptWithAd(nil, nil, []byte("msg"))
+	if pt, err := b.DecryptWithAd(nil, nil, ct); err != nil || string(pt) != "msg" {
+		t.Errorf("CipherState.Rekey did not use the cipher's REKEY: %q, %v", pt, err)
+	}
+}
+
+func TestCipherState(t *testing.T) {
+	cs := NewCipherState(CipherDeoxysII)
+	if out, err := cs.EncryptWithAd(nil, []byte("ad"), []byte("clear")); err != nil || string(out) != "clear" {
+		t.Errorf("EncryptWithAd without a key = %q, %v", out, err)
+	}
+	cs.InitializeKey(testKey())
+	ct, _ := cs.EncryptWithAd(nil, nil, []byte("msg"))
+
+	rs := NewCipherState(CipherDeoxysII)
+	rs.InitializeKey(testKey())
+	bad := append([]byte(nil), ct...)
+	bad[0] ^= 1
+	if _, err := rs.DecryptWithAd(nil, nil, bad); err == nil || rs.Nonce() != 0 {
+		t.Errorf("DecryptWithAd of a bad message = %v, nonce %d", err, rs.Nonce())
+	}
+	if pt, err := rs.DecryptWithAd(nil, nil, ct); err != nil || string(pt) != "msg" || rs.Nonce() != 1 {
+		t.Errorf("DecryptWithAd = %q, %v, nonce %d", pt, err, rs.Nonce())
+	}
+
+	cs.SetNonce(math.MaxUint64)
+	if _, err := cs.EncryptWithAd(nil, nil, nil); err == nil {
+		t.Errorf("EncryptWithAd succeeded with the reserved nonce")
+	}
+}
+
+func TestHKDF(t *testing.T) {
+	ck := []byte("chaining key")
+	ikm := []byte("input key material")
+	want := make([]byte, 3*hashLen)
+	io.ReadFull(xhkdf.New(sha256.New, ikm, ck, nil), want)
+	out := hkdf(ck, ikm, 3)
+	for i := range out {
+		if !bytes.Equal(out[i][:], want[i*hashLen:][:hashLen]) {
+			t.Errorf("hkdf output %d = %x, want %x", i, out[i], want[i*hashLen:][:hashLen])
+		}
+	}
+}
+
+// counterReader returns bytes 1, 2, 3, ... for deterministic ephemeral keys.
+type counterReader struct{ b byte }
+
+func (r *counterReader) Read(p []byte) (int, error) {
+	for i := range p {
+		r.b++
+		p[i] = r.b
+	}
+	return len(p), nil
+}
+
+func staticKey(t *testing.T, b byte) *ecdh.PrivateKey {
+	t.Helper()
+	k, err := ecdh.X25519().NewPrivateKey(bytes.Repeat([]byte{b}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+// handshake runs a handshake to completion and returns the transcript
+// and the transport CipherStates of both parties.
+func handshake(t *testing.T, init, resp Config) (msgs [][]byte, ic, rc [2]*CipherState, ih, rh *HandshakeState) {
+	t.Helper()
+	var err error
+	if ih, err = NewHandshakeState(init); err != nil {
+		t.Fatal(err)
+	}
+	if rh, err = NewHandshakeState(resp); err != nil {
+		t.Fatal(err)
+	}
+	w, r := ih, rh
+	for i := 0; ; i++ {
+		payload := []byte{'p', byte('0' + i)}
+		msg, c1, c2, err := w.WriteMessage(nil, payload)
+		if err != nil {
+			t.Fatalf("message %d: WriteMessage: %v", i, err)
+		}
+		got, d1, d2, err := r.ReadMessage(nil, msg)
+		if err != nil {
+			t.Fatalf("message %d: ReadMessage: %v", i, err)
+		}
+		if !bytes.Equal(got, payload) {
+			t.Errorf("message %d: payload = %q, want %q", i, got, payload)
+		}
+		msgs = append(msgs, msg)
+		if (c1 == nil) != (d1 == nil) {
+			t.Fatalf("message %d: only one side finished", i)
+		}
+		if c1 != nil {
+			if w == ih {
+				ic, rc = [2]*CipherState{c1, c2}, [2]*CipherState{d1, d2}
+			} else {
+				ic, rc = [2]*CipherState{d1, d2}, [2]*CipherState{c1, c2}
+			}
+			return
+		}
+		w, r = r, w
+	}
+}
+
+func checkTransport(t *testing.T, ic, rc [2]*CipherState) {
+	t.Helper()
+	for i := 0; i < 3; i++ {
+		ct, _ := ic[0].EncryptWithAd(nil, nil, []byte("ping"))
+		if pt, err := rc[0].DecryptWithAd(nil, nil, ct); err != nil || string(pt) != "ping" {
+			t.Errorf("initiator to responder: %q, %v", pt, err)
+		}
+		ct, _ = rc[1].EncryptWithAd(nil, nil, []byte("pong"))
+		if pt, err := ic[1].DecryptWithAd(nil, nil, ct); err != nil || string(pt) != "pong" {
+			t.Errorf("responder to initiator: %q, %v", pt, err)
+		}
+	}
+	// The two directions use different keys.
+	ct, _ := ic[0].EncryptWithAd(nil, nil, []byte("x"))
+	if _, err := ic[1].DecryptWithAd(nil, nil, ct); err == nil {
+		t.Errorf("the two directions share a key")
+	}
+}
+
+func TestNN(t *testing.T) {
+	msgs, ic, rc, ih, rh := handshake(t,
+		Config{Pattern: HandshakeNN, Initiator: true, Prologue: []byte("prologue")},
+		Config{Pattern: HandshakeNN, Prologue: []byte("prologue")})
+	if len(msgs) != 2 || len(msgs[0]) != 32+2 || len(msgs[1]) != 32+2+tagLen {
+		t.Errorf("message sizes = %d, %d", len(msgs[0]), len(msgs[1]))
+	}
+	if !bytes.Equal(ih.HandshakeHash(), rh.HandshakeHash()) {
+		t.Errorf("handshake hashes differ")
+	}
+	checkTransport(t, ic, rc)
+}
+
+func TestXX(t *testing.T) {
+	is, rs := staticKey(t, 1), staticKey(t, 2)
+	msgs, ic, rc, ih, rh := handshake(t,
+		Config{Pattern: HandshakeXX, Initiator: true, StaticKeypair: is},
+		Config{Pattern: HandshakeXX, StaticKeypair: rs})
+	if len(msgs) != 3 {
+		t.Fatalf("XX took %d messages", len(msgs))
+	}
+	if !ih.PeerStatic().Equal(rs.PublicKey()) || !rh.PeerStatic().Equal(is.PublicKey()) {
+		t.Errorf("PeerStatic does not match the static keys")
+	}
+	if !bytes.Equal(ih.HandshakeHash(), rh.HandshakeHash()) {
+		t.Errorf("handshake hashes differ")
+	}
+	checkTransport(t, ic, rc)
+}
+
+// Regression vectors generated with this implementation: the XX handshake
+// with static keys of all 1s and 2s, ephemeral keys from counterReader,
+// prologue "prologue" and payloads "p0", "p1", "p2".
+var xxVector = struct {
+	msgs []string
+	hash string
+}{
+	msgs: []string{
+		"07a37cbc142093c8b755dc1b10e86cb426374ad16aa853ed0bdfc0b2b86d1c7c7030",
+		"5714769d116bf76436ae74bc793d2c30ad1903c59ac5273805c7e2698b410c36" +
+			"9e93f42e3a190ed8494bd1d804fec93d31ea01f45d631ccfd91820239091a652" +
+			"259fd1013996d6982fd672e5aa6081dd36ad18b55e88c62cf80f5576694ca5492ffc",
+		"4cfb4358b9b55503efb49bcac7b54b96958b5d6d84a7ef0ad0a6696ae4bb25b8" +
+			"6ea14de38e4cdea23e031423ad92005262434832ed9c7e8d5ad3f96b6dcab3fb7afe",
+	},
+	hash: "9484fbd0c7e053e0d280a390e8b48232c8a8401fc9b9d592b850038988ec7d71",
+}
+
+func TestXXVector(t *testing.T) {
+	msgs, _, _, ih, _ := handshake(t,
+		Config{Pattern: HandshakeXX, Initiator: true, Prologue: []byte("prologue"), StaticKeypair: staticKey(t, 1), Random: &counterReader{}},
+		Config{Pattern: HandshakeXX, Prologue: []byte("prologue"), StaticKeypair: staticKey(t, 2), Random: &counterReader{b: 100}})
+	for i, m := range msgs {
+		if got := hex.EncodeToString(m); got != xxVector.msgs[i] {
+			t.Errorf("message %d = %s, want %s", i, got, xxVector.msgs[i])
+		}
+	}
+	if got := hex.EncodeToString(ih.HandshakeHash()); got != xxVector.hash {
+		t.Errorf("handshake hash = %s, want %s", got, xxVector.hash)
+	}
+}
+
+func TestHandshakeFailures(t *testing.T) {
+	is, rs := staticKey(t, 1), staticKey(t, 2)
+	newPair := func(initPrologue string) (*HandshakeState, *HandshakeState) {
+		ih, err := NewHandshakeState(Config{Pattern: HandshakeXX, Initiator: true, StaticKeypair: is, Prologue: []byte(initPrologue)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		rh, err := NewHandshakeState(Config{Pattern: HandshakeXX, StaticKeypair: rs})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ih, rh
+	}
+
+	// A different prologue breaks the second message.
+	ih, rh := newPair("other")
+	m, _, _, _ := ih.WriteMessage(nil, nil)
+	rh.ReadMessage(nil, m)
+	m, _, _, _ = rh.WriteMessage(nil, nil)
+	if _, _, _, err := ih.ReadMessage(nil, m); err == nil {
+		t.Errorf("ReadMessage succeeded with mismatched prologues")
+	}
+
+	// Modifying any byte of the second message is detected.
+	ih, rh = newPair("")
+	m, _, _, _ = ih.WriteMessage(nil, nil)
+	rh.ReadMessage(nil, m)
+	m1, _, _, _ := rh.WriteMessage(nil, []byte("hi"))
+	for i := range m1 {
+		ih, rh = newPair("")
+		ih.random, rh.random = &counterReader{}, &counterReader{b: 100}
+		m, _, _, _ = ih.WriteMessage(nil, nil)
+		rh.ReadMessage(nil, m)
+		m, _, _, _ = rh.WriteMessage(nil, []byte("hi"))
+		m[i] ^= 1
+		if _, _, _, err := ih.ReadMessage(nil, m); err == nil {
+			t.Errorf("ReadMessage succeeded with byte %d of the second message modified", i)
+		}
+	}
+
+	ih, rh = newPair("")
+	if _, _, _, err := ih.ReadMessage(nil, nil); err == nil {
+		t.Errorf("initiator read the first message")
+	}
+	if _, _, _, err := rh.WriteMessage(nil, nil); err == nil {
+		t.Errorf("responder wrote the first message")
+	}
+	if _, _, _, err := rh.ReadMessage(nil, make([]byte, 31)); err == nil {
+		t.Errorf("ReadMessage accepted a short message")
+	}
+
+	if _, err := NewHandshakeState(Config{Pattern: HandshakeXX, Initiator: true}); err == nil {
+		t.Errorf("NewHandshakeState accepted XX without a static key")
+	}
+	if _, err := NewHandshakeState(Config{}); err == nil {
+		t.Errorf("NewHandshakeState accepted an empty pattern")
+	}
+}
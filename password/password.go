@@ -0,0 +1,181 @@
+// Package password encrypts messages under a key derived
+// from a password with Argon2id.
+//
+// A sealed message is
+//
+//	magic "DXPW" || version (1 byte) || KDF (1 byte) ||
+//	time (4 bytes) || memory (4 bytes) || threads (1 byte) ||
+//	salt (16 bytes) || nonce || ciphertext and tag
+//
+// with the salt and nonce chosen at random, and the Deoxys-II key derived
+// from the password and salt with the stored work factors. Everything
+// before the ciphertext is authenticated as additional data, so the work
+// factors cannot be changed without detection, but they are read before
+// the message can be authenticated. Open therefore refuses work factors
+// above DefaultLimits, so that a crafted message cannot make it use
+// unbounded memory and time.
+//
+// This is a separate package so that only programs using it
+// depend on golang.org/x/crypto/argon2.
+package password
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+
+	"github.com/magical/deoxys"
+	"golang.org/x/crypto/argon2"
+)
+
+// Params are the Argon2id work factors
+// used to derive a key from a password.
+type Params struct {
+	Time    uint32 // number of passes over the memory
+	Memory  uint32 // memory size in KiB
+	Threads uint8  // degree of parallelism
+}
+
+// DefaultParams are the work factors used by Seal.
+// They follow the second recommendation of RFC 9106
+// and may be raised in later releases.
+var DefaultParams = Params{Time: 3, Memory: 64 << 10, Threads: 4}
+
+// DefaultLimits are the largest work factors that
+// SealWithParams and Open accept.
+var DefaultLimits = Params{Time: 8, Memory: 256 << 10, Threads: 16}
+
+const (
+	magic   = "DXPW"
+	version = 1
+	kdfID   = 1 // Argon2id
+
+	saltSize   = 16
+	headerSize = len(magic) + 2 + 9 + saltSize + deoxys.NonceSize
+
+	// Limits on the parameters of any message, well above DefaultLimits.
+	// SealWithLimits and OpenWithLimits still reject anything beyond these.
+	maxTime   = 64
+	maxMemory = 4 << 20 // 4 GiB
+)
+
+func (p Params) check() error {
+	if p.Time < 1 || p.Time > maxTime {
+		return errors.New("password: invalid time parameter")
+	}
+	if p.Threads < 1 {
+		return errors.New("password: invalid threads parameter")
+	}
+	if p.Memory < 8*uint32(p.Threads) || p.Memory > maxMemory {
+		return errors.New("password: invalid memory parameter")
+	}
+	return nil
+}
+
+func (p Params) exceeds(max Params) bool {
+	return p.Time > max.Time || p.Memory > max.Memory || p.Threads > max.Threads
+}
+
+// Seal encrypts and authenticates plaintext under a key
+// derived from password with DefaultParams.
+func Seal(password, plaintext []byte) ([]byte, error) {
+	return SealWithParams(password, plaintext, DefaultParams)
+}
+
+// SealWithParams is like Seal but uses the given work factors,
+// which must not exceed DefaultLimits, so that Open accepts the result.
+func SealWithParams(password, plaintext []byte, p Params) ([]byte, error) {
+	return SealWithLimits(password, plaintext, p, DefaultLimits)
+}
+
+// SealWithLimits is like SealWithParams, but the work factors must not
+// exceed max instead. Messages sealed with work factors above
+// DefaultLimits can only be opened with OpenWithLimits.
+func SealWithLimits(password, plaintext []byte, p, max Params) ([]byte, error) {
+	if err := p.check(); err != nil {
+		return nil, err
+	}
+	if p.exceeds(max) {
+		return nil, errors.New("password: work factors exceed the limits")
+	}
+	out := make([]byte, headerSize, headerSize+len(plaintext)+deoxys.TagSize)
+	copy(out, magic)
+	n := len(magic)
+	out[n] = version
+	out[n+1] = kdfID
+	binary.BigEndian.PutUint32(out[n+2:], p.Time)
+	binary.BigEndian.PutUint32(out[n+6:], p.Memory)
+	out[n+10] = p.Threads
+	if _, err := rand.Read(out[n+11:]); err != nil {
+		return nil, err
+	}
+	aead := newAEAD(password, out[n+11:n+11+saltSize], p)
+	return aead.Seal(out, out[headerSize-deoxys.NonceSize:], plaintext, out), nil
+}
+
+// Open decrypts a message sealed by Seal or SealWithParams,
+// using the work factors stored in it.
+// It rejects messages with work factors above DefaultLimits.
+func Open(password, sealed []byte) ([]byte, error) {
+	return OpenWithLimits(password, sealed, DefaultLimits)
+}
+
+// OpenWithLimits is like Open, but rejects messages with
+// any work factor above the corresponding one in max instead.
+func OpenWithLimits(password, sealed []byte, max Params) ([]byte, error) {
+	p, err := ParamsOf(sealed)
+	if err != nil {
+		return nil, err
+	}
+	if p.exceeds(max) {
+		return nil, errors.New("password: work factors exceed the limits")
+	}
+	if len(sealed) < headerSize+deoxys.TagSize {
+		return nil, errors.New("password: message too short")
+	}
+	n := len(magic)
+	aead := newAEAD(password, sealed[n+11:n+11+saltSize], p)
+	header := sealed[:headerSize]
+	return aead.Open(nil, header[headerSize-deoxys.NonceSize:], sealed[headerSize:], header)
+}
+
+// ParamsOf returns the work factors a message was sealed with.
+// The parameters are not authenticated until the message is opened.
+func ParamsOf(sealed []byte) (Params, error) {
+	n := len(magic)
+	if len(sealed) < headerSize || string(sealed[:n]) != magic {
+		return Params{}, errors.New("password: not a password-sealed message")
+	}
+	if sealed[n] != version {
+		return Params{}, errors.New("password: unsupported version")
+	}
+	if sealed[n+1] != kdfID {
+		return Params{}, errors.New("password: unknown KDF")
+	}
+	p := Params{
+		Time:    binary.BigEndian.Uint32(sealed[n+2:]),
+		Memory:  binary.BigEndian.Uint32(sealed[n+6:]),
+		Threads: sealed[n+10],
+	}
+	if err := p.check(); err != nil {
+		return Params{}, err
+	}
+	return p, nil
+}
+
+// NeedsUpgrade reports whether a message was sealed
+// with any work factor below those in p, or cannot be parsed.
+// Applications raising their work factors can open such messages
+// and seal them again with SealWithParams.
+func NeedsUpgrade(sealed []byte, p Params) bool {
+	q, err := ParamsOf(sealed)
+	if err != nil {
+		return true
+	}
+	return q.Time < p.Time || q.Memory < p.Memory || q.Threads < p.Threads
+}
+
+func newAEAD(password, salt []byte, p Params) *deoxys.AEAD {
+	key := argon2.IDKey(password, salt, p.Time, p.Memory, p.Threads, 16)
+	return deoxys.New(key)
+}
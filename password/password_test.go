@@ -0,0 +1,196 @@
+package password
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/magical/deoxys"
+)
+
+// Cheap work factors so the tests run quickly.
+var testParams = Params{Time: 1, Memory: 64, Threads: 1}
+
+func TestSeal(t *testing.T) {
+	password := []byte("correct horse battery staple")
+	msg := []byte("A witty saying means nothing.")
+	sealed, err := SealWithParams(password, msg, testParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sealed) != headerSize+len(msg)+deoxys.TagSize {
+		t.Errorf("sealed message is %d bytes, want %d", len(sealed), headerSize+len(msg)+deoxys.TagSize)
+	}
+	pt, err := Open(password, sealed)
+	if err != nil || !bytes.Equal(pt, msg) {
+		t.Errorf("Open = %q, %v; want %q", pt, err, msg)
+	}
+	if _, err := Open([]byte("wrong password"), sealed); err == nil {
+		t.Errorf("Open with the wrong password succeeded")
+	}
+
+	again, _ := SealWithParams(password, msg, testParams)
+	if bytes.Equal(sealed[:headerSize], again[:headerSize]) {
+		t.Errorf("sealing twice used the same salt and nonce")
+	}
+}
+
+func TestSealDefault(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping default work factors in short mode")
+	}
+	sealed, err := Seal([]byte("password"), []byte("msg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p, err := ParamsOf(sealed); err != nil || p != DefaultParams {
+		t.Errorf("ParamsOf = %+v, %v; want %+v", p, err, DefaultParams)
+	}
+	if pt, err := Open([]byte("password"), sealed); err != nil || string(pt) != "msg" {
+		t.Errorf("Open = %q, %v", pt, err)
+	}
+}
+
+func TestHeaderAuthenticated(t *testing.T) {
+	password := []byte("password")
+	sealed, _ := SealWithParams(password, []byte("msg"), testParams)
+	n := len(magic)
+	// Every header byte is bound to the ciphertext.
+	for i := 0; i < headerSize; i++ {
+		c := append([]byte(nil), sealed...)
+		c[i] ^= 1
+		if i == n+2+3 {
+			c[i] = 2 // time 1 -> 2 is still a valid parameter
+		}
+		if _, err := Open(password, c); err == nil {
+			t.Errorf("Open succeeded with header byte %d modified", i)
+		}
+	}
+	if _, err := Open(password, sealed[:headerSize+deoxys.TagSize-1]); err == nil {
+		t.Errorf("Open of a short message succeeded")
+	}
+}
+
+func TestParams(t *testing.T) {
+	for _, p := range []Params{
+		{},
+		{Time: 0, Memory: 64, Threads: 1},
+		{Time: 1, Memory: 64, Threads: 0},
+		{Time: 1, Memory: 7, Threads: 1},
+		{Time: 1, Memory: 64, Threads: 9},
+		{Time: maxTime + 1, Memory: 64, Threads: 1},
+		{Time: 1, Memory: maxMemory + 1, Threads: 1},
+	} {
+		if _, err := SealWithParams(nil, nil, p); err == nil {
+			t.Errorf("SealWithParams accepted %+v", p)
+		}
+	}
+
+	// A crafted header with huge work factors is rejected before any work is done.
+	sealed, _ := SealWithParams(nil, nil, testParams)
+	binary.BigEndian.PutUint32(sealed[len(magic)+6:], 1<<31)
+	if _, err := Open(nil, sealed); err == nil {
+		t.Errorf("Open accepted 2 TiB of memory")
+	}
+}
+
+func TestOpenWithLimits(t *testing.T) {
+	password := []byte("password")
+	limits := Params{Time: 2, Memory: 128, Threads: 2}
+	for _, tt := range []struct {
+		p  Params
+		ok bool
+	}{
+		{testParams, true},
+		{limits, true},
+		{Params{Time: 3, Memory: 128, Threads: 1}, false},
+		{Params{Time: 1, Memory: 129, Threads: 1}, false},
+		{Params{Time: 1, Memory: 128, Threads: 3}, false},
+	} {
+		sealed, err := SealWithParams(password, []byte("msg"), tt.p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pt, err := OpenWithLimits(password, sealed, limits)
+		if tt.ok && (err != nil || string(pt) != "msg") {
+			t.Errorf("OpenWithLimits(%+v) = %q, %v", tt.p, pt, err)
+		}
+		if !tt.ok && err == nil {
+			t.Errorf("OpenWithLimits accepted %+v above the limits %+v", tt.p, limits)
+		}
+	}
+
+	// The default limits stop a crafted header with 1 GiB of memory
+	// and with the largest number of passes and threads.
+	for _, p := range []Params{
+		{Time: 1, Memory: 1 << 20, Threads: 1},
+		{Time: maxTime, Memory: 64, Threads: 1},
+		{Time: 1, Memory: 8 * 255, Threads: 255},
+	} {
+		sealed, _ := SealWithParams(nil, nil, testParams)
+		n := len(magic)
+		binary.BigEndian.PutUint32(sealed[n+2:], p.Time)
+		binary.BigEndian.PutUint32(sealed[n+6:], p.Memory)
+		sealed[n+10] = p.Threads
+		if _, err := Open(nil, sealed); err == nil {
+			t.Errorf("Open accepted %+v", p)
+		}
+	}
+}
+
+func TestSealWithLimits(t *testing.T) {
+	// More passes than DefaultLimits allows, but cheap enough to test.
+	p := Params{Time: DefaultLimits.Time + 1, Memory: 64, Threads: 1}
+	if _, err := SealWithParams(nil, []byte("msg"), p); err == nil {
+		t.Errorf("SealWithParams accepted %+v, which Open rejects", p)
+	}
+	max := Params{Time: p.Time, Memory: DefaultLimits.Memory, Threads: DefaultLimits.Threads}
+	sealed, err := SealWithLimits(nil, []byte("msg"), p, max)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(nil, sealed); err == nil {
+		t.Errorf("Open accepted %+v", p)
+	}
+	if pt, err := OpenWithLimits(nil, sealed, max); err != nil || string(pt) != "msg" {
+		t.Errorf("OpenWithLimits = %q, %v", pt, err)
+	}
+	if _, err := SealWithLimits(nil, nil, Params{Time: maxTime + 1, Memory: 64, Threads: 1}, Params{Time: 1 << 31, Memory: 1 << 31, Threads: 255}); err == nil {
+		t.Errorf("SealWithLimits accepted more than %d passes", maxTime)
+	}
+}
+
+func TestNeedsUpgrade(t *testing.T) {
+	password := []byte("password")
+	old, _ := SealWithParams(password, []byte("msg"), testParams)
+	p, err := ParamsOf(old)
+	if err != nil || p != testParams {
+		t.Fatalf("ParamsOf = %+v, %v; want %+v", p, err, testParams)
+	}
+
+	stronger := Params{Time: 2, Memory: 128, Threads: 1}
+	if NeedsUpgrade(old, testParams) {
+		t.Errorf("NeedsUpgrade with the same parameters = true")
+	}
+	if !NeedsUpgrade(old, stronger) {
+		t.Errorf("NeedsUpgrade with stronger parameters = false")
+	}
+	if !NeedsUpgrade([]byte("junk"), stronger) {
+		t.Errorf("NeedsUpgrade of junk = false")
+	}
+
+	pt, err := Open(password, old)
+	if err != nil {
+		t.Fatal(err)
+	}
+	upgraded, err := SealWithParams(password, pt, stronger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if NeedsUpgrade(upgraded, stronger) {
+		t.Errorf("NeedsUpgrade after upgrading = true")
+	}
+	if pt, err := Open(password, upgraded); err != nil || string(pt) != "msg" {
+		t.Errorf("Open(upgraded) = %q, %v", pt, err)
+	}
+}
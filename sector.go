@@ -0,0 +1,121 @@
+package deoxys
+
+import "encoding/binary"
+
+// SectorCipher implements length-preserving encryption of storage sectors
+// with Deoxys-BC.
+//
+// Each 16-byte block of a sector is encrypted with the tweak
+//
+//	domain (1 byte) || sector number (8 bytes) || block index (7 bytes)
+//
+// so, unlike XTS, no mask has to be computed per block.
+// A trailing partial block is handled with ciphertext stealing,
+// as in XTS.
+//
+// Like any length-preserving mode, SectorCipher provides no
+// authentication, and an observer can tell which 16-byte blocks
+// of a sector changed between two writes.
+type SectorCipher struct {
+	subkey [numRounds][16]uint8
+}
+
+// NewSectorCipher returns a SectorCipher using the given 16-byte key.
+func NewSectorCipher(key []byte) *SectorCipher {
+	c := new(SectorCipher)
+	expandKey(key, c.subkey[:])
+	return c
+}
+
+// EncryptSector encrypts src, the contents of the given sector, into dst.
+// Dst and src must have the same length, which must be at least 16 bytes.
+// Dst and src may overlap entirely or not at all.
+func (c *SectorCipher) EncryptSector(dst, src []byte, sector uint64) {
+	checkSector(dst, src)
+	var tweak [16]uint8
+	setSector(&tweak, sector)
+
+	n := len(src) / blockSize
+	tail := len(src) % blockSize
+	if tail != 0 {
+		// the last full block takes part in ciphertext stealing
+		n--
+	}
+	for i := 0; i < n; i++ {
+		j := i * blockSize
+		setBlockIndex(&tweak, uint64(i))
+		encryptBlock(c.subkey[:], tweak[:], src[j:j+blockSize], dst[j:j+blockSize])
+	}
+	if tail == 0 {
+		return
+	}
+
+	// C_n is the head of E_n(P_n), and C_{n-1} is the encryption of
+	// P_n padded with the rest of E_n(P_n).
+	j := n * blockSize
+	var cc, pp [16]byte
+	setBlockIndex(&tweak, uint64(n))
+	encryptBlock(c.subkey[:], tweak[:], src[j:j+blockSize], cc[:])
+	copy(pp[:], src[j+blockSize:])
+	copy(pp[tail:], cc[tail:])
+	copy(dst[j+blockSize:], cc[:tail])
+	setBlockIndex(&tweak, uint64(n+1))
+	encryptBlock(c.subkey[:], tweak[:], pp[:], dst[j:j+blockSize])
+}
+
+// DecryptSector decrypts src, the contents of the given sector, into dst.
+// Dst and src must have the same length, which must be at least 16 bytes.
+// Dst and src may overlap entirely or not at all.
+func (c *SectorCipher) DecryptSector(dst, src []byte, sector uint64) {
+	checkSector(dst, src)
+	var tweak [16]uint8
+	setSector(&tweak, sector)
+
+	n := len(src) / blockSize
+	tail := len(src) % blockSize
+	if tail != 0 {
+		n--
+	}
+	for i := 0; i < n; i++ {
+		j := i * blockSize
+		setBlockIndex(&tweak, uint64(i))
+		decryptBlock(c.subkey[:], tweak[:], src[j:j+blockSize], dst[j:j+blockSize])
+	}
+	if tail == 0 {
+		return
+	}
+
+	j := n * blockSize
+	var cc, pp [16]byte
+	setBlockIndex(&tweak, uint64(n+1))
+	decryptBlock(c.subkey[:], tweak[:], src[j:j+blockSize], pp[:])
+	copy(cc[:], src[j+blockSize:])
+	copy(cc[tail:], pp[tail:])
+	copy(dst[j+blockSize:], pp[:tail])
+	setBlockIndex(&tweak, uint64(n))
+	decryptBlock(c.subkey[:], tweak[:], cc[:], dst[j:j+blockSize])
+}
+
+func checkSector(dst, src []byte) {
+	if len(src) < blockSize {
+		panic("deoxys: sector smaller than one block")
+	}
+	if len(dst) != len(src) {
+		panic("deoxys: dst and src have different lengths")
+	}
+}
+
+func setSector(tweak *[16]uint8, sector uint64) {
+	tweak[0] = domainSector
+	binary.BigEndian.PutUint64(tweak[1:9], sector)
+}
+
+func setBlockIndex(tweak *[16]uint8, i uint64) {
+	tweak[9] = uint8(i >> 48)
+	tweak[10] = uint8(i >> 40)
+	tweak[11] = uint8(i >> 32)
+	tweak[12] = uint8(i >> 24)
+	tweak[13] = uint8(i >> 16)
+	tweak[14] = uint8(i >> 8)
+	tweak[15] = uint8(i)
+}
@@ -0,0 +1,199 @@
+package deoxys
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"testing"
+)
+
+func TestSectorCipher(t *testing.T) {
+	// Ciphertexts of seq(n) in sector 7 under the key seq(16), recorded
+	// from this package so that existing disk images stay readable.
+	// TestSectorReference checks the mode itself.
+	tests := []struct {
+		n          int
+		ciphertext string
+	}{
+		{16, "5c596549d3b7460e4a16fa3966225ed2"},
+		{20, "192d4adf533e3d1065345bf8728492075c596549"},
+		{32, "5c596549d3b7460e4a16fa3966225ed2af38607a088fc1b611e3e80c96661af2"},
+	}
+	c := NewSectorCipher(seq(16))
+	for _, tt := range tests {
+		dst := make([]byte, tt.n)
+		c.EncryptSector(dst, seq(tt.n), 7)
+		if got := hex.EncodeToString(dst); got != tt.ciphertext {
+			t.Errorf("EncryptSector(seq(%d)) = %s, want %s", tt.n, got, tt.ciphertext)
+		}
+	}
+}
+
+func TestSectorRoundTrip(t *testing.T) {
+	c := NewSectorCipher([]byte("16-byte password"))
+	for _, n := range []int{16, 17, 31, 32, 33, 512, 513, 4096, 4111} {
+		src := make([]byte, n)
+		for i := range src {
+			src[i] = uint8(i * 7)
+		}
+		ct := make([]byte, n)
+		c.EncryptSector(ct, src, 1234)
+		if bytes.Equal(ct, src) {
+			t.Errorf("%d bytes: EncryptSector did nothing", n)
+		}
+		pt := make([]byte, n)
+		c.DecryptSector(pt, ct, 1234)
+		if !bytes.Equal(pt, src) {
+			t.Errorf("%d bytes: DecryptSector(EncryptSector(x)) != x", n)
+		}
+
+		// in place
+		buf := append([]byte(nil), src...)
+		c.EncryptSector(buf, buf, 1234)
+		if !bytes.Equal(buf, ct) {
+			t.Errorf("%d bytes: in-place EncryptSector differs", n)
+		}
+		c.DecryptSector(buf, buf, 1234)
+		if !bytes.Equal(buf, src) {
+			t.Errorf("%d bytes: in-place DecryptSector differs", n)
+		}
+
+		// a different sector number gives a different ciphertext
+		other := make([]byte, n)
+		c.EncryptSector(other, src, 1235)
+		if bytes.Equal(other[:16], ct[:16]) {
+			t.Errorf("%d bytes: sectors 1234 and 1235 encrypt alike", n)
+		}
+	}
+}
+
+// xtsBlock is a block function as in IEEE 1619: it encrypts or
+// decrypts the 16-byte block in with the block index j as its tweak.
+type xtsBlock func(j uint64, in, out []byte)
+
+// ctsEncrypt follows the XTS-AES encryption procedure of IEEE 1619,
+// section 5.3.2, with the given block function in place of XTS-AES-blockEnc.
+func ctsEncrypt(enc xtsBlock, p []byte) []byte {
+	c := make([]byte, len(p))
+	m := len(p) / 16
+	b := len(p) % 16
+	if b == 0 {
+		for q := 0; q < m; q++ {
+			enc(uint64(q), p[16*q:16*q+16], c[16*q:16*q+16])
+		}
+		return c
+	}
+	for q := 0; q < m-1; q++ {
+		enc(uint64(q), p[16*q:16*q+16], c[16*q:16*q+16])
+	}
+	cc := make([]byte, 16)
+	enc(uint64(m-1), p[16*(m-1):16*m], cc)
+	copy(c[16*m:], cc[:b])
+	pp := append(append([]byte(nil), p[16*m:]...), cc[b:]...)
+	enc(uint64(m), pp, c[16*(m-1):16*m])
+	return c
+}
+
+// ctsDecrypt follows the XTS-AES decryption procedure of IEEE 1619,
+// section 5.4.2, with the given block function in place of XTS-AES-blockDec.
+func ctsDecrypt(dec xtsBlock, c []byte) []byte {
+	p := make([]byte, len(c))
+	m := len(c) / 16
+	b := len(c) % 16
+	if b == 0 {
+		for q := 0; q < m; q++ {
+			dec(uint64(q), c[16*q:16*q+16], p[16*q:16*q+16])
+		}
+		return p
+	}
+	for q := 0; q < m-1; q++ {
+		dec(uint64(q), c[16*q:16*q+16], p[16*q:16*q+16])
+	}
+	pp := make([]byte, 16)
+	dec(uint64(m), c[16*(m-1):16*m], pp)
+	copy(p[16*m:], pp[:b])
+	cc := append(append([]byte(nil), c[16*m:]...), pp[b:]...)
+	dec(uint64(m-1), cc, p[16*(m-1):16*m])
+	return p
+}
+
+func TestSectorReference(t *testing.T) {
+	// SectorCipher is IEEE 1619 ciphertext stealing with Deoxys-BC,
+	// under the tweak domain || sector || j, as the block function.
+	key := []byte("16-byte password")
+	const sector = 0x0102030405060708
+	subkey := make([][16]byte, numRounds)
+	expandKey(key, subkey)
+	tweak := func(j uint64) []byte {
+		t := make([]byte, 16)
+		t[0] = domainSector
+		binary.BigEndian.PutUint64(t[1:9], sector)
+		for k := 15; k >= 9; k-- {
+			t[k] = uint8(j)
+			j >>= 8
+		}
+		return t
+	}
+	enc := func(j uint64, in, out []byte) { encryptBlock(subkey, tweak(j), in, out) }
+	dec := func(j uint64, in, out []byte) { decryptBlock(subkey, tweak(j), in, out) }
+
+	c := NewSectorCipher(key)
+	for _, n := range []int{16, 17, 31, 32, 33, 47, 100, 4096, 4111} {
+		src := make([]byte, n)
+		for i := range src {
+			src[i] = uint8(i*13 + 5)
+		}
+		want := ctsEncrypt(enc, src)
+		got := make([]byte, n)
+		c.EncryptSector(got, src, sector)
+		if !bytes.Equal(got, want) {
+			t.Errorf("%d bytes: EncryptSector = %x, want %x", n, got, want)
+		}
+		if pt := ctsDecrypt(dec, want); !bytes.Equal(pt, src) {
+			t.Errorf("%d bytes: reference decryption does not invert encryption", n)
+		}
+		c.DecryptSector(got, want, sector)
+		if !bytes.Equal(got, src) {
+			t.Errorf("%d bytes: DecryptSector = %x, want %x", n, got, src)
+		}
+	}
+}
+
+func TestSectorCiphertextStealing(t *testing.T) {
+	// All blocks but the last full one are unaffected by a partial tail.
+	c := NewSectorCipher([]byte("16-byte password"))
+	src := seq(40)
+	full := make([]byte, 32)
+	c.EncryptSector(full, src[:32], 0)
+	stolen := make([]byte, 40)
+	c.EncryptSector(stolen, src, 0)
+	if !bytes.Equal(stolen[:16], full[:16]) {
+		t.Errorf("first block changed: got %x, want %x", stolen[:16], full[:16])
+	}
+	if !bytes.Equal(stolen[32:], full[16:24]) {
+		t.Errorf("stolen tail: got %x, want %x", stolen[32:], full[16:24])
+	}
+}
+
+func TestSectorPanics(t *testing.T) {
+	c := NewSectorCipher([]byte("16-byte password"))
+	for _, tt := range []struct{ dst, src int }{{15, 15}, {0, 0}, {16, 32}} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("EncryptSector(%d, %d bytes) did not panic", tt.dst, tt.src)
+				}
+			}()
+			c.EncryptSector(make([]byte, tt.dst), make([]byte, tt.src), 0)
+		}()
+	}
+}
+
+func BenchmarkSector4K(b *testing.B) {
+	c := NewSectorCipher([]byte("16-byte password"))
+	buf := make([]byte, 4096)
+	b.SetBytes(int64(len(buf)))
+	for i := 0; i < b.N; i++ {
+		c.EncryptSector(buf, buf, uint64(i))
+	}
+}
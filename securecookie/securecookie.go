@@ -0,0 +1,188 @@
+// Package securecookie encodes values into encrypted, authenticated
+// tokens for use in HTTP cookies.
+//
+// A token is the unpadded base64url encoding of
+//
+//	version (1 byte) || nonce (15 bytes) || ciphertext
+//
+// where the ciphertext is the Deoxys-II encryption of
+//
+//	expiry (8 bytes, big-endian Unix seconds) || value
+//
+// with the version byte and the cookie name as additional data, so a
+// token issued for one cookie is rejected under any other name.
+// Both the value and the expiry are confidential.
+//
+// A Codec holds a list of keys. Tokens are always sealed with the first
+// key, and opened with whichever key works, so keys can be rotated by
+// adding a new key at the front of the list and removing the old key
+// once the tokens sealed with it have expired. The keys given to New are
+// not used directly: each is passed through the KDF first, so a key
+// shared with another part of an application is still separated from it.
+package securecookie
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/magical/deoxys"
+)
+
+const (
+	version    = 1
+	expirySize = 8
+
+	// DefaultMaxAge is the MaxAge of the Codec returned by New.
+	DefaultMaxAge = 30 * 24 * time.Hour
+
+	// MaxLength is the maximum length of an encoded token, the
+	// smallest cookie size that browsers are required to support.
+	MaxLength = 4096
+)
+
+var (
+	keyLabel = []byte("deoxys securecookie v1")
+	b64      = base64.RawURLEncoding.Strict()
+
+	// ErrInvalid is returned for tokens that are malformed,
+	// were sealed with an unknown key, or were issued for another name.
+	ErrInvalid = errors.New("securecookie: invalid token")
+
+	// ErrExpired is returned for tokens that are past their expiry.
+	ErrExpired = errors.New("securecookie: token has expired")
+
+	// ErrTooLong is returned when a token would exceed MaxLength.
+	ErrTooLong = errors.New("securecookie: encoded value is too long")
+)
+
+// A Codec encodes and decodes tokens.
+// It is safe for concurrent use, as long as its fields are not modified.
+type Codec struct {
+	// MaxAge is the lifetime of new tokens. Tokens with an expiry
+	// more than MaxAge in the future are also rejected, so reducing
+	// MaxAge applies to tokens that have already been issued.
+	MaxAge time.Duration
+
+	keys []*deoxys.AEAD
+	now  func() time.Time
+}
+
+// New returns a Codec with the given 16-byte keys, the first of which
+// is used to seal new tokens, and a MaxAge of DefaultMaxAge.
+func New(keys ...[]byte) (*Codec, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("securecookie: no keys")
+	}
+	c := &Codec{MaxAge: DefaultMaxAge, now: time.Now}
+	for _, key := range keys {
+		if len(key) != 16 {
+			return nil, errors.New("securecookie: wrong size key")
+		}
+		c.keys = append(c.keys, deoxys.New(deoxys.DeriveKey(key, keyLabel, nil, 16)))
+	}
+	return c, nil
+}
+
+func additionalData(name string) []byte {
+	return append([]byte{version}, name...)
+}
+
+// Encode returns a token holding value for the cookie name,
+// which expires after c.MaxAge.
+func (c *Codec) Encode(name string, value []byte) (string, error) {
+	if c.MaxAge <= 0 {
+		return "", errors.New("securecookie: MaxAge must be positive")
+	}
+	n := 1 + deoxys.NonceSize + expirySize + len(value) + deoxys.TagSize
+	if b64.EncodedLen(n) > MaxLength {
+		return "", ErrTooLong
+	}
+	b := make([]byte, 1+deoxys.NonceSize, n)
+	b[0] = version
+	nonce := b[1:]
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	pt := make([]byte, expirySize, expirySize+len(value))
+	expiry := c.now().Add(c.MaxAge)
+	binary.BigEndian.PutUint64(pt, uint64(expiry.Unix()))
+	pt = append(pt, value...)
+	b = c.keys[0].Seal(b, nonce, pt, additionalData(name))
+	return b64.EncodeToString(b), nil
+}
+
+// Decode returns the value held by a token for the cookie name.
+// It returns ErrExpired if the token has expired,
+// and ErrInvalid for any other failure.
+func (c *Codec) Decode(name, token string) ([]byte, error) {
+	if len(token) > MaxLength {
+		return nil, ErrInvalid
+	}
+	b, err := b64.DecodeString(token)
+	if err != nil || len(b) < 1+deoxys.NonceSize+expirySize+deoxys.TagSize || b[0] != version {
+		return nil, ErrInvalid
+	}
+	nonce, ct := b[1:1+deoxys.NonceSize], b[1+deoxys.NonceSize:]
+	ad := additionalData(name)
+	var pt []byte
+	for _, aead := range c.keys {
+		if pt, err = aead.Open(nil, nonce, ct, ad); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, ErrInvalid
+	}
+	expiry := time.Unix(int64(binary.BigEndian.Uint64(pt)), 0)
+	now := c.now()
+	if !now.Before(expiry) || expiry.Sub(now) > c.MaxAge {
+		return nil, ErrExpired
+	}
+	return pt[expirySize:], nil
+}
+
+// Cookie returns a cookie named name holding the encoded value.
+// The cookie has Path "/", is marked Secure and HttpOnly, uses
+// SameSite=Lax, and expires with the token; callers may adjust these
+// attributes before setting it.
+func (c *Codec) Cookie(name string, value []byte) (*http.Cookie, error) {
+	token, err := c.Encode(name, value)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Cookie{
+		Name:     name,
+		Value:    token,
+		Path:     "/",
+		MaxAge:   int(c.MaxAge / time.Second),
+		Expires:  c.now().Add(c.MaxAge),
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}, nil
+}
+
+// SetCookie adds a Set-Cookie header holding value to w,
+// with the attributes described at Cookie.
+func (c *Codec) SetCookie(w http.ResponseWriter, name string, value []byte) error {
+	cookie, err := c.Cookie(name, value)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, cookie)
+	return nil
+}
+
+// ReadCookie returns the value of the cookie named name in r.
+// It returns http.ErrNoCookie if r has no such cookie.
+func (c *Codec) ReadCookie(r *http.Request, name string) ([]byte, error) {
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return nil, err
+	}
+	return c.Decode(name, cookie.Value)
+}
@@ -0,0 +1,187 @@
+package securecookie
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var (
+	testKey  = []byte("0123456789abcdef")
+	otherKey = []byte("fedcba9876543210")
+	testTime = time.Date(2024, 3, 14, 15, 9, 26, 0, time.UTC)
+)
+
+func newCodec(t *testing.T, keys ...[]byte) *Codec {
+	t.Helper()
+	c, err := New(keys...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.now = func() time.Time { return testTime }
+	return c
+}
+
+func TestRoundTrip(t *testing.T) {
+	c := newCodec(t, testKey)
+	for _, value := range []string{"", "user=42", strings.Repeat("x", 1000)} {
+		token, err := c.Encode("session", []byte(value))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.ContainsAny(token, "+/=;, ") {
+			t.Errorf("token %q is not URL and cookie safe", token)
+		}
+		got, err := c.Decode("session", token)
+		if err != nil || string(got) != value {
+			t.Errorf("Decode = %q, %v; want %q", got, err, value)
+		}
+	}
+}
+
+func TestName(t *testing.T) {
+	c := newCodec(t, testKey)
+	token, _ := c.Encode("session", []byte("user=42"))
+	if _, err := c.Decode("csrf", token); err != ErrInvalid {
+		t.Errorf("Decode with another name = %v, want ErrInvalid", err)
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	c := newCodec(t, testKey)
+	c.MaxAge = time.Hour
+	token, _ := c.Encode("session", []byte("user=42"))
+
+	for _, tt := range []struct {
+		now    time.Duration
+		maxAge time.Duration
+		err    error
+	}{
+		{59 * time.Minute, time.Hour, nil},
+		{time.Hour, time.Hour, ErrExpired},
+		{-time.Minute, time.Hour, ErrExpired}, // expiry is too far in the future
+		{0, 30 * time.Minute, ErrExpired},     // MaxAge was reduced
+		{45 * time.Minute, 30 * time.Minute, nil},
+	} {
+		c.now = func() time.Time { return testTime.Add(tt.now) }
+		c.MaxAge = tt.maxAge
+		if _, err := c.Decode("session", token); err != tt.err {
+			t.Errorf("Decode at %v with MaxAge %v = %v, want %v", tt.now, tt.maxAge, err, tt.err)
+		}
+	}
+}
+
+func TestRotation(t *testing.T) {
+	old := newCodec(t, otherKey)
+	token, _ := old.Encode("session", []byte("old"))
+
+	c := newCodec(t, testKey, otherKey)
+	if got, err := c.Decode("session", token); err != nil || string(got) != "old" {
+		t.Errorf("Decode with a rotated key = %q, %v", got, err)
+	}
+	token, _ = c.Encode("session", []byte("new"))
+	if _, err := old.Decode("session", token); err != ErrInvalid {
+		t.Errorf("new tokens are not sealed with the first key")
+	}
+
+	retired := newCodec(t, testKey)
+	if _, err := retired.Decode("session", token); err != nil {
+		t.Errorf("Decode with the current key: %v", err)
+	}
+}
+
+func TestConcurrent(t *testing.T) {
+	// Decode with a rotated key too, so both keys are in use.
+	c := newCodec(t, testKey, otherKey)
+	old := newCodec(t, otherKey)
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				value := fmt.Sprintf("goroutine %d, value %d", g, i)
+				enc := c
+				if i%2 == 1 {
+					enc = old
+				}
+				token, err := enc.Encode("session", []byte(value))
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if got, err := c.Decode("session", token); err != nil || string(got) != value {
+					t.Errorf("Decode = %q, %v; want %q", got, err, value)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+}
+
+func TestInvalid(t *testing.T) {
+	c := newCodec(t, testKey)
+	token, _ := c.Encode("session", []byte("user=42"))
+	b, _ := b64.DecodeString(token)
+	for i := range b {
+		b[i] ^= 1
+		if _, err := c.Decode("session", b64.EncodeToString(b)); err != ErrInvalid {
+			t.Errorf("byte %d modified: Decode = %v, want ErrInvalid", i, err)
+		}
+		b[i] ^= 1
+	}
+	for _, bad := range []string{"", "a", token[:len(token)-1], token + "A", token + "=", strings.Repeat("A", MaxLength+4)} {
+		if _, err := c.Decode("session", bad); err != ErrInvalid {
+			t.Errorf("Decode(%q) = %v, want ErrInvalid", bad, err)
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	if _, err := New(); err == nil {
+		t.Errorf("New accepted no keys")
+	}
+	if _, err := New(testKey, testKey[:15]); err == nil {
+		t.Errorf("New accepted a short key")
+	}
+	c := newCodec(t, testKey)
+	if _, err := c.Encode("session", make([]byte, MaxLength)); err != ErrTooLong {
+		t.Errorf("Encode of a long value = %v, want ErrTooLong", err)
+	}
+	c.MaxAge = 0
+	if _, err := c.Encode("session", nil); err == nil {
+		t.Errorf("Encode accepted a zero MaxAge")
+	}
+}
+
+func TestHTTP(t *testing.T) {
+	c := newCodec(t, testKey)
+	w := httptest.NewRecorder()
+	if err := c.SetCookie(w, "session", []byte("user=42")); err != nil {
+		t.Fatal(err)
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("got %d cookies, want 1", len(cookies))
+	}
+	set := cookies[0]
+	if set.Name != "session" || !set.Secure || !set.HttpOnly || set.Path != "/" || set.MaxAge != int(DefaultMaxAge/time.Second) {
+		t.Errorf("unexpected cookie attributes: %v", set)
+	}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	if _, err := c.ReadCookie(r, "session"); err != http.ErrNoCookie {
+		t.Errorf("ReadCookie without a cookie = %v, want http.ErrNoCookie", err)
+	}
+	r.AddCookie(&http.Cookie{Name: "session", Value: set.Value})
+	got, err := c.ReadCookie(r, "session")
+	if err != nil || !bytes.Equal(got, []byte("user=42")) {
+		t.Errorf("ReadCookie = %q, %v", got, err)
+	}
+}
@@ -0,0 +1,257 @@
+// Package sqlcrypt provides database/sql types that encrypt column values.
+//
+// EncryptedString and EncryptedBytes implement driver.Valuer and
+// sql.Scanner, so they can be passed as query arguments and scanned
+// into like any other value. Each holds a pointer to the Column it is
+// stored in. The column's table and name are authenticated as additional
+// data, so a value copied into another column, or another table,
+// fails to decrypt.
+//
+// Values are stored as binary strings of the form
+//
+//	version (1 byte) || mode (1 byte) || key ID (4 bytes, big-endian) || nonce (15 bytes) || ciphertext
+//
+// The header before the nonce is authenticated along with the column.
+// Keys come from a Keyring, by default the process-wide DefaultKeyring:
+// values are sealed with the primary key and opened with the key named
+// by their key ID, so keys can be rotated without rewriting every row.
+//
+// In deterministic mode the nonce is all zeros. Because Deoxys-II is
+// misuse resistant, this still gives authenticated encryption, but equal
+// values in the same column encrypt to equal ciphertexts, which allows
+// equality lookups and unique indexes on the encrypted column. The
+// trade-off is that anyone who can read the column learns which rows
+// hold equal values. A lookup only finds rows sealed with the current
+// primary key, so rows must be re-encrypted after a rotation.
+package sqlcrypt
+
+import (
+	"bytes"
+	"crypto/rand"
+	"database/sql/driver"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/magical/deoxys"
+)
+
+const (
+	version = 1
+
+	modeRandom        = 0
+	modeDeterministic = 1
+
+	headerSize = 1 + 1 + 4
+)
+
+var zeroNonce [deoxys.NonceSize]byte
+
+// A Keyring holds the keys used to encrypt columns, by ID.
+// It is safe for concurrent use.
+type Keyring struct {
+	mu         sync.RWMutex
+	keys       map[uint32]*deoxys.AEAD
+	primary    uint32
+	hasPrimary bool
+}
+
+// NewKeyring returns an empty Keyring.
+func NewKeyring() *Keyring {
+	return &Keyring{keys: make(map[uint32]*deoxys.AEAD)}
+}
+
+// DefaultKeyring is the Keyring used by columns that don't specify one.
+var DefaultKeyring = NewKeyring()
+
+// Add adds a 16-byte key with the given ID.
+// The first key added becomes the primary key.
+func (r *Keyring) Add(id uint32, key []byte) error {
+	if len(key) != 16 {
+		return errors.New("sqlcrypt: wrong size key")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.keys[id]; ok {
+		return fmt.Errorf("sqlcrypt: duplicate key ID %d", id)
+	}
+	r.keys[id] = deoxys.New(key)
+	if !r.hasPrimary {
+		r.primary, r.hasPrimary = id, true
+	}
+	return nil
+}
+
+// SetPrimary makes the key with the given ID the one used to seal new values.
+func (r *Keyring) SetPrimary(id uint32) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.keys[id]; !ok {
+		return fmt.Errorf("sqlcrypt: unknown key ID %d", id)
+	}
+	r.primary, r.hasPrimary = id, true
+	return nil
+}
+
+func (r *Keyring) primaryKey() (uint32, *deoxys.AEAD, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if !r.hasPrimary {
+		return 0, nil, errors.New("sqlcrypt: keyring is empty")
+	}
+	return r.primary, r.keys[r.primary], nil
+}
+
+func (r *Keyring) key(id uint32) (*deoxys.AEAD, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	aead, ok := r.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("sqlcrypt: unknown key ID %d", id)
+	}
+	return aead, nil
+}
+
+// A Column describes where encrypted values are stored.
+// A nil *Column is like a Column with an empty table and name.
+type Column struct {
+	Table string
+	Name  string
+
+	// Deterministic selects deterministic encryption for new values.
+	// Values in either mode can be read regardless of this setting.
+	Deterministic bool
+
+	// Keyring holds the keys. If nil, DefaultKeyring is used.
+	Keyring *Keyring
+}
+
+func (c *Column) keyring() *Keyring {
+	if c == nil || c.Keyring == nil {
+		return DefaultKeyring
+	}
+	return c.Keyring
+}
+
+// additionalData returns the header followed by the
+// length-prefixed table name and the column name.
+func (c *Column) additionalData(header []byte) []byte {
+	var table, name string
+	if c != nil {
+		table, name = c.Table, c.Name
+	}
+	ad := make([]byte, 0, len(header)+4+len(table)+len(name))
+	ad = append(ad, header...)
+	ad = binary.BigEndian.AppendUint32(ad, uint32(len(table)))
+	ad = append(ad, table...)
+	return append(ad, name...)
+}
+
+func (c *Column) seal(plaintext []byte) ([]byte, error) {
+	id, aead, err := c.keyring().primaryKey()
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, headerSize+deoxys.NonceSize, headerSize+deoxys.NonceSize+len(plaintext)+deoxys.TagSize)
+	b[0] = version
+	b[1] = modeRandom
+	binary.BigEndian.PutUint32(b[2:], id)
+	nonce := b[headerSize:]
+	if c != nil && c.Deterministic {
+		b[1] = modeDeterministic
+	} else if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(b, nonce, plaintext, c.additionalData(b[:headerSize])), nil
+}
+
+func (c *Column) open(data []byte) ([]byte, error) {
+	if len(data) < headerSize+deoxys.NonceSize+deoxys.TagSize {
+		return nil, errors.New("sqlcrypt: encrypted value too short")
+	}
+	if data[0] != version {
+		return nil, errors.New("sqlcrypt: unknown version")
+	}
+	if data[1] != modeRandom && data[1] != modeDeterministic {
+		return nil, errors.New("sqlcrypt: unknown mode")
+	}
+	aead, err := c.keyring().key(binary.BigEndian.Uint32(data[2:]))
+	if err != nil {
+		return nil, err
+	}
+	header, nonce, ct := data[:headerSize], data[headerSize:headerSize+deoxys.NonceSize], data[headerSize+deoxys.NonceSize:]
+	if data[1] == modeDeterministic && !bytes.Equal(nonce, zeroNonce[:]) {
+		return nil, errors.New("sqlcrypt: nonzero nonce in deterministic mode")
+	}
+	// An empty value is not NULL, so don't return a nil slice for it.
+	pt, err := aead.Open([]byte{}, nonce, ct, c.additionalData(header))
+	if err != nil {
+		return nil, errors.New("sqlcrypt: decryption failed")
+	}
+	return pt, nil
+}
+
+// scanBytes returns the raw bytes of a value from the database,
+// or nil for NULL.
+func scanBytes(src interface{}) ([]byte, error) {
+	switch src := src.(type) {
+	case nil:
+		return nil, nil
+	case []byte:
+		return src, nil
+	case string:
+		return []byte(src), nil
+	}
+	return nil, fmt.Errorf("sqlcrypt: cannot scan %T into an encrypted value", src)
+}
+
+// EncryptedString is a string stored encrypted in Column.
+// NULL scans as the empty string.
+type EncryptedString struct {
+	Column *Column
+	String string
+}
+
+// Value implements the driver.Valuer interface.
+func (s EncryptedString) Value() (driver.Value, error) {
+	return s.Column.seal([]byte(s.String))
+}
+
+// Scan implements the sql.Scanner interface.
+func (s *EncryptedString) Scan(src interface{}) error {
+	data, err := scanBytes(src)
+	if err != nil || data == nil {
+		s.String = ""
+		return err
+	}
+	pt, err := s.Column.open(data)
+	s.String = string(pt)
+	return err
+}
+
+// EncryptedBytes is a byte string stored encrypted in Column.
+// A nil Bytes is stored as NULL, and NULL scans as nil.
+type EncryptedBytes struct {
+	Column *Column
+	Bytes  []byte
+}
+
+// Value implements the driver.Valuer interface.
+func (b EncryptedBytes) Value() (driver.Value, error) {
+	if b.Bytes == nil {
+		return nil, nil
+	}
+	return b.Column.seal(b.Bytes)
+}
+
+// Scan implements the sql.Scanner interface.
+func (b *EncryptedBytes) Scan(src interface{}) error {
+	data, err := scanBytes(src)
+	if err != nil || data == nil {
+		b.Bytes = nil
+		return err
+	}
+	b.Bytes, err = b.Column.open(data)
+	return err
+}
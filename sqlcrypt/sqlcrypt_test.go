@@ -0,0 +1,248 @@
+package sqlcrypt
+
+import (
+	"bytes"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+var (
+	testKey  = []byte("0123456789abcdef")
+	otherKey = []byte("fedcba9876543210")
+)
+
+var (
+	_ driver.Valuer = EncryptedString{}
+	_ sql.Scanner   = (*EncryptedString)(nil)
+	_ driver.Valuer = EncryptedBytes{}
+	_ sql.Scanner   = (*EncryptedBytes)(nil)
+)
+
+func newKeyring(t *testing.T) *Keyring {
+	t.Helper()
+	r := NewKeyring()
+	if err := r.Add(1, testKey); err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func value(t *testing.T, v driver.Valuer) []byte {
+	t.Helper()
+	x, err := v.Value()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !driver.IsValue(x) {
+		t.Fatalf("Value returned %T, which is not a driver.Value", x)
+	}
+	b, _ := x.([]byte)
+	return b
+}
+
+func TestRoundTrip(t *testing.T) {
+	col := &Column{Table: "users", Name: "email", Keyring: newKeyring(t)}
+	stored := value(t, EncryptedString{col, "alice@example.com"})
+	if bytes.Contains(stored, []byte("alice")) {
+		t.Errorf("stored value contains the plaintext")
+	}
+	for _, src := range []interface{}{stored, string(stored)} {
+		s := EncryptedString{Column: col}
+		if err := s.Scan(src); err != nil || s.String != "alice@example.com" {
+			t.Errorf("Scan(%T) = %q, %v", src, s.String, err)
+		}
+	}
+
+	stored = value(t, EncryptedBytes{col, []byte{}})
+	b := EncryptedBytes{Column: col}
+	if err := b.Scan(stored); err != nil || b.Bytes == nil || len(b.Bytes) != 0 {
+		t.Errorf("Scan of an empty value = %q, %v", b.Bytes, err)
+	}
+}
+
+func TestNull(t *testing.T) {
+	col := &Column{Keyring: newKeyring(t)}
+	if v, err := (EncryptedBytes{col, nil}).Value(); v != nil || err != nil {
+		t.Errorf("Value of nil bytes = %v, %v; want NULL", v, err)
+	}
+	b := EncryptedBytes{col, []byte("x")}
+	if err := b.Scan(nil); err != nil || b.Bytes != nil {
+		t.Errorf("Scan(nil) = %q, %v", b.Bytes, err)
+	}
+	s := EncryptedString{col, "x"}
+	if err := s.Scan(nil); err != nil || s.String != "" {
+		t.Errorf("Scan(nil) = %q, %v", s.String, err)
+	}
+	if err := s.Scan(42); err == nil {
+		t.Errorf("Scan accepted an integer")
+	}
+}
+
+func TestColumnBinding(t *testing.T) {
+	r := newKeyring(t)
+	email := &Column{Table: "users", Name: "email", Keyring: r}
+	stored := value(t, EncryptedString{email, "alice@example.com"})
+	for _, col := range []*Column{
+		{Table: "users", Name: "phone", Keyring: r},
+		{Table: "admins", Name: "email", Keyring: r},
+		{Table: "usersemail", Name: "", Keyring: r},
+		{Table: "", Name: "usersemail", Keyring: r},
+	} {
+		s := EncryptedString{Column: col}
+		if err := s.Scan(stored); err == nil {
+			t.Errorf("value for users.email decrypted as %s.%s", col.Table, col.Name)
+		}
+	}
+}
+
+func TestDeterministic(t *testing.T) {
+	r := newKeyring(t)
+	col := &Column{Table: "users", Name: "email", Keyring: r}
+	a := value(t, EncryptedString{col, "alice@example.com"})
+	b := value(t, EncryptedString{col, "alice@example.com"})
+	if bytes.Equal(a, b) {
+		t.Errorf("randomized mode produced equal ciphertexts")
+	}
+
+	col.Deterministic = true
+	a = value(t, EncryptedString{col, "alice@example.com"})
+	b = value(t, EncryptedString{col, "alice@example.com"})
+	c := value(t, EncryptedString{col, "bob@example.com"})
+	if !bytes.Equal(a, b) || bytes.Equal(a, c) {
+		t.Errorf("deterministic mode: equal values must give equal ciphertexts, and only they")
+	}
+	other := &Column{Table: "users", Name: "backup_email", Deterministic: true, Keyring: r}
+	if bytes.Equal(a, value(t, EncryptedString{other, "alice@example.com"})) {
+		t.Errorf("deterministic ciphertexts are equal across columns")
+	}
+
+	// Both modes can be read whatever the column's setting.
+	s := EncryptedString{Column: &Column{Table: "users", Name: "email", Keyring: r}}
+	if err := s.Scan(a); err != nil || s.String != "alice@example.com" {
+		t.Errorf("Scan = %q, %v", s.String, err)
+	}
+
+	// A deterministic value must use the zero nonce.
+	a[headerSize] ^= 1
+	if err := s.Scan(a); err == nil {
+		t.Errorf("Scan accepted a deterministic value with a nonzero nonce")
+	}
+}
+
+func TestRotation(t *testing.T) {
+	r := newKeyring(t)
+	col := &Column{Table: "t", Name: "c", Keyring: r}
+	old := value(t, EncryptedBytes{col, []byte("old")})
+
+	if err := r.Add(2, otherKey); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.SetPrimary(2); err != nil {
+		t.Fatal(err)
+	}
+	current := value(t, EncryptedBytes{col, []byte("new")})
+	if id := current[2:headerSize]; !bytes.Equal(id, []byte{0, 0, 0, 2}) {
+		t.Errorf("new value sealed with key ID %x, want 2", id)
+	}
+	for stored, want := range map[string]string{string(old): "old", string(current): "new"} {
+		b := EncryptedBytes{Column: col}
+		if err := b.Scan([]byte(stored)); err != nil || string(b.Bytes) != want {
+			t.Errorf("Scan = %q, %v; want %q", b.Bytes, err, want)
+		}
+	}
+
+	// A keyring without key 1 can't read old values.
+	r2 := NewKeyring()
+	r2.Add(2, otherKey)
+	b := EncryptedBytes{Column: &Column{Table: "t", Name: "c", Keyring: r2}}
+	if err := b.Scan(old); err == nil {
+		t.Errorf("Scan succeeded with a missing key")
+	}
+}
+
+func TestConcurrent(t *testing.T) {
+	// database/sql calls Value and Scan from many goroutines at once.
+	keys := newKeyring(t)
+	keys.Add(2, otherKey)
+	random := &Column{Table: "users", Name: "email", Keyring: keys}
+	deterministic := &Column{Table: "users", Name: "login", Deterministic: true, Keyring: keys}
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				col := random
+				if i%2 == 1 {
+					col = deterministic
+				}
+				want := fmt.Sprintf("goroutine %d, value %d", g, i)
+				stored, err := EncryptedString{col, want}.Value()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				s := EncryptedString{Column: col}
+				if err := s.Scan(stored); err != nil || s.String != want {
+					t.Errorf("Scan = %q, %v; want %q", s.String, err, want)
+					return
+				}
+			}
+		}(g)
+	}
+	// Rotate keys while values are being sealed and opened.
+	for i := 0; i < 100; i++ {
+		keys.SetPrimary(uint32(1 + i%2))
+	}
+	wg.Wait()
+}
+
+func TestTamper(t *testing.T) {
+	col := &Column{Table: "t", Name: "c", Keyring: newKeyring(t)}
+	stored := value(t, EncryptedBytes{col, []byte("secret")})
+	for i := range stored {
+		stored[i] ^= 1
+		b := EncryptedBytes{Column: col}
+		if err := b.Scan(stored); err == nil {
+			t.Errorf("byte %d modified: Scan succeeded", i)
+		}
+		stored[i] ^= 1
+	}
+	b := EncryptedBytes{Column: col}
+	if err := b.Scan(stored[:len(stored)-1]); err == nil {
+		t.Errorf("Scan accepted a truncated value")
+	}
+}
+
+func TestKeyring(t *testing.T) {
+	r := NewKeyring()
+	col := &Column{Keyring: r}
+	if _, err := (EncryptedString{col, "x"}).Value(); err == nil {
+		t.Errorf("Value succeeded with an empty keyring")
+	}
+	if err := r.Add(1, testKey[:15]); err == nil {
+		t.Errorf("Add accepted a short key")
+	}
+	if err := r.SetPrimary(1); err == nil {
+		t.Errorf("SetPrimary accepted an unknown key ID")
+	}
+	r.Add(1, testKey)
+	if err := r.Add(1, otherKey); err == nil {
+		t.Errorf("Add accepted a duplicate key ID")
+	}
+}
+
+func TestDefaultKeyring(t *testing.T) {
+	saved := DefaultKeyring
+	defer func() { DefaultKeyring = saved }()
+	DefaultKeyring = newKeyring(t)
+
+	stored := value(t, EncryptedString{nil, "hello"})
+	s := EncryptedString{Column: &Column{}}
+	if err := s.Scan(stored); err != nil || s.String != "hello" {
+		t.Errorf("Scan = %q, %v", s.String, err)
+	}
+}
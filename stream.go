@@ -0,0 +1,192 @@
+package deoxys
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// A stream is split into chunks of StreamChunkSize bytes of plaintext,
+// each sealed separately. The nonce of chunk i is
+//
+//	prefix (10 bytes) || i (4 bytes, big-endian) || final (1 byte)
+//
+// where final is 1 for the last chunk and 0 otherwise,
+// so a reader detects reordered, dropped and truncated chunks.
+// The last chunk may be empty.
+const (
+	StreamChunkSize              = 64 << 10
+	StreamNoncePrefixSize        = 10
+	maxStreamChunks       uint64 = 1 << 32
+)
+
+// ErrStreamInvalid is returned when a stream fails authentication,
+// including when it has been truncated.
+var ErrStreamInvalid = errors.New("deoxys: stream authentication failed")
+
+// StreamWriter encrypts a stream of data in chunks.
+// Callers must call Close to write the final chunk.
+type StreamWriter struct {
+	w      io.Writer
+	aead   *AEAD
+	nonce  [NonceSize]byte
+	ad     []byte
+	chunk  uint64
+	buf    []byte
+	out    []byte
+	closed bool
+}
+
+// NewStreamWriter returns a StreamWriter that writes chunks
+// sealed with aead to w. Every chunk is authenticated together with ad.
+// The nonce prefix must be StreamNoncePrefixSize bytes long
+// and must never be used twice with the same key; a random prefix
+// is safe for up to about 2^32 streams per key. The aead may be
+// shared with other streams, including ones used concurrently.
+func NewStreamWriter(w io.Writer, aead *AEAD, noncePrefix, ad []byte) (*StreamWriter, error) {
+	if len(noncePrefix) != StreamNoncePrefixSize {
+		return nil, errors.New("NewStreamWriter: wrong size nonce prefix")
+	}
+	s := &StreamWriter{
+		w:    w,
+		aead: aead,
+		ad:   append([]byte(nil), ad...),
+		buf:  make([]byte, 0, StreamChunkSize),
+	}
+	copy(s.nonce[:], noncePrefix)
+	return s, nil
+}
+
+// Write encrypts p. Output is written a chunk at a time,
+// so some data may be held back until a later Write or Close.
+func (s *StreamWriter) Write(p []byte) (int, error) {
+	if s.closed {
+		return 0, errors.New("StreamWriter: write after close")
+	}
+	n := 0
+	for len(p) > 0 {
+		if len(s.buf) == StreamChunkSize {
+			// Only flush a full chunk once we know it is not the last one.
+			if err := s.flush(false); err != nil {
+				return n, err
+			}
+		}
+		k := copy(s.buf[len(s.buf):cap(s.buf)], p)
+		s.buf = s.buf[:len(s.buf)+k]
+		p = p[k:]
+		n += k
+	}
+	return n, nil
+}
+
+// Close writes the final chunk. It does not close the underlying writer.
+func (s *StreamWriter) Close() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	return s.flush(true)
+}
+
+func (s *StreamWriter) flush(final bool) error {
+	if s.chunk == maxStreamChunks {
+		return errors.New("StreamWriter: stream too long")
+	}
+	setStreamNonce(&s.nonce, s.chunk, final)
+	s.out = s.aead.Seal(s.out[:0], s.nonce[:], s.buf, s.ad)
+	s.buf = s.buf[:0]
+	s.chunk++
+	_, err := s.w.Write(s.out)
+	return err
+}
+
+// StreamReader decrypts a stream written by a StreamWriter.
+//
+// It returns plaintext as soon as each chunk has been authenticated,
+// so a caller may see the beginning of a stream before learning
+// that a later part of it is corrupt or missing. Data is only
+// fully authenticated once Read has returned io.EOF.
+type StreamReader struct {
+	r     *bufio.Reader
+	aead  *AEAD
+	nonce [NonceSize]byte
+	ad    []byte
+	chunk uint64
+	in    []byte
+	buf   []byte // decrypted data not yet returned
+	done  bool
+	err   error
+}
+
+// NewStreamReader returns a StreamReader that reads a stream sealed
+// with aead, the given nonce prefix and additional data from r.
+// The aead may be shared with other streams, including ones used concurrently.
+func NewStreamReader(r io.Reader, aead *AEAD, noncePrefix, ad []byte) (*StreamReader, error) {
+	if len(noncePrefix) != StreamNoncePrefixSize {
+		return nil, errors.New("NewStreamReader: wrong size nonce prefix")
+	}
+	s := &StreamReader{
+		r:    bufio.NewReaderSize(r, StreamChunkSize+TagSize+1),
+		aead: aead,
+		ad:   append([]byte(nil), ad...),
+		in:   make([]byte, StreamChunkSize+TagSize),
+	}
+	copy(s.nonce[:], noncePrefix)
+	return s, nil
+}
+
+// Read reads decrypted data into p.
+// It returns ErrStreamInvalid if the stream fails authentication.
+func (s *StreamReader) Read(p []byte) (int, error) {
+	for len(s.buf) == 0 {
+		if s.err != nil {
+			return 0, s.err
+		}
+		if s.done {
+			return 0, io.EOF
+		}
+		s.err = s.next()
+	}
+	n := copy(p, s.buf)
+	s.buf = s.buf[n:]
+	return n, nil
+}
+
+func (s *StreamReader) next() error {
+	n, err := io.ReadFull(s.r, s.in)
+	final := false
+	switch err {
+	case nil:
+		// A full chunk is the last one if nothing follows it.
+		if _, err := s.r.Peek(1); err == io.EOF {
+			final = true
+		} else if err != nil {
+			return err
+		}
+	case io.EOF, io.ErrUnexpectedEOF:
+		final = true
+	default:
+		return err
+	}
+	if s.chunk == maxStreamChunks {
+		return ErrStreamInvalid
+	}
+	setStreamNonce(&s.nonce, s.chunk, final)
+	out, err := s.aead.Open(s.in[:0], s.nonce[:], s.in[:n], s.ad)
+	if err != nil {
+		return ErrStreamInvalid
+	}
+	s.buf = out
+	s.chunk++
+	s.done = final
+	return nil
+}
+
+func setStreamNonce(nonce *[NonceSize]byte, chunk uint64, final bool) {
+	binary.BigEndian.PutUint32(nonce[StreamNoncePrefixSize:], uint32(chunk))
+	nonce[NonceSize-1] = 0
+	if final {
+		nonce[NonceSize-1] = 1
+	}
+}
@@ -0,0 +1,110 @@
+package deoxys
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func sealStream(t *testing.T, m *AEAD, prefix, ad, msg []byte, writeSize int) []byte {
+	var buf bytes.Buffer
+	w, err := NewStreamWriter(&buf, m, prefix, ad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for p := msg; len(p) > 0; {
+		k := writeSize
+		if k > len(p) {
+			k = len(p)
+		}
+		if _, err := w.Write(p[:k]); err != nil {
+			t.Fatal(err)
+		}
+		p = p[k:]
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func openStream(m *AEAD, prefix, ad, ct []byte) ([]byte, error) {
+	r, err := NewStreamReader(bytes.NewReader(ct), m, prefix, ad)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func TestStreamRoundTrip(t *testing.T) {
+	m := New([]byte("16-byte password"))
+	prefix := seq(StreamNoncePrefixSize)
+	ad := []byte("header")
+	for _, n := range []int{0, 1, 100, StreamChunkSize - 1, StreamChunkSize, StreamChunkSize + 1, 3*StreamChunkSize + 17} {
+		msg := make([]byte, n)
+		for i := range msg {
+			msg[i] = uint8(i)
+		}
+		ct := sealStream(t, m, prefix, ad, msg, 1000)
+		chunks := n/StreamChunkSize + 1
+		if n > 0 && n%StreamChunkSize == 0 {
+			chunks--
+		}
+		if want := n + chunks*TagSize; len(ct) != want {
+			t.Errorf("%d bytes: stream is %d bytes long, want %d", n, len(ct), want)
+		}
+		if ct2 := sealStream(t, m, prefix, ad, msg, StreamChunkSize+5); !bytes.Equal(ct, ct2) {
+			t.Errorf("%d bytes: output depends on the size of writes", n)
+		}
+		pt, err := openStream(m, prefix, ad, ct)
+		if err != nil {
+			t.Errorf("%d bytes: %v", n, err)
+		}
+		if !bytes.Equal(pt, msg) {
+			t.Errorf("%d bytes: decrypted stream differs", n)
+		}
+	}
+}
+
+func TestStreamTampering(t *testing.T) {
+	m := New([]byte("16-byte password"))
+	prefix := seq(StreamNoncePrefixSize)
+	msg := make([]byte, 2*StreamChunkSize+100)
+	ct := sealStream(t, m, prefix, nil, msg, len(msg))
+	full := StreamChunkSize + TagSize
+
+	tests := []struct {
+		name string
+		ct   []byte
+	}{
+		{"empty", nil},
+		{"truncated at chunk boundary", ct[:2*full]},
+		{"truncated mid-chunk", ct[:full+10]},
+		{"last chunk dropped", append(append([]byte(nil), ct[:full]...), ct[2*full:]...)},
+		{"chunks swapped", append(append(append([]byte(nil), ct[full:2*full]...), ct[:full]...), ct[2*full:]...)},
+		{"bit flipped", func() []byte { c := append([]byte(nil), ct...); c[5] ^= 1; return c }()},
+		{"extended", append(append([]byte(nil), ct...), 0)},
+	}
+	for _, tt := range tests {
+		if _, err := openStream(m, prefix, nil, tt.ct); err != ErrStreamInvalid {
+			t.Errorf("%s: got error %v, want ErrStreamInvalid", tt.name, err)
+		}
+	}
+
+	if _, err := openStream(m, ones(StreamNoncePrefixSize), nil, ct); err != ErrStreamInvalid {
+		t.Errorf("wrong nonce prefix: got error %v, want ErrStreamInvalid", err)
+	}
+	if _, err := openStream(m, prefix, []byte("ad"), ct); err != ErrStreamInvalid {
+		t.Errorf("wrong additional data: got error %v, want ErrStreamInvalid", err)
+	}
+}
+
+func TestStreamPrefixSize(t *testing.T) {
+	m := New([]byte("16-byte password"))
+	if _, err := NewStreamWriter(io.Discard, m, make([]byte, 9), nil); err == nil {
+		t.Errorf("NewStreamWriter accepted a short nonce prefix")
+	}
+	if _, err := NewStreamReader(bytes.NewReader(nil), m, make([]byte, 11), nil); err == nil {
+		t.Errorf("NewStreamReader accepted a long nonce prefix")
+	}
+}
@@ -0,0 +1,134 @@
+// Package token implements short-lived, opaque, encrypted tokens
+// in the style of Fernet and Branca.
+//
+// A token is the base62 encoding of
+//
+//	version (1 byte, 0xD2) || timestamp (8 bytes) || nonce (15 bytes) || ciphertext
+//
+// where the timestamp is the time of issue in big-endian Unix seconds
+// and the ciphertext is the Deoxys-II encryption of the payload with
+// a random nonce. The version, timestamp and nonce make up the header,
+// which is authenticated as additional data. The timestamp is not
+// encrypted, like in Fernet and Branca.
+//
+// The base62 alphabet is 0-9, a-z, A-Z, as used by math/big,
+// so tokens consist only of letters and digits.
+package token
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"math/big"
+	"time"
+
+	"github.com/magical/deoxys"
+)
+
+const (
+	version    = 0xD2
+	headerSize = 1 + 8 + deoxys.NonceSize
+
+	// MaxClockSkew is how far in the future a token's
+	// timestamp may be before Decode rejects it.
+	MaxClockSkew = time.Minute
+)
+
+var (
+	// ErrInvalid is returned for tokens that are malformed
+	// or fail authentication.
+	ErrInvalid = errors.New("token: invalid token")
+
+	// ErrExpired is returned for tokens older than the TTL
+	// or issued too far in the future.
+	ErrExpired = errors.New("token: token has expired")
+)
+
+// A Codec encodes and decodes tokens with a key.
+// It is safe for concurrent use, as long as Now is not modified.
+type Codec struct {
+	aead *deoxys.AEAD
+
+	// Now returns the current time. If nil, time.Now is used.
+	Now func() time.Time
+}
+
+// New returns a Codec with the given 16-byte key.
+func New(key []byte) (*Codec, error) {
+	if len(key) != 16 {
+		return nil, errors.New("token: wrong size key")
+	}
+	return &Codec{aead: deoxys.New(key)}, nil
+}
+
+func (c *Codec) now() time.Time {
+	if c.Now != nil {
+		return c.Now()
+	}
+	return time.Now()
+}
+
+// Encode returns a token for payload, timestamped with the current time.
+func (c *Codec) Encode(payload []byte) (string, error) {
+	var nonce [deoxys.NonceSize]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return "", err
+	}
+	return c.encode(payload, c.now(), nonce[:]), nil
+}
+
+func (c *Codec) encode(payload []byte, now time.Time, nonce []byte) string {
+	b := make([]byte, headerSize, headerSize+len(payload)+deoxys.TagSize)
+	b[0] = version
+	binary.BigEndian.PutUint64(b[1:], uint64(now.Unix()))
+	copy(b[9:], nonce)
+	b = c.aead.Seal(b, nonce, payload, b[:headerSize])
+	return new(big.Int).SetBytes(b).Text(62)
+}
+
+// Decode returns the payload of a token. If ttl is positive, tokens issued
+// more than ttl ago are rejected with ErrExpired; a ttl of zero disables
+// that check. Tokens timestamped more than MaxClockSkew in the future
+// are always rejected with ErrExpired.
+func (c *Codec) Decode(token string, ttl time.Duration) ([]byte, error) {
+	b, err := parse(token)
+	if err != nil {
+		return nil, err
+	}
+	header, ct := b[:headerSize], b[headerSize:]
+	payload, err := c.aead.Open(nil, header[9:], ct, header)
+	if err != nil {
+		return nil, ErrInvalid
+	}
+	// Only look at the timestamp once it has been authenticated.
+	ts := int64(binary.BigEndian.Uint64(header[1:]))
+	age := c.now().Unix() - ts
+	if age < -int64(MaxClockSkew/time.Second) || ttl > 0 && age > int64(ttl/time.Second) {
+		return nil, ErrExpired
+	}
+	return payload, nil
+}
+
+// parse decodes the base62 text of a token.
+// It accepts only the canonical encoding of a well-formed token.
+func parse(token string) ([]byte, error) {
+	// Bound the cost of the quadratic base conversion.
+	if len(token) == 0 || token[0] == '0' || len(token) > 64<<10 {
+		return nil, ErrInvalid
+	}
+	for i := 0; i < len(token); i++ {
+		c := token[i]
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z') {
+			return nil, ErrInvalid
+		}
+	}
+	n, ok := new(big.Int).SetString(token, 62)
+	if !ok {
+		return nil, ErrInvalid
+	}
+	b := n.Bytes()
+	if len(b) < headerSize+deoxys.TagSize || b[0] != version {
+		return nil, ErrInvalid
+	}
+	return b, nil
+}
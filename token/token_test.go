@@ -0,0 +1,146 @@
+package token
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var (
+	testKey  = []byte("0123456789abcdef")
+	testTime = time.Date(2024, 3, 14, 15, 9, 26, 0, time.UTC)
+)
+
+// testToken is a regression vector generated with this implementation:
+// "Hello, world!" issued at testTime with the nonce "fifteen-byte-no".
+const testToken = "1X8xdhtODti8fOxLGE3uesTT4UPZplkgifx9wFKVNzmMxgqqGsvvkWvLE9wVdwTRt9zW4cXp"
+
+func newCodec(t *testing.T, now time.Time) *Codec {
+	t.Helper()
+	c, err := New(testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Now = func() time.Time { return now }
+	return c
+}
+
+func TestVector(t *testing.T) {
+	c := newCodec(t, testTime)
+	if got := c.encode([]byte("Hello, world!"), testTime, []byte("fifteen-byte-no")); got != testToken {
+		t.Errorf("encode = %s, want %s", got, testToken)
+	}
+	got, err := c.Decode(testToken, time.Hour)
+	if err != nil || string(got) != "Hello, world!" {
+		t.Errorf("Decode = %q, %v", got, err)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	c := newCodec(t, testTime)
+	for _, payload := range [][]byte{nil, {0}, []byte("user=42"), bytes.Repeat([]byte{0xff}, 1000)} {
+		token, err := c.Encode(payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, r := range token {
+			if !('0' <= r && r <= '9' || 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z') {
+				t.Fatalf("token %q is not base62", token)
+			}
+		}
+		got, err := c.Decode(token, 0)
+		if err != nil || !bytes.Equal(got, payload) {
+			t.Errorf("Decode = %x, %v; want %x", got, err, payload)
+		}
+	}
+}
+
+func TestConcurrent(t *testing.T) {
+	c := newCodec(t, testTime)
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				payload := fmt.Sprintf("goroutine %d, payload %d", g, i)
+				token, err := c.Encode([]byte(payload))
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if got, err := c.Decode(token, time.Hour); err != nil || string(got) != payload {
+					t.Errorf("Decode = %q, %v; want %q", got, err, payload)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+}
+
+func TestTTL(t *testing.T) {
+	for _, tt := range []struct {
+		now time.Duration
+		ttl time.Duration
+		err error
+	}{
+		{0, time.Hour, nil},
+		{time.Hour, time.Hour, nil},
+		{time.Hour + time.Second, time.Hour, ErrExpired},
+		{1000 * time.Hour, 0, nil},
+		{-MaxClockSkew, time.Hour, nil},
+		{-MaxClockSkew - time.Second, time.Hour, ErrExpired},
+		{-MaxClockSkew - time.Second, 0, ErrExpired},
+	} {
+		c := newCodec(t, testTime.Add(tt.now))
+		if _, err := c.Decode(testToken, tt.ttl); err != tt.err {
+			t.Errorf("Decode at %v with ttl %v = %v, want %v", tt.now, tt.ttl, err, tt.err)
+		}
+	}
+}
+
+func TestInvalid(t *testing.T) {
+	c := newCodec(t, testTime)
+	b, err := parse(testToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The version byte is checked by parse; modify each of the others.
+	for i := 1; i < len(b); i++ {
+		b[i] ^= 1
+		token := new(big.Int).SetBytes(b).Text(62)
+		if _, err := c.Decode(token, 0); err != ErrInvalid {
+			t.Errorf("byte %d modified: Decode = %v, want ErrInvalid", i, err)
+		}
+		b[i] ^= 1
+	}
+	other, _ := New([]byte("fedcba9876543210"))
+	if _, err := other.Decode(testToken, 0); err != ErrInvalid {
+		t.Errorf("Decode with the wrong key = %v, want ErrInvalid", err)
+	}
+	for _, bad := range []string{
+		"",
+		"0" + testToken,
+		"-" + testToken,
+		"+" + testToken,
+		testToken + "_",
+		testToken[:len(testToken)-1],
+		"1X8xdhtODti8fO", // too short
+		strings.Repeat("z", 100),
+	} {
+		if _, err := c.Decode(bad, 0); err != ErrInvalid {
+			t.Errorf("Decode(%q) = %v, want ErrInvalid", bad, err)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	if _, err := New(testKey[:15]); err == nil {
+		t.Errorf("New accepted a short key")
+	}
+}
@@ -0,0 +1,414 @@
+// Package wal implements an encrypted append-only log file.
+//
+// A log file starts with a header
+//
+//	magic "DXWL" || version (1 byte) || file ID (7 bytes) || tag (16 bytes)
+//
+// where the tag authenticates the rest of the header, so that a wrong key
+// is detected before any record is read. It is followed by records of the form
+//
+//	length (4 bytes, big-endian) || crc32c of length (4 bytes) || ciphertext
+//
+// where the length counts the ciphertext, including its tag. The checksum
+// lets a damaged length be told apart from a torn write.
+// Record i is sealed with Deoxys-II under the nonce
+//
+//	file ID (7 bytes) || i (8 bytes, big-endian)
+//
+// with the file header and the record's frame as additional data.
+// The header tag uses the nonce for i = 2^64-1. The
+// sequence number in the nonce binds each record to its position, so
+// records cannot be reordered, dropped from the middle of the log, or
+// moved into another log file. The file ID is random; it only needs to
+// be unique among the logs that share a key.
+//
+// A crash while appending can leave a torn record at the end of the
+// file. Readers report it as ErrTruncated, and Open removes it before
+// appending. Damage anywhere else is reported as ErrCorrupt. Whole records
+// removed from the end of a log cannot be detected from the file alone:
+// callers that need that must keep the record count somewhere else.
+package wal
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"sync"
+
+	"github.com/magical/deoxys"
+)
+
+const (
+	magic      = "DXWL"
+	version    = 1
+	fileIDSize = deoxys.NonceSize - 8
+	prefixSize = len(magic) + 1 + fileIDSize
+	headerSize = prefixSize + deoxys.TagSize
+	frameSize  = 8
+	headerSeq  = math.MaxUint64
+
+	// MaxRecordSize is the largest record that can be appended.
+	MaxRecordSize = 16 << 20
+
+	// indexInterval is the number of records between
+	// the offsets that a Reader remembers for Seek.
+	indexInterval = 64
+)
+
+var (
+	// ErrTruncated is returned when a log ends with an incomplete record,
+	// as left by a crash during Append.
+	ErrTruncated = errors.New("wal: log ends with a torn record")
+
+	// ErrCorrupt is returned for a damaged or forged record
+	// that is not at the end of the log.
+	ErrCorrupt = errors.New("wal: corrupt record")
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// format holds what is needed to seal and open the records of a file.
+type format struct {
+	aead   *deoxys.AEAD
+	header [headerSize]byte
+}
+
+// newFormat returns the format for the header that starts with prefix.
+func newFormat(key []byte, prefix []byte) (*format, error) {
+	if len(key) != 16 {
+		return nil, errors.New("wal: wrong size key")
+	}
+	f := &format{aead: deoxys.New(key)}
+	copy(f.header[:], prefix)
+	f.aead.Seal(f.header[:prefixSize], f.nonce(headerSeq), nil, prefix)
+	return f, nil
+}
+
+func (f *format) nonce(seq uint64) []byte {
+	nonce := make([]byte, deoxys.NonceSize)
+	copy(nonce, f.header[len(magic)+1:])
+	binary.BigEndian.PutUint64(nonce[fileIDSize:], seq)
+	return nonce
+}
+
+func (f *format) additionalData(frame []byte) []byte {
+	return append(f.header[:], frame...)
+}
+
+// parseFrame returns the ciphertext length in a frame,
+// and whether its checksum is correct.
+func parseFrame(b []byte) (int64, bool) {
+	return int64(binary.BigEndian.Uint32(b)), crc32.Checksum(b[:4], castagnoli) == binary.BigEndian.Uint32(b[4:])
+}
+
+// seal returns the framed record for data at sequence number seq.
+func (f *format) seal(seq uint64, data []byte) []byte {
+	b := make([]byte, frameSize, frameSize+len(data)+deoxys.TagSize)
+	binary.BigEndian.PutUint32(b, uint32(len(data)+deoxys.TagSize))
+	binary.BigEndian.PutUint32(b[4:], crc32.Checksum(b[:4], castagnoli))
+	return f.aead.Seal(b, f.nonce(seq), data, f.additionalData(b[:frameSize]))
+}
+
+// A Reader reads the records of a log.
+type Reader struct {
+	r    io.ReaderAt
+	size int64
+	f    *format
+
+	seq uint64 // index of the next record
+	off int64  // offset of the next record
+
+	// index[k] is the offset of record k*indexInterval.
+	index []int64
+}
+
+// NewReader returns a Reader for the log in r, which is size bytes long.
+func NewReader(r io.ReaderAt, size int64, key []byte) (*Reader, error) {
+	header := make([]byte, headerSize)
+	if size < int64(headerSize) {
+		return nil, errors.New("wal: file too short")
+	}
+	if _, err := r.ReadAt(header, 0); err != nil {
+		return nil, err
+	}
+	if string(header[:len(magic)]) != magic {
+		return nil, errors.New("wal: not a log file")
+	}
+	if header[len(magic)] != version {
+		return nil, errors.New("wal: unsupported version")
+	}
+	f, err := newFormat(key, header[:prefixSize])
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.aead.Open(nil, f.nonce(headerSeq), header[prefixSize:], header[:prefixSize]); err != nil {
+		return nil, errors.New("wal: wrong key or corrupt header")
+	}
+	return &Reader{r: r, size: size, f: f, off: int64(headerSize), index: []int64{int64(headerSize)}}, nil
+}
+
+// Index returns the index of the record that Next will return.
+func (r *Reader) Index() uint64 { return r.seq }
+
+// Offset returns the offset just past the last record read,
+// which is where the next record starts.
+func (r *Reader) Offset() int64 { return r.off }
+
+// frame returns the length of the ciphertext of the record at off,
+// checking that the record lies within the file.
+func (r *Reader) frame(off int64) (int64, error) {
+	if off == r.size {
+		return 0, io.EOF
+	}
+	if r.size-off < frameSize {
+		return 0, r.damaged(off)
+	}
+	var b [frameSize]byte
+	if _, err := r.r.ReadAt(b[:], off); err != nil {
+		return 0, err
+	}
+	n, ok := parseFrame(b[:])
+	if !ok || n < deoxys.TagSize || n > MaxRecordSize+deoxys.TagSize || n > r.size-off-frameSize {
+		return 0, r.damaged(off)
+	}
+	return n, nil
+}
+
+// damaged returns the error for a bad record at off: ErrTruncated if it
+// could be the result of a torn write, and ErrCorrupt otherwise.
+// A torn write leaves a partial frame, a valid frame for a record
+// that runs to or past the end of the file, or zeros.
+func (r *Reader) damaged(off int64) error {
+	if r.size-off < frameSize {
+		return ErrTruncated
+	}
+	var b [frameSize]byte
+	if _, err := r.r.ReadAt(b[:], off); err != nil {
+		return err
+	}
+	if n, ok := parseFrame(b[:]); ok && n >= deoxys.TagSize && n <= MaxRecordSize+deoxys.TagSize && n >= r.size-off-frameSize {
+		return ErrTruncated
+	}
+	zero, err := allZero(io.NewSectionReader(r.r, off, r.size-off))
+	if err != nil {
+		return err
+	}
+	if zero {
+		return ErrTruncated
+	}
+	return ErrCorrupt
+}
+
+func allZero(r io.Reader) (bool, error) {
+	buf := make([]byte, 4096)
+	for {
+		n, err := r.Read(buf)
+		for _, c := range buf[:n] {
+			if c != 0 {
+				return false, nil
+			}
+		}
+		if err == io.EOF {
+			return true, nil
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+}
+
+// advance moves past a record of n bytes at r.off,
+// remembering its offset if it starts an index interval.
+func (r *Reader) advance(n int64) {
+	r.off += frameSize + n
+	r.seq++
+	if r.seq%indexInterval == 0 && r.seq/indexInterval == uint64(len(r.index)) {
+		r.index = append(r.index, r.off)
+	}
+}
+
+// Next returns the next record. At the end of the log it returns io.EOF,
+// or ErrTruncated if the log ends with a torn record.
+// The Reader does not advance past an error.
+func (r *Reader) Next() ([]byte, error) {
+	n, err := r.frame(r.off)
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, frameSize+n)
+	if _, err := r.r.ReadAt(b, r.off); err != nil {
+		return nil, err
+	}
+	data, err := r.f.aead.Open(b[frameSize:frameSize], r.f.nonce(r.seq), b[frameSize:], r.f.additionalData(b[:frameSize]))
+	if err != nil {
+		return nil, r.damaged(r.off)
+	}
+	r.advance(n)
+	return data, nil
+}
+
+// Seek positions the Reader so that Next returns the record with the
+// given index. Seeking to the number of records in the log positions
+// the Reader at the end. Seek reads only the length frames of the
+// records it skips; they are authenticated when they are read by Next.
+func (r *Reader) Seek(index uint64) error {
+	k := index / indexInterval
+	if k >= uint64(len(r.index)) {
+		k = uint64(len(r.index)) - 1
+	}
+	// Start from the remembered offset closest to index,
+	// unless the current position is closer.
+	if r.seq > index || r.seq < k*indexInterval {
+		r.seq, r.off = k*indexInterval, r.index[k]
+	}
+	for r.seq < index {
+		n, err := r.frame(r.off)
+		if err == io.EOF {
+			return errors.New("wal: seek past the end of the log")
+		}
+		if err != nil {
+			return err
+		}
+		r.advance(n)
+	}
+	return nil
+}
+
+// A Writer appends records to a log file.
+// It is safe for concurrent use.
+type Writer struct {
+	mu        sync.Mutex
+	file      *os.File
+	f         *format
+	seq       uint64
+	off       int64
+	discarded int64
+	err       error // sticky write error
+}
+
+// Create creates a new log file with a random file ID.
+// It fails if the file already exists.
+func Create(name string, key []byte) (*Writer, error) {
+	prefix := make([]byte, prefixSize)
+	copy(prefix, magic)
+	prefix[len(magic)] = version
+	if _, err := rand.Read(prefix[len(magic)+1:]); err != nil {
+		return nil, err
+	}
+	f, err := newFormat(key, prefix)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := file.Write(f.header[:]); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &Writer{file: file, f: f, off: int64(headerSize)}, nil
+}
+
+// Open opens an existing log file for appending. It reads and
+// authenticates every record, and removes a torn record from the end of
+// the file; see Discarded. It fails with ErrCorrupt if any other record
+// is damaged.
+func Open(name string, key []byte) (*Writer, error) {
+	file, err := os.OpenFile(name, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	w, err := recoverLog(file, key)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+func recoverLog(file *os.File, key []byte) (*Writer, error) {
+	fi, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	r, err := NewReader(file, fi.Size(), key)
+	if err != nil {
+		return nil, err
+	}
+	for err == nil {
+		_, err = r.Next()
+	}
+	w := &Writer{file: file, f: r.f, seq: r.Index(), off: r.Offset()}
+	switch err {
+	case io.EOF:
+	case ErrTruncated:
+		if err := file.Truncate(w.off); err != nil {
+			return nil, err
+		}
+		if err := file.Sync(); err != nil {
+			return nil, err
+		}
+		w.discarded = fi.Size() - w.off
+	default:
+		return nil, err
+	}
+	return w, nil
+}
+
+// Discarded returns the number of bytes of a torn record
+// that Open removed from the end of the file.
+func (w *Writer) Discarded() int64 { return w.discarded }
+
+// Append appends a record and returns its index.
+// The record is not durable until Sync returns.
+func (w *Writer) Append(data []byte) (uint64, error) {
+	if len(data) > MaxRecordSize {
+		return 0, errors.New("wal: record too large")
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return 0, w.err
+	}
+	b := w.f.seal(w.seq, data)
+	// After a failed write the end of the file is unknown,
+	// so all later appends fail too.
+	if _, err := w.file.WriteAt(b, w.off); err != nil {
+		w.err = err
+		return 0, err
+	}
+	index := w.seq
+	w.seq++
+	w.off += int64(len(b))
+	return index, nil
+}
+
+// Len returns the number of records in the log.
+func (w *Writer) Len() uint64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.seq
+}
+
+// Sync commits the appended records to stable storage.
+func (w *Writer) Sync() error {
+	return w.file.Sync()
+}
+
+// Close syncs and closes the file.
+func (w *Writer) Close() error {
+	err := w.file.Sync()
+	if err2 := w.file.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
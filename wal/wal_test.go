@@ -0,0 +1,255 @@
+package wal
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var testKey = []byte("0123456789abcdef")
+
+func record(i int) []byte {
+	return []byte(fmt.Sprintf("event %d: %s", i, bytes.Repeat([]byte{'x'}, i%7)))
+}
+
+// writeLog creates a log with n records and returns its path.
+func writeLog(t *testing.T, n int) string {
+	t.Helper()
+	name := filepath.Join(t.TempDir(), "log")
+	w, err := Create(name, testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		if index, err := w.Append(record(i)); err != nil || index != uint64(i) {
+			t.Fatalf("Append = %d, %v; want %d", index, err, i)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func openReader(t *testing.T, data []byte) *Reader {
+	t.Helper()
+	r, err := NewReader(bytes.NewReader(data), int64(len(data)), testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+// readAll reads records until an error, which it returns
+// along with the number of records read.
+func readAll(t *testing.T, r *Reader) (int, error) {
+	t.Helper()
+	for i := 0; ; i++ {
+		data, err := r.Next()
+		if err != nil {
+			return i, err
+		}
+		if !bytes.Equal(data, record(int(r.Index())-1)) {
+			t.Fatalf("record %d = %q, want %q", r.Index()-1, data, record(int(r.Index())-1))
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	const n = 200
+	name := writeLog(t, n)
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := readAll(t, openReader(t, data)); got != n || err != io.EOF {
+		t.Errorf("read %d records, %v; want %d, EOF", got, err, n)
+	}
+
+	// Reopen and keep appending.
+	w, err := Open(name, testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.Len() != n || w.Discarded() != 0 {
+		t.Errorf("Open: Len = %d, Discarded = %d; want %d, 0", w.Len(), w.Discarded(), n)
+	}
+	if index, err := w.Append(record(n)); err != nil || index != n {
+		t.Errorf("Append after Open = %d, %v; want %d", index, err, n)
+	}
+	w.Close()
+	data, _ = os.ReadFile(name)
+	if got, err := readAll(t, openReader(t, data)); got != n+1 || err != io.EOF {
+		t.Errorf("read %d records, %v; want %d, EOF", got, err, n+1)
+	}
+}
+
+func TestSeek(t *testing.T) {
+	const n = 300
+	data, _ := os.ReadFile(writeLog(t, n))
+	r := openReader(t, data)
+	for _, index := range []uint64{5, 0, 299, 64, 63, 65, 128, 200, 130, 1, 300, 100} {
+		if err := r.Seek(index); err != nil {
+			t.Fatalf("Seek(%d): %v", index, err)
+		}
+		got, err := r.Next()
+		if index == n {
+			if err != io.EOF {
+				t.Errorf("Next after Seek to the end = %v, want EOF", err)
+			}
+			continue
+		}
+		if err != nil || !bytes.Equal(got, record(int(index))) {
+			t.Errorf("Next after Seek(%d) = %q, %v", index, got, err)
+		}
+	}
+	if err := r.Seek(n + 1); err == nil {
+		t.Errorf("Seek past the end succeeded")
+	}
+	if len(r.index) != (n+indexInterval-1)/indexInterval {
+		t.Errorf("Reader remembers %d offsets, want %d", len(r.index), (n+indexInterval-1)/indexInterval)
+	}
+}
+
+// TestTornWrite simulates a crash at every point during the last Append.
+func TestTornWrite(t *testing.T) {
+	const n = 5
+	name := writeLog(t, n)
+	full, _ := os.ReadFile(name)
+	r := openReader(t, full)
+	r.Seek(n - 1)
+	last := r.Offset()
+
+	for size := last + 1; size < int64(len(full)); size++ {
+		torn := full[:size]
+		if got, err := readAll(t, openReader(t, torn)); got != n-1 || err != ErrTruncated {
+			t.Fatalf("size %d: read %d records, %v; want %d, ErrTruncated", size, got, err, n-1)
+		}
+		if err := os.WriteFile(name, torn, 0600); err != nil {
+			t.Fatal(err)
+		}
+		w, err := Open(name, testKey)
+		if err != nil {
+			t.Fatalf("size %d: Open: %v", size, err)
+		}
+		if w.Len() != n-1 || w.Discarded() != size-last {
+			t.Errorf("size %d: Len = %d, Discarded = %d; want %d, %d", size, w.Len(), w.Discarded(), n-1, size-last)
+		}
+		if _, err := w.Append(record(n - 1)); err != nil {
+			t.Fatal(err)
+		}
+		w.Close()
+		data, _ := os.ReadFile(name)
+		if got, err := readAll(t, openReader(t, data)); got != n || err != io.EOF {
+			t.Errorf("size %d: after repair, read %d records, %v; want %d, EOF", size, got, err, n)
+		}
+	}
+}
+
+// TestZeroTail simulates a crash that leaves zeroed blocks after the last record.
+func TestZeroTail(t *testing.T) {
+	full, _ := os.ReadFile(writeLog(t, 3))
+	for _, tail := range []int{1, 3, 4, 17, 4096} {
+		data := append(full[:len(full):len(full)], make([]byte, tail)...)
+		if got, err := readAll(t, openReader(t, data)); got != 3 || err != ErrTruncated {
+			t.Errorf("%d zeros: read %d records, %v; want 3, ErrTruncated", tail, got, err)
+		}
+	}
+}
+
+func TestCorrupt(t *testing.T) {
+	const n = 4
+	full, _ := os.ReadFile(writeLog(t, n))
+	r := openReader(t, full)
+	r.Seek(n - 1)
+	last := r.Offset()
+
+	// Damage to any record but the last is corruption.
+	for i := headerSize; i < int(last); i++ {
+		data := append([]byte(nil), full...)
+		data[i] ^= 0x80
+		_, err := readAll(t, openReader(t, data))
+		if err != ErrCorrupt {
+			t.Fatalf("byte %d modified: got %v, want ErrCorrupt", i, err)
+		}
+	}
+
+	// Damage to the header is detected before reading any record.
+	for i := 0; i < headerSize; i++ {
+		data := append([]byte(nil), full...)
+		data[i] ^= 1
+		if _, err := NewReader(bytes.NewReader(data), int64(len(data)), testKey); err == nil {
+			t.Errorf("header byte %d modified: NewReader succeeded", i)
+		}
+	}
+
+	// Records can't be moved between logs.
+	other, _ := os.ReadFile(writeLog(t, n))
+	data := append(append([]byte(nil), full[:last]...), other[last:]...)
+	if got, err := readAll(t, openReader(t, data)); got != n-1 || err == io.EOF {
+		t.Errorf("record from another log: read %d records, %v", got, err)
+	}
+
+	// Open refuses to truncate a damaged log.
+	name := filepath.Join(t.TempDir(), "log")
+	data = append([]byte(nil), full...)
+	data[headerSize+frameSize] ^= 1
+	os.WriteFile(name, data, 0600)
+	if _, err := Open(name, testKey); err != ErrCorrupt {
+		t.Errorf("Open of a corrupt log = %v, want ErrCorrupt", err)
+	}
+	if after, _ := os.ReadFile(name); !bytes.Equal(after, data) {
+		t.Errorf("Open modified a corrupt log")
+	}
+}
+
+func TestReorder(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "log")
+	w, _ := Create(name, testKey)
+	w.Append([]byte("aaaa"))
+	w.Append([]byte("bbbb"))
+	w.Append([]byte("cccc"))
+	w.Close()
+	full, _ := os.ReadFile(name)
+	size := frameSize + 4 + 16 // each record holds 4 bytes
+	first, second := full[headerSize:headerSize+size], full[headerSize+size:headerSize+2*size]
+	swapped := append(append(append(append([]byte(nil), full[:headerSize]...), second...), first...), full[headerSize+2*size:]...)
+	if _, err := openReader(t, swapped).Next(); err != ErrCorrupt {
+		t.Errorf("swapped records: Next = %v, want ErrCorrupt", err)
+	}
+	dropped := append(append([]byte(nil), full[:headerSize]...), full[headerSize+size:]...)
+	if _, err := openReader(t, dropped).Next(); err != ErrCorrupt {
+		t.Errorf("dropped record: Next = %v, want ErrCorrupt", err)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	name := writeLog(t, 1)
+	if _, err := Create(name, testKey); err == nil {
+		t.Errorf("Create overwrote an existing file")
+	}
+	before, _ := os.ReadFile(name)
+	if _, err := Open(name, []byte("fedcba9876543210")); err == nil {
+		t.Errorf("Open succeeded with the wrong key")
+	}
+	if after, _ := os.ReadFile(name); !bytes.Equal(after, before) {
+		t.Errorf("Open with the wrong key modified the log")
+	}
+	if _, err := Create(filepath.Join(t.TempDir(), "x"), testKey[:15]); err == nil {
+		t.Errorf("Create accepted a short key")
+	}
+	data, _ := os.ReadFile(name)
+	for _, bad := range [][]byte{nil, data[:headerSize-1], append([]byte("DXWX"), data[4:]...), append([]byte("DXWL\x02"), data[5:]...)} {
+		if _, err := NewReader(bytes.NewReader(bad), int64(len(bad)), testKey); err == nil {
+			t.Errorf("NewReader accepted %q", bad)
+		}
+	}
+	w, _ := Open(name, testKey)
+	defer w.Close()
+	if _, err := w.Append(make([]byte, MaxRecordSize+1)); err == nil {
+		t.Errorf("Append accepted an oversized record")
+	}
+}
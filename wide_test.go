@@ -0,0 +1,226 @@
+package deoxys
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"testing"
+)
+
+func TestWideCipher(t *testing.T) {
+	// Ciphertexts of seq(n) in sector 7 under the key seq(16), recorded
+	// from this package so that existing disk images stay readable.
+	// TestWideReference checks the construction they come from.
+	tests := []struct {
+		n          int
+		ciphertext string
+	}{
+		{16, "71046c2bb58d5ec88ae3c2630cf73be2"},
+		{20, "2feffba5a853e51c5110bd3d3ccaeb11000160e3"},
+		{48, "b24374943883e69e43bad39a1c6429133258bf99784a900446160bee817419b84ee18f548f43781ad77eaafe19cb16fc"},
+	}
+	c := NewWideCipher(seq(16))
+	for _, tt := range tests {
+		dst := make([]byte, tt.n)
+		c.EncryptSector(dst, seq(tt.n), 7)
+		if got := hex.EncodeToString(dst); got != tt.ciphertext {
+			t.Errorf("EncryptSector(seq(%d)) = %s, want %s", tt.n, got, tt.ciphertext)
+		}
+	}
+}
+
+func TestWideRoundTrip(t *testing.T) {
+	c := NewWideCipher([]byte("16-byte password"))
+	for _, n := range []int{16, 17, 31, 32, 33, 512, 4096, 4111} {
+		src := make([]byte, n)
+		for i := range src {
+			src[i] = uint8(i * 7)
+		}
+		ct := make([]byte, n)
+		c.EncryptSector(ct, src, 99)
+		pt := make([]byte, n)
+		c.DecryptSector(pt, ct, 99)
+		if !bytes.Equal(pt, src) {
+			t.Errorf("%d bytes: DecryptSector(EncryptSector(x)) != x", n)
+		}
+
+		buf := append([]byte(nil), src...)
+		c.EncryptSector(buf, buf, 99)
+		if !bytes.Equal(buf, ct) {
+			t.Errorf("%d bytes: in-place EncryptSector differs", n)
+		}
+		c.DecryptSector(buf, buf, 99)
+		if !bytes.Equal(buf, src) {
+			t.Errorf("%d bytes: in-place DecryptSector differs", n)
+		}
+	}
+}
+
+// hctr is the hash-encrypt-hash structure of HCTR2, with its
+// components supplied by the caller: the hashes h(1, R) and h(2, R),
+// the block cipher e and its inverse d, and the keystream ctr(S, n).
+type hctr struct {
+	h   func(pass uint8, r []byte) []byte
+	e   func(x []byte) []byte
+	d   func(y []byte) []byte
+	ctr func(s []byte, n int) []byte
+}
+
+func xorBytes(a, b []byte) []byte {
+	out := make([]byte, len(a))
+	for i := range a {
+		out[i] = a[i] ^ b[i]
+	}
+	return out
+}
+
+func (w hctr) encrypt(p []byte) []byte {
+	l, r := p[:16], p[16:]
+	x := xorBytes(l, w.h(1, r))
+	y := w.e(x)
+	r2 := xorBytes(r, w.ctr(xorBytes(x, y), len(r)))
+	l2 := xorBytes(y, w.h(2, r2))
+	return append(l2, r2...)
+}
+
+func (w hctr) decrypt(c []byte) []byte {
+	l2, r2 := c[:16], c[16:]
+	y := xorBytes(l2, w.h(2, r2))
+	x := w.d(y)
+	r := xorBytes(r2, w.ctr(xorBytes(x, y), len(r2)))
+	l := xorBytes(x, w.h(1, r))
+	return append(l, r...)
+}
+
+// wideReference builds the components of WideCipher
+// from Deoxys-BC as the WideCipher documentation describes them.
+func wideReference(key []byte, sector uint64) hctr {
+	subkey := make([][16]byte, numRounds)
+	expandKey(key, subkey)
+	enc := func(tweak, in []byte) []byte {
+		out := make([]byte, 16)
+		encryptBlock(subkey, tweak, in, out)
+		return out
+	}
+	tweak := func(domain, pass uint8, i uint64) []byte {
+		t := make([]byte, 16)
+		t[0], t[1] = domain, pass
+		binary.BigEndian.PutUint64(t[8:], i)
+		return t
+	}
+	blockTweak := make([]byte, 16)
+	blockTweak[0] = domainWideBlock
+	binary.BigEndian.PutUint64(blockTweak[1:9], sector)
+
+	return hctr{
+		h: func(pass uint8, r []byte) []byte {
+			sum := make([]byte, 16)
+			i := uint64(0)
+			for ; len(r) >= 16; i++ {
+				sum = xorBytes(sum, enc(tweak(domainWideHash, pass, i), r[:16]))
+				r = r[16:]
+			}
+			if len(r) > 0 {
+				last := make([]byte, 16)
+				copy(last, r)
+				last[len(r)] = 0x80
+				sum = xorBytes(sum, enc(tweak(domainWideHashPadded, pass, i), last))
+			}
+			return enc(tweak(domainWideFinal, pass, sector), sum)
+		},
+		e: func(x []byte) []byte { return enc(blockTweak, x) },
+		d: func(y []byte) []byte {
+			out := make([]byte, 16)
+			decryptBlock(subkey, blockTweak, y, out)
+			return out
+		},
+		ctr: func(s []byte, n int) []byte {
+			var ks []byte
+			for i := uint64(0); len(ks) < n; i++ {
+				ks = append(ks, enc(tweak(domainWideStream, 0, i), s)...)
+			}
+			return ks[:n]
+		},
+	}
+}
+
+func TestWideReference(t *testing.T) {
+	key := []byte("16-byte password")
+	const sector = 0x0102030405060708
+	ref := wideReference(key, sector)
+	c := NewWideCipher(key)
+	for _, n := range []int{16, 17, 31, 32, 33, 100, 512, 4111} {
+		src := make([]byte, n)
+		for i := range src {
+			src[i] = uint8(i*13 + 5)
+		}
+		want := ref.encrypt(src)
+		got := make([]byte, n)
+		c.EncryptSector(got, src, sector)
+		if !bytes.Equal(got, want) {
+			t.Errorf("%d bytes: EncryptSector = %x, want %x", n, got, want)
+		}
+		if pt := ref.decrypt(want); !bytes.Equal(pt, src) {
+			t.Errorf("%d bytes: reference decryption does not invert encryption", n)
+		}
+		c.DecryptSector(got, want, sector)
+		if !bytes.Equal(got, src) {
+			t.Errorf("%d bytes: DecryptSector = %x, want %x", n, got, src)
+		}
+
+		// Check the ciphertext against the equations directly:
+		// E(L ^ H_1(R)) = L' ^ H_2(R') and R ^ R' = CTR(X ^ Y).
+		x := xorBytes(src[:16], ref.h(1, src[16:]))
+		y := xorBytes(want[:16], ref.h(2, want[16:]))
+		if e := ref.e(x); !bytes.Equal(e, y) {
+			t.Errorf("%d bytes: E(L ^ H_1(R)) = %x, but L' ^ H_2(R') = %x", n, e, y)
+		}
+		if ks := xorBytes(src[16:], want[16:]); !bytes.Equal(ks, ref.ctr(xorBytes(x, y), n-16)) {
+			t.Errorf("%d bytes: R ^ R' is not the keystream CTR(X ^ Y)", n)
+		}
+	}
+}
+
+func TestWideDiffusion(t *testing.T) {
+	// Flipping one bit anywhere in the sector changes every block
+	// of the ciphertext, and likewise for decryption.
+	c := NewWideCipher([]byte("16-byte password"))
+	src := make([]byte, 512)
+	ct := make([]byte, len(src))
+	c.EncryptSector(ct, src, 0)
+	pt := make([]byte, len(src))
+	c.DecryptSector(pt, src, 0)
+
+	for _, pos := range []int{0, 15, 16, 300, 511} {
+		mod := append([]byte(nil), src...)
+		mod[pos] ^= 1
+		out := make([]byte, len(src))
+		c.EncryptSector(out, mod, 0)
+		for i := 0; i < len(out); i += blockSize {
+			if bytes.Equal(out[i:i+blockSize], ct[i:i+blockSize]) {
+				t.Errorf("encrypt: flipping byte %d left block %d unchanged", pos, i/blockSize)
+			}
+		}
+		c.DecryptSector(out, mod, 0)
+		for i := 0; i < len(out); i += blockSize {
+			if bytes.Equal(out[i:i+blockSize], pt[i:i+blockSize]) {
+				t.Errorf("decrypt: flipping byte %d left block %d unchanged", pos, i/blockSize)
+			}
+		}
+	}
+
+	other := make([]byte, len(src))
+	c.EncryptSector(other, src, 1)
+	if bytes.Equal(other[:blockSize], ct[:blockSize]) || bytes.Equal(other[blockSize:], ct[blockSize:]) {
+		t.Errorf("sectors 0 and 1 encrypt alike")
+	}
+}
+
+func BenchmarkWide4K(b *testing.B) {
+	c := NewWideCipher([]byte("16-byte password"))
+	buf := make([]byte, 4096)
+	b.SetBytes(int64(len(buf)))
+	for i := 0; i < b.N; i++ {
+		c.EncryptSector(buf, buf, uint64(i))
+	}
+}
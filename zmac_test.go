@@ -0,0 +1,99 @@
+package deoxys
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+// There are no published test vectors for ZMAC over Deoxys-BC;
+// these were generated with this implementation and guard against regressions.
+var zmacTestVectors = []struct {
+	n   int
+	mac string
+}{
+	{0, "5b86fffdeda265127e31471db0072ea8"},
+	{1, "4e3e5113dac81456c062a8acf57991e0"},
+	{30, "057a8ac4c805a18b777f8dd57f4aea03"},
+	{31, "34008067079f37937db78fbfc7c3e1c6"},
+	{32, "a14ed6a528c5d0449a77a223dc6b64ce"},
+	{62, "09a26a9a774bb4cab0c39904b8150345"},
+	{100, "8e9b770cc46d218b4654ec7cf16ef4b5"},
+}
+
+func TestZMAC(t *testing.T) {
+	key := []byte("16-byte password")
+	for _, tt := range zmacTestVectors {
+		h := NewZMAC(key)
+		h.Write(seq(tt.n))
+		got := hex.EncodeToString(h.Sum(nil))
+		if got != tt.mac {
+			t.Errorf("ZMAC(seq(%d)) = %s, want %s", tt.n, got, tt.mac)
+		}
+	}
+}
+
+func TestZMACStreaming(t *testing.T) {
+	key := []byte("16-byte password")
+	data := seq(200)
+	for n := 0; n <= len(data); n += 7 {
+		h := NewZMAC(key)
+		h.Write(data[:n])
+		want := h.Sum(nil)
+		for _, chunk := range []int{1, 16, 30, 31, 32} {
+			h.Reset()
+			for p := data[:n]; len(p) > 0; {
+				k := chunk
+				if k > len(p) {
+					k = len(p)
+				}
+				h.Write(p[:k])
+				p = p[k:]
+			}
+			if got := h.Sum(nil); !bytes.Equal(got, want) {
+				t.Errorf("ZMAC(%d bytes, chunk %d) = %x, want %x", n, chunk, got, want)
+			}
+		}
+	}
+}
+
+func TestZMACPadding(t *testing.T) {
+	// A full chunk that looks like padding must not collide
+	// with the padded empty message.
+	key := []byte("16-byte password")
+	h := NewZMAC(key)
+	s0 := h.Sum(nil)
+	pad := make([]byte, zmacChunk)
+	pad[0] = padByte
+	h.Write(pad)
+	s1 := h.Sum(nil)
+	if bytes.Equal(s0, s1) {
+		t.Errorf("ZMAC(\"\") == ZMAC(%x) = %x", pad, s0)
+	}
+}
+
+func TestDouble(t *testing.T) {
+	x := [16]byte{0x80}
+	double(&x)
+	want := [16]byte{15: 0x87}
+	if x != want {
+		t.Errorf("double(0x80...) = %x, want %x", x, want)
+	}
+	x = [16]byte{0x40, 15: 1}
+	double(&x)
+	want = [16]byte{0x80, 15: 2}
+	if x != want {
+		t.Errorf("double(0x40...01) = %x, want %x", x, want)
+	}
+}
+
+func BenchmarkZMAC(b *testing.B) {
+	h := NewZMAC([]byte("16-byte password"))
+	msg := make([]byte, 1024)
+	b.SetBytes(int64(len(msg)))
+	for i := 0; i < b.N; i++ {
+		h.Reset()
+		h.Write(msg)
+		h.Sum(nil)
+	}
+}